@@ -1,8 +1,450 @@
 package config
 
-const (
-	PORT = "9000"
-	DB_HOST = "root"
-	DB_PASS = "password"
-	DB_NAME = "example"
-)
\ No newline at end of file
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ENV_PREFIX is the prefix of every environment variable read by Load.
+const ENV_PREFIX = "INFINITI_"
+
+type Config struct {
+	ListenAddr string   `yaml:"listen_addr" toml:"listen_addr"`
+	SongsDir   string   `yaml:"songs_dir" toml:"songs_dir"`
+	BufferSize int      `yaml:"buffer_size" toml:"buffer_size"`
+	Database   Database `yaml:"database" toml:"database"`
+	Upload     Upload   `yaml:"upload" toml:"upload"`
+	Storage    Storage  `yaml:"storage" toml:"storage"`
+	Radio      Radio    `yaml:"radio" toml:"radio"`
+	HLS        HLS      `yaml:"hls" toml:"hls"`
+	Watch      Watch    `yaml:"watch" toml:"watch"`
+	Auth       Auth     `yaml:"auth" toml:"auth"`
+}
+
+type Database struct {
+	// Driver selects the database: "mysql", "postgres" or "sqlite".
+	Driver string `yaml:"driver" toml:"driver"`
+	Host   string `yaml:"host" toml:"host"`
+	// Port of the database server, 0 selects the default port of the driver.
+	Port     int    `yaml:"port" toml:"port"`
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	Name     string `yaml:"name" toml:"name"`
+	// SSLMode is passed on to postgres, e.g. "disable" or "require".
+	SSLMode string `yaml:"ssl_mode" toml:"ssl_mode"`
+	// Path is the database file used by sqlite, ":memory:" keeps the database in memory.
+	Path string `yaml:"path" toml:"path"`
+}
+
+type Upload struct {
+	// MaxFileSize is the largest song in bytes that can be uploaded, also for each song in an archive.
+	MaxFileSize int64 `yaml:"max_file_size" toml:"max_file_size"`
+	// MaxRequestSize is the largest upload request in bytes, with all of its files and archives.
+	MaxRequestSize int64 `yaml:"max_request_size" toml:"max_request_size"`
+	// MaxMultipartMemory is the amount of bytes of a multipart form kept in memory before spilling to disk.
+	MaxMultipartMemory int64 `yaml:"max_multipart_memory" toml:"max_multipart_memory"`
+	// ResumableDir holds the resumable uploads until they are complete. It must not be inside SongsDir when the songs are stored locally.
+	ResumableDir string `yaml:"resumable_dir" toml:"resumable_dir"`
+	// MaxResumableSize is the largest resumable upload in bytes, for the long songs and archives the others can't take.
+	MaxResumableSize int64 `yaml:"max_resumable_size" toml:"max_resumable_size"`
+	// ResumableExpiry is how long a resumable upload is kept after its last part arrived, in hours.
+	ResumableExpiry int `yaml:"resumable_expiry" toml:"resumable_expiry"`
+}
+
+type Radio struct {
+	// Name and Genre describe the stations to radio clients.
+	Name  string `yaml:"name" toml:"name"`
+	Genre string `yaml:"genre" toml:"genre"`
+}
+
+type HLS struct {
+	// TargetDuration is the longest an HLS segment may play, in seconds.
+	TargetDuration int `yaml:"target_duration" toml:"target_duration"`
+	// Window is the amount of segments listed in the live playlist of a station.
+	Window int `yaml:"window" toml:"window"`
+	// Retention is the amount of segments kept after dropping out of the window, for clients lagging behind.
+	Retention int `yaml:"retention" toml:"retention"`
+}
+
+type Watch struct {
+	// Enabled ingests songs added to, changed in or removed from the storage backend while running.
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Poll lists the storage backend every Interval instead of relying on filesystem events,
+	// which only the local backend on Linux gets. Other setups always poll.
+	Poll bool `yaml:"poll" toml:"poll"`
+	// Interval is the time between two listings when polling, in seconds.
+	Interval int `yaml:"interval" toml:"interval"`
+	// Debounce is how long a file must be left alone before it is ingested, in milliseconds.
+	Debounce int `yaml:"debounce" toml:"debounce"`
+}
+
+type Auth struct {
+	// Secret signs the session tokens. When empty a random one is made at start, so logins don't survive a restart.
+	Secret string `yaml:"secret" toml:"secret"`
+	// TokenExpiry is how long a session token is valid after logging in, in hours.
+	TokenExpiry int `yaml:"token_expiry" toml:"token_expiry"`
+	// Registration lets anyone create an account. The first account can always be created.
+	Registration bool `yaml:"registration" toml:"registration"`
+	// Anonymous is the role of requests sent without credentials: "listener", "uploader", "admin" or "none" to require logging in.
+	Anonymous string `yaml:"anonymous" toml:"anonymous"`
+}
+
+type Storage struct {
+	// Driver selects where the music library is stored: "local" (in SongsDir) or "s3".
+	Driver string `yaml:"driver" toml:"driver"`
+	S3     S3     `yaml:"s3" toml:"s3"`
+}
+
+type S3 struct {
+	Endpoint  string `yaml:"endpoint" toml:"endpoint"`
+	Region    string `yaml:"region" toml:"region"`
+	Bucket    string `yaml:"bucket" toml:"bucket"`
+	AccessKey string `yaml:"access_key" toml:"access_key"`
+	SecretKey string `yaml:"secret_key" toml:"secret_key"`
+	// Prefix is prepended to the key of every object, to share a bucket with other data.
+	Prefix string `yaml:"prefix" toml:"prefix"`
+	// PathStyle addresses the bucket in the path instead of the host name, as MinIO expects.
+	PathStyle bool `yaml:"path_style" toml:"path_style"`
+}
+
+// Default returns the configuration used when nothing has been overridden.
+func Default() *Config {
+	return &Config{
+		ListenAddr: "0.0.0.0:9000",
+		SongsDir:   "./resources/songs",
+		BufferSize: 16384,
+		Database: Database{
+			Driver:   "mysql",
+			Host:     "db",
+			User:     "root",
+			Password: "password",
+			Name:     "example",
+			SSLMode:  "disable",
+			Path:     "./infiniti.db",
+		},
+		Upload: Upload{
+			MaxFileSize:        64 << 20,
+			MaxRequestSize:     2 << 30,
+			MaxMultipartMemory: 8 << 20,
+			ResumableDir:       "./resources/uploads",
+			MaxResumableSize:   8 << 30,
+			ResumableExpiry:    24,
+		},
+		Radio: Radio{
+			Name: "Infiniti",
+		},
+		HLS: HLS{
+			TargetDuration: 6,
+			Window:         5,
+			Retention:      5,
+		},
+		Watch: Watch{
+			Interval: 10,
+			Debounce: 2000,
+		},
+		Auth: Auth{
+			TokenExpiry: 24,
+			Anonymous:   "listener",
+		},
+		Storage: Storage{
+			Driver: "local",
+			S3: S3{
+				Region: "us-east-1",
+			},
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, overridden by the optional
+// file at path (.yaml, .yml, .toml or .env) and then by INFINITI_* environment variables.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		err := cfg.loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	env := make(map[string]string)
+	for _, variable := range os.Environ() {
+		key, value, _ := strings.Cut(variable, "=")
+		if strings.HasPrefix(key, ENV_PREFIX) {
+			env[key] = value
+		}
+	}
+
+	err := cfg.applyEnv(env)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.SongsDir, err = filepath.Abs(cfg.SongsDir)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Upload.ResumableDir, err = filepath.Abs(cfg.Upload.ResumableDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate reports every invalid setting in the configuration.
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(cfg.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("listen_addr: %w", err))
+	}
+	if cfg.SongsDir == "" {
+		errs = append(errs, errors.New("songs_dir: must not be empty"))
+	}
+	if cfg.BufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("buffer_size: must be positive, got %d", cfg.BufferSize))
+	}
+	switch cfg.Database.Driver {
+	case "mysql", "postgres":
+		if cfg.Database.Host == "" {
+			errs = append(errs, errors.New("database.host: must not be empty"))
+		}
+		if cfg.Database.Port < 0 || cfg.Database.Port > 65535 {
+			errs = append(errs, fmt.Errorf("database.port: must be between 0 and 65535, got %d", cfg.Database.Port))
+		}
+		if cfg.Database.User == "" {
+			errs = append(errs, errors.New("database.user: must not be empty"))
+		}
+		if cfg.Database.Name == "" {
+			errs = append(errs, errors.New("database.name: must not be empty"))
+		}
+	case "sqlite":
+		if cfg.Database.Path == "" {
+			errs = append(errs, errors.New("database.path: must not be empty"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("database.driver: must be 'mysql', 'postgres' or 'sqlite', got '%s'", cfg.Database.Driver))
+	}
+	if cfg.Upload.MaxFileSize <= 0 {
+		errs = append(errs, fmt.Errorf("upload.max_file_size: must be positive, got %d", cfg.Upload.MaxFileSize))
+	}
+	if cfg.Upload.MaxRequestSize <= 0 {
+		errs = append(errs, fmt.Errorf("upload.max_request_size: must be positive, got %d", cfg.Upload.MaxRequestSize))
+	}
+	if cfg.Upload.MaxMultipartMemory <= 0 {
+		errs = append(errs, fmt.Errorf("upload.max_multipart_memory: must be positive, got %d", cfg.Upload.MaxMultipartMemory))
+	}
+	if cfg.Upload.ResumableDir == "" {
+		errs = append(errs, errors.New("upload.resumable_dir: must not be empty"))
+	} else if cfg.Storage.Driver == "local" && cfg.SongsDir != "" && inside(cfg.Upload.ResumableDir, cfg.SongsDir) {
+		errs = append(errs, fmt.Errorf("upload.resumable_dir: must not be inside songs_dir, got '%s'", cfg.Upload.ResumableDir))
+	}
+	if cfg.Upload.MaxResumableSize <= 0 {
+		errs = append(errs, fmt.Errorf("upload.max_resumable_size: must be positive, got %d", cfg.Upload.MaxResumableSize))
+	}
+	if cfg.Upload.ResumableExpiry <= 0 {
+		errs = append(errs, fmt.Errorf("upload.resumable_expiry: must be positive, got %d", cfg.Upload.ResumableExpiry))
+	}
+	if cfg.HLS.TargetDuration <= 0 {
+		errs = append(errs, fmt.Errorf("hls.target_duration: must be positive, got %d", cfg.HLS.TargetDuration))
+	}
+	if cfg.HLS.Window <= 0 {
+		errs = append(errs, fmt.Errorf("hls.window: must be positive, got %d", cfg.HLS.Window))
+	}
+	if cfg.HLS.Retention < 0 {
+		errs = append(errs, fmt.Errorf("hls.retention: must not be negative, got %d", cfg.HLS.Retention))
+	}
+	if cfg.Watch.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("watch.interval: must be positive, got %d", cfg.Watch.Interval))
+	}
+	if cfg.Watch.Debounce < 0 {
+		errs = append(errs, fmt.Errorf("watch.debounce: must not be negative, got %d", cfg.Watch.Debounce))
+	}
+	if cfg.Auth.Secret != "" && len(cfg.Auth.Secret) < 32 {
+		errs = append(errs, fmt.Errorf("auth.secret: must be at least 32 bytes, got %d", len(cfg.Auth.Secret)))
+	}
+	if cfg.Auth.TokenExpiry <= 0 {
+		errs = append(errs, fmt.Errorf("auth.token_expiry: must be positive, got %d", cfg.Auth.TokenExpiry))
+	}
+	switch cfg.Auth.Anonymous {
+	case "none", "listener", "uploader", "admin":
+	default:
+		errs = append(errs, fmt.Errorf("auth.anonymous: must be 'none', 'listener', 'uploader' or 'admin', got '%s'", cfg.Auth.Anonymous))
+	}
+	switch cfg.Storage.Driver {
+	case "local":
+	case "s3":
+		if cfg.Storage.S3.Endpoint == "" {
+			errs = append(errs, errors.New("storage.s3.endpoint: must not be empty"))
+		}
+		if cfg.Storage.S3.Bucket == "" {
+			errs = append(errs, errors.New("storage.s3.bucket: must not be empty"))
+		}
+		if cfg.Storage.S3.Region == "" {
+			errs = append(errs, errors.New("storage.s3.region: must not be empty"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage.driver: must be 'local' or 's3', got '%s'", cfg.Storage.Driver))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// inside reports whether dir is parent or one of the directories below it.
+func inside(dir string, parent string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	parent, err = filepath.Abs(parent)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(parent, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (cfg *Config) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, cfg)
+	case ".toml":
+		err = toml.Unmarshal(content, cfg)
+	case ".env":
+		var env map[string]string
+		env, err = godotenv.UnmarshalBytes(content)
+		if err == nil {
+			err = cfg.applyEnv(env)
+		}
+	default:
+		err = fmt.Errorf("unsupported file type '%s'", filepath.Ext(path))
+	}
+
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides settings with the INFINITI_* variables found in env.
+func (cfg *Config) applyEnv(env map[string]string) error {
+	texts := map[string]*string{
+		"LISTEN_ADDR": &cfg.ListenAddr,
+		"SONGS_DIR":   &cfg.SongsDir,
+		"DB_DRIVER":   &cfg.Database.Driver,
+		"DB_HOST":     &cfg.Database.Host,
+		"DB_USER":     &cfg.Database.User,
+		"DB_PASSWORD": &cfg.Database.Password,
+		"DB_NAME":     &cfg.Database.Name,
+		"DB_SSL_MODE": &cfg.Database.SSLMode,
+		"DB_PATH":     &cfg.Database.Path,
+
+		"UPLOAD_RESUMABLE_DIR": &cfg.Upload.ResumableDir,
+
+		"AUTH_SECRET":    &cfg.Auth.Secret,
+		"AUTH_ANONYMOUS": &cfg.Auth.Anonymous,
+
+		"RADIO_NAME":  &cfg.Radio.Name,
+		"RADIO_GENRE": &cfg.Radio.Genre,
+
+		"STORAGE_DRIVER":        &cfg.Storage.Driver,
+		"STORAGE_S3_ENDPOINT":   &cfg.Storage.S3.Endpoint,
+		"STORAGE_S3_REGION":     &cfg.Storage.S3.Region,
+		"STORAGE_S3_BUCKET":     &cfg.Storage.S3.Bucket,
+		"STORAGE_S3_ACCESS_KEY": &cfg.Storage.S3.AccessKey,
+		"STORAGE_S3_SECRET_KEY": &cfg.Storage.S3.SecretKey,
+		"STORAGE_S3_PREFIX":     &cfg.Storage.S3.Prefix,
+	}
+	ints := map[string]*int{
+		"BUFFER_SIZE": &cfg.BufferSize,
+		"DB_PORT":     &cfg.Database.Port,
+
+		"HLS_TARGET_DURATION": &cfg.HLS.TargetDuration,
+		"HLS_WINDOW":          &cfg.HLS.Window,
+		"HLS_RETENTION":       &cfg.HLS.Retention,
+
+		"UPLOAD_RESUMABLE_EXPIRY": &cfg.Upload.ResumableExpiry,
+
+		"AUTH_TOKEN_EXPIRY": &cfg.Auth.TokenExpiry,
+
+		"WATCH_INTERVAL": &cfg.Watch.Interval,
+		"WATCH_DEBOUNCE": &cfg.Watch.Debounce,
+	}
+	sizes := map[string]*int64{
+		"UPLOAD_MAX_FILE_SIZE":        &cfg.Upload.MaxFileSize,
+		"UPLOAD_MAX_REQUEST_SIZE":     &cfg.Upload.MaxRequestSize,
+		"UPLOAD_MAX_MULTIPART_MEMORY": &cfg.Upload.MaxMultipartMemory,
+		"UPLOAD_MAX_RESUMABLE_SIZE":   &cfg.Upload.MaxResumableSize,
+	}
+
+	bools := map[string]*bool{
+		"STORAGE_S3_PATH_STYLE": &cfg.Storage.S3.PathStyle,
+		"WATCH_ENABLED":         &cfg.Watch.Enabled,
+		"WATCH_POLL":            &cfg.Watch.Poll,
+		"AUTH_REGISTRATION":     &cfg.Auth.Registration,
+	}
+
+	var errs []error
+
+	for key, field := range texts {
+		if value, ok := env[ENV_PREFIX+key]; ok {
+			*field = value
+		}
+	}
+	for key, field := range ints {
+		if value, ok := env[ENV_PREFIX+key]; ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", ENV_PREFIX, key, err))
+				continue
+			}
+			*field = parsed
+		}
+	}
+	for key, field := range sizes {
+		if value, ok := env[ENV_PREFIX+key]; ok {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", ENV_PREFIX, key, err))
+				continue
+			}
+			*field = parsed
+		}
+	}
+	for key, field := range bools {
+		if value, ok := env[ENV_PREFIX+key]; ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", ENV_PREFIX, key, err))
+				continue
+			}
+			*field = parsed
+		}
+	}
+
+	return errors.Join(errs...)
+}
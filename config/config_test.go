@@ -0,0 +1,178 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Expected defaults to be valid; got %v", err)
+	}
+
+	if cfg.ListenAddr != Default().ListenAddr {
+		t.Errorf("Expected listen address %s; got %s", Default().ListenAddr, cfg.ListenAddr)
+	}
+	if !filepath.IsAbs(cfg.SongsDir) {
+		t.Errorf("Expected songs directory to be made absolute; got %s", cfg.SongsDir)
+	}
+	if !filepath.IsAbs(cfg.Upload.ResumableDir) {
+		t.Errorf("Expected resumable upload directory to be made absolute; got %s", cfg.Upload.ResumableDir)
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := writeFile(t, "infiniti.yaml", `
+listen_addr: "127.0.0.1:8080"
+database:
+  host: mariadb
+  port: 3307
+  password: secret
+upload:
+  max_file_size: 1024
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.ListenAddr != "127.0.0.1:8080" {
+		t.Errorf("Expected listen address from file; got %s", cfg.ListenAddr)
+	}
+	if cfg.Database.Host != "mariadb" || cfg.Database.Port != 3307 || cfg.Database.Password != "secret" {
+		t.Errorf("Expected database settings from file; got %+v", cfg.Database)
+	}
+	if cfg.Database.Name != Default().Database.Name {
+		t.Errorf("Expected unset settings to keep their default; got %s", cfg.Database.Name)
+	}
+	if cfg.Upload.MaxFileSize != 1024 {
+		t.Errorf("Expected max file size from file; got %d", cfg.Upload.MaxFileSize)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeFile(t, "infiniti.toml", `
+buffer_size = 4096
+
+[database]
+user = "infiniti"
+
+[hls]
+target_duration = 4
+
+[watch]
+enabled = true
+debounce = 500
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.BufferSize != 4096 || cfg.Database.User != "infiniti" || cfg.HLS.TargetDuration != 4 || !cfg.Watch.Enabled || cfg.Watch.Debounce != 500 {
+		t.Errorf("Expected settings from file; got %+v", cfg)
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	path := writeFile(t, "infiniti.env", "INFINITI_DB_PASSWORD=fromfile\nINFINITI_DB_PORT=5000\n")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Database.Password != "fromfile" || cfg.Database.Port != 5000 {
+		t.Errorf("Expected settings from .env file; got %+v", cfg.Database)
+	}
+}
+
+func TestEnvironmentOverridesFile(t *testing.T) {
+	path := writeFile(t, "infiniti.yaml", "database:\n  password: fromfile\n  name: fromfile\n")
+	t.Setenv("INFINITI_DB_PASSWORD", "fromenv")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Database.Password != "fromenv" {
+		t.Errorf("Expected environment to take precedence over file; got %s", cfg.Database.Password)
+	}
+	if cfg.Database.Name != "fromfile" {
+		t.Errorf("Expected file to take precedence over defaults; got %s", cfg.Database.Name)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := map[string]string{
+		"INFINITI_DB_PORT":                 "abc",
+		"INFINITI_BUFFER_SIZE":             "0",
+		"INFINITI_LISTEN_ADDR":             "9000",
+		"INFINITI_DB_HOST":                 "",
+		"INFINITI_UPLOAD_MAX_FILE_SIZE":    "-1",
+		"INFINITI_UPLOAD_MAX_REQUEST_SIZE": "0",
+		"INFINITI_UPLOAD_RESUMABLE_EXPIRY": "0",
+		"INFINITI_UPLOAD_RESUMABLE_DIR":    "./resources/songs/uploads",
+		"INFINITI_HLS_WINDOW":              "0",
+		"INFINITI_HLS_RETENTION":           "-1",
+		"INFINITI_WATCH_INTERVAL":          "0",
+		"INFINITI_WATCH_ENABLED":           "sometimes",
+		"INFINITI_AUTH_SECRET":             "short",
+		"INFINITI_AUTH_TOKEN_EXPIRY":       "0",
+		"INFINITI_AUTH_ANONYMOUS":          "guest",
+	}
+
+	for key, value := range tests {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+
+			_, err := Load("")
+			if err == nil {
+				t.Errorf("Expected an error for %s=%q", key, value)
+			}
+		})
+	}
+}
+
+func TestLoadUnsupportedFile(t *testing.T) {
+	path := writeFile(t, "infiniti.ini", "")
+
+	_, err := Load(path)
+	if err == nil {
+		t.Errorf("Expected an error for an unsupported config file")
+	}
+}
+
+func TestLoadSQLite(t *testing.T) {
+	t.Setenv("INFINITI_DB_DRIVER", "sqlite")
+	t.Setenv("INFINITI_DB_HOST", "")
+	t.Setenv("INFINITI_DB_PATH", ":memory:")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Expected sqlite to not need a database server; got %v", err)
+	}
+	if cfg.Database.Path != ":memory:" {
+		t.Errorf("Expected database path from environment; got %s", cfg.Database.Path)
+	}
+
+	t.Setenv("INFINITI_DB_DRIVER", "oracle")
+	_, err = Load("")
+	if err == nil {
+		t.Errorf("Expected an error for an unknown database driver")
+	}
+}
@@ -0,0 +1,254 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"infiniti.com/internal/auth"
+	"infiniti.com/internal/database"
+	model "infiniti.com/model"
+)
+
+// USERKEY is the key of the authenticated user in the context of a request.
+const USERKEY = "user"
+
+// ROLEKEY is the key of the role of the request in its context, which is the
+// role of its user or the anonymous role.
+const ROLEKEY = "role"
+
+// AuthController registers and logs in users, manages their API keys and
+// checks who sent the requests that need a user.
+type AuthController struct {
+	auth *auth.Authenticator
+}
+
+// Credentials are the username and password sent to register or log in.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Session is the response to logging in, with the token to send in the Authorization header.
+type Session struct {
+	Token   string     `json:"token"`
+	Expires time.Time  `json:"expires"`
+	User    model.User `json:"user"`
+}
+
+// NewAPIKey is the response to creating an API key, the only time the key is shown.
+type NewAPIKey struct {
+	Key    string       `json:"key"`
+	APIKey model.APIKey `json:"api_key"`
+}
+
+func NewAuthController(a *auth.Authenticator) *AuthController {
+	return &AuthController{auth: a}
+}
+
+// Authenticate lets through requests sent by a user, who is told by a session
+// token or API key in the Authorization header as "Bearer <token>", or by an
+// API key in the X-API-Key header. Other requests are refused.
+func (ac *AuthController) Authenticate(c *gin.Context) {
+	user, ok := ac.authenticate(c)
+	if !ok {
+		return
+	}
+	if user == nil {
+		challenge(c)
+		return
+	}
+
+	c.Set(USERKEY, user)
+	c.Set(ROLEKEY, user.Role)
+	c.Next()
+}
+
+// Require returns a middleware that lets through requests whose role has the
+// permission, which is the role of their user or the anonymous role for
+// requests without credentials. Anonymous requests without the permission are
+// asked to authenticate, users without it are forbidden.
+func (ac *AuthController) Require(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := ac.authenticate(c)
+		if !ok {
+			return
+		}
+
+		role := ac.auth.Anonymous()
+		if user != nil {
+			role = user.Role
+		}
+		if !auth.Can(role, permission) {
+			if user == nil {
+				challenge(c)
+				return
+			}
+			respondErrorDetails(c, http.StatusForbidden, fmt.Sprintf("the %s role lacks the %s permission", role, permission), gin.H{"role": role, "permission": permission})
+			return
+		}
+
+		if user != nil {
+			c.Set(USERKEY, user)
+		}
+		c.Set(ROLEKEY, role)
+		c.Next()
+	}
+}
+
+// Register creates an account. Only the first one can be created, unless registration is open.
+func (ac *AuthController) Register(c *gin.Context) {
+	var credentials Credentials
+	err := c.ShouldBindJSON(&credentials)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, "invalid credentials", gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := ac.auth.Register(credentials.Username, credentials.Password)
+	switch {
+	case errors.Is(err, auth.ErrInvalidUsername), errors.Is(err, auth.ErrInvalidPassword):
+		respondError(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, auth.ErrRegistrationClosed):
+		respondError(c, http.StatusForbidden, err.Error())
+	case errors.Is(err, database.ErrUserExists):
+		respondError(c, http.StatusConflict, err.Error())
+	case err != nil:
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not register")
+	default:
+		c.IndentedJSON(http.StatusCreated, user)
+	}
+}
+
+// Login responds with a session token for the user whose credentials were sent.
+func (ac *AuthController) Login(c *gin.Context) {
+	var credentials Credentials
+	err := c.ShouldBindJSON(&credentials)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, "invalid credentials", gin.H{"error": err.Error()})
+		return
+	}
+
+	token, expires, user, err := ac.auth.Login(credentials.Username, credentials.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		respondError(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not log in")
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, Session{Token: token, Expires: expires, User: *user})
+}
+
+// Me responds with the user who sent the request.
+func (ac *AuthController) Me(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, currentUser(c))
+}
+
+// GetAPIKeys responds with the API keys of the user who sent the request, without the keys themselves.
+func (ac *AuthController) GetAPIKeys(c *gin.Context) {
+	keys, err := ac.auth.Keys(currentUser(c))
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not list api keys")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey creates an API key for the user who sent the request, named by the name in the body.
+func (ac *AuthController) CreateAPIKey(c *gin.Context) {
+	var request struct {
+		Name string `json:"name"`
+	}
+	err := c.ShouldBindJSON(&request)
+	if err != nil || strings.TrimSpace(request.Name) == "" {
+		respondError(c, http.StatusBadRequest, "an api key needs a name")
+		return
+	}
+
+	secret, key, err := ac.auth.CreateKey(currentUser(c), request.Name)
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not create api key")
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, NewAPIKey{Key: secret, APIKey: *key})
+}
+
+// DeleteAPIKey deletes an API key of the user who sent the request.
+func (ac *AuthController) DeleteAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err == nil {
+		err = ac.auth.DeleteKey(currentUser(c), uint(id))
+	}
+
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, database.ErrKeyNotFound), errors.Is(err, strconv.ErrSyntax), errors.Is(err, strconv.ErrRange):
+		respondError(c, http.StatusNotFound, "api key not found")
+	default:
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not delete api key")
+	}
+}
+
+/**
+	Private functions
+**/
+
+// authenticate returns the user whose credentials the request has, nil if it
+// has none. Requests with credentials that aren't valid are refused.
+func (ac *AuthController) authenticate(c *gin.Context) (*model.User, bool) {
+	credential := c.GetHeader("X-API-Key")
+	if scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		credential = strings.TrimSpace(token)
+	}
+	if credential == "" {
+		return nil, true
+	}
+
+	user, err := ac.auth.Authenticate(credential)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		c.Header("WWW-Authenticate", `Bearer realm="infiniti", error="invalid_token"`)
+		respondError(c, http.StatusUnauthorized, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not authenticate")
+		return nil, false
+	}
+	return user, true
+}
+
+// challenge refuses the request for having no credentials.
+func challenge(c *gin.Context) {
+	c.Header("WWW-Authenticate", `Bearer realm="infiniti"`)
+	respondError(c, http.StatusUnauthorized, "authentication required")
+}
+
+// currentUser returns the user Authenticate or Require found for the request, nil for anonymous requests.
+func currentUser(c *gin.Context) *model.User {
+	user, _ := c.Get(USERKEY)
+	u, _ := user.(*model.User)
+	return u
+}
+
+// currentUserID returns the id of the user who sent the request, 0 for anonymous requests.
+func currentUserID(c *gin.Context) uint {
+	if user := currentUser(c); user != nil {
+		return user.ID
+	}
+	return 0
+}
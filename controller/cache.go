@@ -0,0 +1,77 @@
+package controller
+
+import "sync"
+
+// cache keeps the most recently used values that are expensive to load, like
+// the segments of a song, so requests for them don't load them again.
+type cache[T any] struct {
+	values map[string]T
+	// order holds the keys from the least to the most recently used
+	order []string
+	size  int
+	// loading holds the loads in progress, which requests for the same key wait for
+	loading map[string]*load[T]
+	mu      sync.Mutex
+}
+
+// load is a value being loaded, done once it is.
+type load[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+func newCache[T any](size int) *cache[T] {
+	return &cache[T]{values: make(map[string]T), size: size, loading: make(map[string]*load[T])}
+}
+
+// get returns the cached value for key, loading it if it isn't cached yet. The
+// value is loaded once for the requests that ask for it at the same time, and
+// without keeping those for other keys waiting.
+func (ca *cache[T]) get(key string, loader func() (T, error)) (T, error) {
+	ca.mu.Lock()
+	value, ok := ca.values[key]
+	if ok {
+		ca.touch(key)
+		ca.mu.Unlock()
+		return value, nil
+	}
+	if l, ok := ca.loading[key]; ok {
+		ca.mu.Unlock()
+		<-l.done
+		return l.value, l.err
+	}
+	l := &load[T]{done: make(chan struct{})}
+	ca.loading[key] = l
+	ca.mu.Unlock()
+
+	// the waiting requests are let go once the value is cached, so no request loads it again in between
+	defer close(l.done)
+	l.value, l.err = loader()
+
+	defer ca.mu.Unlock()
+	ca.mu.Lock()
+
+	delete(ca.loading, key)
+	if l.err != nil {
+		return l.value, l.err
+	}
+
+	ca.values[key] = l.value
+	ca.order = append(ca.order, key)
+	if len(ca.order) > ca.size {
+		delete(ca.values, ca.order[0])
+		ca.order = ca.order[1:]
+	}
+
+	return l.value, nil
+}
+
+func (ca *cache[T]) touch(key string) {
+	for i, cached := range ca.order {
+		if cached == key {
+			ca.order = append(append(ca.order[:i:i], ca.order[i+1:]...), key)
+			return
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	_ "image/gif"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	"infiniti.com/internal/database"
+	"infiniti.com/internal/storage"
+)
+
+// COVERCACHE is the amount of cover images, original or resized, kept in memory.
+const COVERCACHE = 64
+
+// MINCOVERSIZE and MAXCOVERSIZE bound the sizes covers can be resized to, in pixels.
+const (
+	MINCOVERSIZE = 16
+	MAXCOVERSIZE = 2048
+)
+
+// coverImage is a cover image ready to be served.
+type coverImage struct {
+	data        []byte
+	contentType string
+}
+
+// serveCover responds with the cover stored under hash. With ?size= it is
+// scaled down to fit a square of that many pixels, keeping its aspect ratio.
+// Covers never change under a hash, so clients may cache them for a day and
+// revalidate with their ETag afterwards.
+func serveCover(c *gin.Context, store storage.Backend, covers *cache[coverImage], hash string) {
+	if hash == "" {
+		respondError(c, http.StatusNotFound, "cover not found")
+		return
+	}
+
+	size := 0
+	if param, ok := c.GetQuery("size"); ok {
+		var err error
+		size, err = strconv.Atoi(param)
+		if err != nil || size < MINCOVERSIZE || size > MAXCOVERSIZE {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("size must be between %d and %d", MINCOVERSIZE, MAXCOVERSIZE))
+			return
+		}
+	}
+
+	key := hash
+	if size > 0 {
+		key = fmt.Sprintf("%s-%d", hash, size)
+	}
+
+	cover, err := covers.get(key, func() (coverImage, error) {
+		return loadCover(store, hash, size)
+	})
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not load cover")
+		return
+	}
+
+	c.Header("Content-Type", cover.contentType)
+	c.Header("ETag", `"`+key+`"`)
+	c.Header("Cache-Control", "public, max-age=86400")
+
+	// ServeContent answers If-None-Match with 304 Not Modified
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, bytes.NewReader(cover.data))
+}
+
+// loadCover reads the cover stored under hash, resized to size unless it is 0.
+func loadCover(store storage.Backend, hash string, size int) (coverImage, error) {
+	file, err := store.Open(database.CoverPath(hash))
+	if err != nil {
+		return coverImage{}, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return coverImage{}, err
+	}
+
+	original := coverImage{data: data, contentType: http.DetectContentType(data)}
+	if size == 0 {
+		return original, nil
+	}
+
+	return resizeCover(original, size)
+}
+
+// resizeCover scales the cover down to fit a square of size pixels. Covers
+// that already fit are left alone, as they would only get blurry.
+func resizeCover(cover coverImage, size int) (coverImage, error) {
+	src, format, err := image.Decode(bytes.NewReader(cover.data))
+	if err != nil {
+		return coverImage{}, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return cover, nil
+	}
+
+	if width >= height {
+		width, height = size, max(1, height*size/width)
+	} else {
+		width, height = max(1, width*size/height), size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	// PNG keeps transparency, everything else becomes a JPEG
+	var buffer bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&buffer, dst)
+		cover.contentType = "image/png"
+	} else {
+		err = jpeg.Encode(&buffer, dst, &jpeg.Options{Quality: 85})
+		cover.contentType = "image/jpeg"
+	}
+	if err != nil {
+		return coverImage{}, err
+	}
+
+	cover.data = buffer.Bytes()
+	return cover, nil
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIError is the body of every error response. Clients tell errors apart by
+// their code, the message is meant for people and the details, if any, say
+// more about what went wrong.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// errorCodes are the codes of the errors responded with each status.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "unprocessable",
+	http.StatusLocked:                "locked",
+	http.StatusInternalServerError:   "internal_error",
+}
+
+// RouteNotFound responds to requests for paths without a route.
+func RouteNotFound(c *gin.Context) {
+	respondError(c, http.StatusNotFound, "route not found")
+}
+
+// Recover responds to requests whose handler panicked.
+func Recover(c *gin.Context, err any) {
+	log.Println("Recovered from panic:", err)
+	respondError(c, http.StatusInternalServerError, "internal server error")
+}
+
+// respondError ends the request with an error of the status.
+func respondError(c *gin.Context, status int, message string) {
+	respondErrorDetails(c, status, message, nil)
+}
+
+// respondErrorDetails ends the request with an error of the status, saying more about it in details.
+func respondErrorDetails(c *gin.Context, status int, message string, details any) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = "error"
+	}
+
+	c.Abort()
+	c.IndentedJSON(status, APIError{Code: code, Message: message, Details: details})
+}
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"infiniti.com/config"
+	"infiniti.com/internal/audiopipeline"
+)
+
+// PLAYLIST is the file name of a media playlist, next to its segments named <sequence>.mp3.
+const PLAYLIST = "index.m3u8"
+
+// HLSCACHE is the amount of segmented songs kept in memory for their VOD playlists.
+const HLSCACHE = 8
+
+func newSegmenter(cfg config.HLS, live bool) *audiopipeline.Segmenter {
+	target := time.Duration(cfg.TargetDuration) * time.Second
+	if !live {
+		return audiopipeline.NewSegmenter(target, 0, 0)
+	}
+	return audiopipeline.NewSegmenter(target, cfg.Window, cfg.Retention)
+}
+
+// serveHLS responds with the media playlist or the segment named by the file parameter.
+func serveHLS(c *gin.Context, segmenter *audiopipeline.Segmenter, live bool) {
+	file := c.Param("file")
+
+	if file == PLAYLIST {
+		c.Header("Content-Type", "application/vnd.apple.mpegurl")
+		if live {
+			// live playlists change with every segment
+			c.Header("Cache-Control", "no-cache")
+		}
+		c.Status(http.StatusOK)
+
+		audiopipeline.WritePlaylist(c.Writer, segmenter.Window(), segmenter.Target(), live, func(sequence uint64) string {
+			return fmt.Sprintf("%d.mp3", sequence)
+		})
+		return
+	}
+
+	name, ok := strings.CutSuffix(file, ".mp3")
+	sequence, err := strconv.ParseUint(name, 10, 64)
+	if !ok || err != nil {
+		respondError(c, http.StatusNotFound, "segment not found")
+		return
+	}
+
+	segment, ok := segmenter.Segment(sequence)
+	if !ok {
+		respondError(c, http.StatusNotFound, "segment not found")
+		return
+	}
+
+	c.Data(http.StatusOK, "audio/mpeg", segment.Data)
+}
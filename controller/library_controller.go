@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"infiniti.com/internal/database"
+	"infiniti.com/internal/storage"
+	model "infiniti.com/model"
+)
+
+// LibraryController browses the library by artist and album.
+type LibraryController struct {
+	library database.LibraryRepository
+	store   storage.Backend
+
+	// covers keeps recently requested cover images.
+	covers *cache[coverImage]
+}
+
+// AlbumTrack is a song on an album along with the artists it features.
+type AlbumTrack struct {
+	model.Song
+	Featured []model.Artist `json:"featured"`
+}
+
+func NewLibraryController(library database.LibraryRepository, store storage.Backend) *LibraryController {
+	return &LibraryController{
+		library: library,
+		store:   store,
+		covers:  newCache[coverImage](COVERCACHE),
+	}
+}
+
+func (lc *LibraryController) GetArtists(c *gin.Context) {
+	artists, err := lc.library.Artists()
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not list artists")
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, artists)
+}
+
+func (lc *LibraryController) GetArtistAlbums(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err == nil {
+		_, err = lc.library.GetArtist(uint(id))
+	}
+	if err != nil {
+		respondError(c, http.StatusNotFound, "artist not found")
+		return
+	}
+
+	albums, err := lc.library.ArtistAlbums(uint(id))
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not list albums")
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, albums)
+}
+
+func (lc *LibraryController) GetAlbums(c *gin.Context) {
+	albums, err := lc.library.Albums()
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not list albums")
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, albums)
+}
+
+// GetAlbumTracks lists the songs on an album in play order, by disc and track number.
+func (lc *LibraryController) GetAlbumTracks(c *gin.Context) {
+	album, ok := lc.getAlbum(c)
+	if !ok {
+		return
+	}
+
+	songs, err := lc.library.AlbumTracks(album.ID)
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not list tracks")
+		return
+	}
+
+	tracks := make([]AlbumTrack, len(songs))
+	for i, song := range songs {
+		featured, err := lc.library.FeaturedArtists(song.ID)
+		if err != nil {
+			log.Println(err)
+			respondError(c, http.StatusInternalServerError, "could not list tracks")
+			return
+		}
+		tracks[i] = AlbumTrack{Song: song, Featured: featured}
+	}
+
+	c.IndentedJSON(http.StatusOK, tracks)
+}
+
+// AlbumCover serves the album's cover image, resized to fit ?size= pixels if given.
+func (lc *LibraryController) AlbumCover(c *gin.Context) {
+	album, ok := lc.getAlbum(c)
+	if !ok {
+		return
+	}
+
+	serveCover(c, lc.store, lc.covers, album.CoverHash)
+}
+
+/**
+	Private functions
+**/
+
+// getAlbum looks up the album named in the path, responding with 404 if there is none.
+func (lc *LibraryController) getAlbum(c *gin.Context) (*model.Album, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		respondError(c, http.StatusNotFound, "album not found")
+		return nil, false
+	}
+
+	album, err := lc.library.GetAlbum(uint(id))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "album not found")
+		return nil, false
+	}
+	return album, true
+}
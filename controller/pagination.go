@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"infiniti.com/internal/database"
+	model "infiniti.com/model"
+)
+
+// DEFAULTLIMIT is the amount of songs in a page when the request doesn't say.
+const DEFAULTLIMIT = 50
+
+// MAXLIMIT is the most songs a page may have.
+const MAXLIMIT = 500
+
+// songFields maps the fields of a song, lowercase and without underscores, to their name in its JSON.
+var songFields = func() map[string]string {
+	fields := make(map[string]string)
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(model.Song{})) {
+		fields[strings.ToLower(field.Name)] = field.Name
+	}
+	return fields
+}()
+
+// queryError is a query parameter that can't be used, responded with as the details of the error.
+type queryError struct {
+	Parameter string `json:"parameter"`
+	Error     string `json:"error"`
+}
+
+// songQuery reads the filters, order and page of a song listing from the
+// query string, along with the fields to respond with, nil for all of them.
+func songQuery(c *gin.Context) (database.SongQuery, []string, *queryError) {
+	query := database.SongQuery{
+		Artist: c.Query("artist"),
+		Album:  c.Query("album"),
+		Genre:  c.Query("genre"),
+	}
+	for _, fileType := range strings.Split(c.Query("file_type"), ",") {
+		if fileType = strings.TrimSpace(fileType); fileType != "" {
+			query.FileTypes = append(query.FileTypes, fileType)
+		}
+	}
+
+	var err error
+	if value := c.Query("min_duration"); value != "" {
+		query.MinDuration, err = strconv.ParseFloat(value, 64)
+		if err != nil || query.MinDuration < 0 {
+			return query, nil, &queryError{"min_duration", "must be a number of seconds"}
+		}
+	}
+	if value := c.Query("max_duration"); value != "" {
+		query.MaxDuration, err = strconv.ParseFloat(value, 64)
+		if err != nil || query.MaxDuration <= 0 {
+			return query, nil, &queryError{"max_duration", "must be a positive number of seconds"}
+		}
+	}
+
+	// a leading dash sorts descending, as in "-duration"
+	query.Sort, query.Desc = strings.CutPrefix(c.Query("sort"), "-")
+
+	var invalid *queryError
+	query.Limit, query.Offset, invalid = pageParams(c)
+	if invalid != nil {
+		return query, nil, invalid
+	}
+	query.Cursor = c.Query("cursor")
+	if query.Cursor != "" && query.Offset > 0 {
+		return query, nil, &queryError{"cursor", "cannot be combined with offset"}
+	}
+
+	var fields []string
+	for _, name := range strings.Split(c.Query("fields"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		field, ok := songFields[strings.ToLower(strings.ReplaceAll(name, "_", ""))]
+		if !ok {
+			return query, nil, &queryError{"fields", fmt.Sprintf("songs have no field '%s'", name)}
+		}
+		fields = append(fields, field)
+	}
+
+	return query, fields, nil
+}
+
+// pageParams reads the limit and offset of a page from the query string.
+func pageParams(c *gin.Context) (int, int, *queryError) {
+	limit, offset := DEFAULTLIMIT, 0
+	var err error
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MAXLIMIT {
+			return limit, offset, &queryError{"limit", fmt.Sprintf("must be between 1 and %d", MAXLIMIT)}
+		}
+	}
+	if value := c.Query("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return limit, offset, &queryError{"offset", "must not be negative"}
+		}
+	}
+	return limit, offset, nil
+}
+
+// pageLinks returns the Link header of a page of songs: the first page and
+// the next one if there is one, through cursors if the request used one and
+// through offsets otherwise, along with the previous and last page then.
+func pageLinks(c *gin.Context, query database.SongQuery, songs []model.Song, total int64, more bool) []string {
+	link := func(rel string, set map[string]string) string {
+		target := *c.Request.URL
+		values := target.Query()
+		for key, value := range set {
+			values.Set(key, value)
+		}
+		target.RawQuery = values.Encode()
+		return fmt.Sprintf("<%s>; rel=\"%s\"", target.RequestURI(), rel)
+	}
+	limit := strconv.Itoa(query.Limit)
+
+	if _, ok := c.GetQuery("cursor"); ok {
+		// an empty cursor starts from the beginning, in cursor mode still
+		links := []string{link("first", map[string]string{"cursor": "", "limit": limit})}
+		if more {
+			links = append(links, link("next", map[string]string{"cursor": database.NewCursor(songs[len(songs)-1], query), "limit": limit}))
+		}
+		return links
+	}
+
+	offset := func(offset int) map[string]string {
+		return map[string]string{"offset": strconv.Itoa(offset), "limit": limit}
+	}
+	links := []string{link("first", offset(0))}
+	if query.Offset > 0 {
+		links = append(links, link("prev", offset(max(query.Offset-query.Limit, 0))))
+	}
+	if more {
+		links = append(links, link("next", offset(query.Offset+query.Limit)))
+	}
+	if total > 0 {
+		links = append(links, link("last", offset(int(total-1)/query.Limit*query.Limit)))
+	}
+	return links
+}
+
+// sparse returns the songs with only the fields.
+func sparse(songs []model.Song, fields []string) ([]map[string]json.RawMessage, error) {
+	result := make([]map[string]json.RawMessage, len(songs))
+	for i, song := range songs {
+		content, err := json.Marshal(song)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		err = json.Unmarshal(content, &all)
+		if err != nil {
+			return nil, err
+		}
+
+		result[i] = make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			result[i][field] = all[field]
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"infiniti.com/internal/upload"
+)
+
+// TUSVERSION is the version of the tus protocol spoken by resumable uploads.
+const TUSVERSION = "1.0.0"
+
+// TUSEXTENSIONS are the extensions of the tus protocol resumable uploads support.
+const TUSEXTENSIONS = "creation,expiration,termination"
+
+// Tus checks that requests for resumable uploads speak the version of the tus
+// protocol the server does, and says so in every response.
+func (sc *SongController) Tus(c *gin.Context) {
+	c.Header("Tus-Resumable", TUSVERSION)
+
+	// OPTIONS tells clients the version, and GET is no part of the protocol
+	if c.Request.Method != http.MethodOptions && c.Request.Method != http.MethodGet && c.GetHeader("Tus-Resumable") != TUSVERSION {
+		c.Header("Tus-Version", TUSVERSION)
+		respondError(c, http.StatusPreconditionFailed, "unsupported tus version, expected "+TUSVERSION)
+		return
+	}
+	c.Next()
+}
+
+// ResumableOptions tells clients what resumable uploads support.
+func (sc *SongController) ResumableOptions(c *gin.Context) {
+	c.Header("Tus-Version", TUSVERSION)
+	c.Header("Tus-Extension", TUSEXTENSIONS)
+	c.Header("Tus-Max-Size", strconv.FormatInt(sc.resumable.MaxSize(), 10))
+	c.Status(http.StatusNoContent)
+}
+
+// CreateResumable starts a resumable upload of Upload-Length bytes, named by
+// the filename in its Upload-Metadata, and responds with its location.
+func (sc *SongController) CreateResumable(c *gin.Context) {
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		respondError(c, http.StatusBadRequest, "a valid Upload-Length is required")
+		return
+	}
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	p, err := sc.resumable.Create(length, metadata, currentUserID(c))
+	if err != nil {
+		sc.resumableError(c, err)
+		return
+	}
+
+	// relative to the route the upload was created through, which is under /api/v1 or not
+	c.Header("Location", path.Join(c.Request.URL.Path, p.ID))
+	c.Header("Upload-Expires", p.Expires.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// ResumableOffset tells how much of a resumable upload has arrived.
+func (sc *SongController) ResumableOffset(c *gin.Context) {
+	p, err := sc.resumable.Get(c.Param("id"))
+	if err != nil {
+		sc.resumableError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(p.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(p.Length, 10))
+	c.Header("Upload-Expires", p.Expires.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// PatchResumable appends the body to a resumable upload at Upload-Offset. The
+// last part stores the upload, which is refused like a single uploaded file
+// if none of its files could be added.
+func (sc *SongController) PatchResumable(c *gin.Context) {
+	if c.ContentType() != "application/offset+octet-stream" {
+		respondError(c, http.StatusUnsupportedMediaType, "parts must be sent as application/offset+octet-stream")
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondError(c, http.StatusBadRequest, "a valid Upload-Offset is required")
+		return
+	}
+
+	p, err := sc.resumable.Write(c.Param("id"), offset, c.Request.Body)
+	if p != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(p.Offset, 10))
+		c.Header("Upload-Expires", p.Expires.UTC().Format(http.TimeFormat))
+	}
+	if err != nil {
+		sc.resumableError(c, err)
+		return
+	}
+
+	if p.Done() && !anyStored(p.Results) {
+		uploadError(c, sc.cfg, p.Results[0].Err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteResumable gives up on a resumable upload.
+func (sc *SongController) DeleteResumable(c *gin.Context) {
+	err := sc.resumable.Delete(c.Param("id"))
+	if err != nil {
+		sc.resumableError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetResumable responds with a resumable upload, with what became of its files once it is complete.
+func (sc *SongController) GetResumable(c *gin.Context) {
+	p, err := sc.resumable.Get(c.Param("id"))
+	if err != nil {
+		sc.resumableError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, p)
+}
+
+/**
+	Private functions
+**/
+
+// resumableError responds with the status the tus protocol uses for the error.
+func (sc *SongController) resumableError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, upload.ErrUploadNotFound):
+		respondError(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, upload.ErrOffset):
+		respondError(c, http.StatusConflict, err.Error())
+	case errors.Is(err, upload.ErrBusy):
+		respondError(c, http.StatusLocked, err.Error())
+	case errors.Is(err, upload.ErrTooLarge):
+		respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload exceeds the maximum size of %d bytes", sc.resumable.MaxSize()))
+	default:
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not store upload")
+	}
+}
+
+// parseUploadMetadata parses the Upload-Metadata header, comma separated keys each followed by a base64 encoded value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for '%s'", key)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+// anyStored reports whether any of the files is in the library now, created by the upload or there already.
+func anyStored(results []upload.Result) bool {
+	for _, result := range results {
+		if result.Status == upload.CREATED || result.Status == upload.DUPLICATE {
+			return true
+		}
+	}
+	return false
+}
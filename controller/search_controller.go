@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"infiniti.com/internal/database"
+	"infiniti.com/internal/search"
+	"infiniti.com/internal/suggest"
+	model "infiniti.com/model"
+)
+
+// SUGGESTLIMIT is the amount of suggestions when the request doesn't say.
+const SUGGESTLIMIT = 10
+
+// MAXSUGGESTLIMIT is the most suggestions a request may ask for.
+const MAXSUGGESTLIMIT = 50
+
+// SearchController finds songs through the search index, and songs, artists and albums to suggest.
+type SearchController struct {
+	index     *search.Index
+	suggester *suggest.Suggester
+	songs     database.SongRepository
+}
+
+func NewSearchController(index *search.Index, suggester *suggest.Suggester, songs database.SongRepository) *SearchController {
+	return &SearchController{index: index, suggester: suggester, songs: songs}
+}
+
+// Search responds with a page of the songs matching the q parameter, best
+// first, along with why they matched. The total count of songs that match is
+// in the X-Total-Count header.
+func (sc *SearchController) Search(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		respondErrorDetails(c, http.StatusBadRequest, "invalid query parameter", queryError{"q", "is required"})
+		return
+	}
+	limit, offset, invalid := pageParams(c)
+	if invalid != nil {
+		respondErrorDetails(c, http.StatusBadRequest, "invalid query parameter", invalid)
+		return
+	}
+
+	results, total := sc.index.Search(query, offset, limit)
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.IndentedJSON(http.StatusOK, results)
+}
+
+// Suggest responds with the songs, artists and albums whose name starts like
+// the q parameter, or has a word that does, best first. It is meant to be
+// asked while the name is being typed, so nothing typed yet has no suggestions.
+func (sc *SearchController) Suggest(c *gin.Context) {
+	limit := SUGGESTLIMIT
+	if value := c.Query("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MAXSUGGESTLIMIT {
+			respondErrorDetails(c, http.StatusBadRequest, "invalid query parameter", queryError{"limit", fmt.Sprintf("must be between 1 and %d", MAXSUGGESTLIMIT)})
+			return
+		}
+	}
+
+	c.IndentedJSON(http.StatusOK, sc.suggester.Suggest(c.Query("q"), limit))
+}
+
+// SearchSongs responds with the songs matching the term in the path, for the
+// deprecated route. Terms the index has no songs for are looked up in the
+// titles ignoring spaces, like before there was an index.
+func (sc *SearchController) SearchSongs(c *gin.Context) {
+	term := c.Param("param")
+
+	results, _ := sc.index.Search(term, 0, MAXLIMIT)
+	songs := make([]model.Song, len(results))
+	for i, result := range results {
+		songs[i] = result.Song
+	}
+
+	if len(songs) == 0 {
+		var err error
+		songs, err = sc.songs.Search(term)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	c.IndentedJSON(http.StatusOK, songs)
+}
@@ -1,179 +1,535 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
-	"os"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/tcolgate/mp3"
-	"gorm.io/gorm"
+	"infiniti.com/config"
 	"infiniti.com/internal/audiopipeline"
+	"infiniti.com/internal/auth"
 	"infiniti.com/internal/database"
+	"infiniti.com/internal/storage"
+	"infiniti.com/internal/upload"
 	model "infiniti.com/model"
 )
 
-var db *gorm.DB
+type SongController struct {
+	songs database.SongRepository
+	store storage.Backend
+	cfg   *config.Config
+
+	// stations keeps one live broadcast per song, shared by all of its listeners.
+	stations *audiopipeline.StationRegistry
+	// segments keeps the HLS segments of recently played songs.
+	segments *cache[*audiopipeline.Segmenter]
+	// covers keeps recently requested cover images.
+	covers *cache[coverImage]
+	// uploads adds uploaded songs to the library.
+	uploads *upload.Uploader
+	// resumable keeps the uploads sent in parts until they are complete.
+	resumable *upload.Resumable
+}
 
-func Init(database *gorm.DB) {
-	db = database
+func NewSongController(songs database.SongRepository, store storage.Backend, cfg *config.Config) *SongController {
+	uploads := upload.New(songs, store, cfg.Upload.MaxFileSize)
+	expiry := time.Duration(cfg.Upload.ResumableExpiry) * time.Hour
+
+	return &SongController{
+		songs:     songs,
+		store:     store,
+		cfg:       cfg,
+		stations:  audiopipeline.NewStationRegistry(),
+		segments:  newCache[*audiopipeline.Segmenter](HLSCACHE),
+		covers:    newCache[coverImage](COVERCACHE),
+		uploads:   uploads,
+		resumable: upload.NewResumable(uploads, cfg.Upload.ResumableDir, cfg.Upload.MaxResumableSize, expiry),
+	}
 }
 
-func GetSpecifiedSong(c *gin.Context) {
-	song, err := getSong(c)
+// ExpireUploads removes the resumable uploads that expired until stop is closed.
+func (sc *SongController) ExpireUploads(stop <-chan struct{}) {
+	sc.resumable.Run(stop)
+}
+
+func (sc *SongController) GetSpecifiedSong(c *gin.Context) {
+	song, err := sc.getSong(c)
 	if err != nil {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "song not found"})
+		songError(c, err)
 	} else {
 		c.IndentedJSON(http.StatusOK, song)
 	}
 }
 
-func HomeScreen(c *gin.Context) {
-	c.String(http.StatusOK, "Welcome to Infiniti! \n\nAvailable endpoints: \n\nGET /songs \nGET /songs/:param \nGET /search/:param \n"+
-		"GET /play/:param \nGET /remove/:param \nPOST /upload (example: curl -X POST http://127.0.0.1:9000/upload -F \"file=@/Users/guest/Music/Johannes Brahms - Hungarian Dance No.5.mp3\")"+
-		"\n\nEnjoy!")
+func (sc *SongController) HomeScreen(c *gin.Context) {
+	c.String(http.StatusOK, "Welcome to Infiniti! \n\nAvailable endpoints, under /api/v1: \n\nGET /songs \nPOST /songs \nGET /songs/:id \nPATCH /songs/:id \nDELETE /songs/:id \nGET /songs/slug/:slug \nGET /search?q= \nGET /suggest?q= \n"+
+		"GET /songs/:id/stream \nGET /songs/:id/hls/index.m3u8 \nGET /songs/:id/cover \nGET /songs/:id/play \n"+
+		"GET /stations \nPOST /stations \nGET /stations/:name \nDELETE /stations/:name \n"+
+		"GET /stations/:name/listen \nGET /stations/:name/hls/index.m3u8 \nPOST /stations/:name/skip \nPOST /stations/:name/pause \nPOST /stations/:name/resume \n"+
+		"GET /stations/:name/queue \nPOST /stations/:name/queue \nPATCH /stations/:name/queue/:entry \nDELETE /stations/:name/queue/:entry \n"+
+		"GET /artists \nGET /artists/:id/albums \nGET /albums \nGET /albums/:id/tracks \nGET /albums/:id/cover \n"+
+		"OPTIONS /uploads \nPOST /uploads \nHEAD /uploads/:id \nPATCH /uploads/:id \nDELETE /uploads/:id \nGET /uploads/:id (resumable uploads, following the tus 1.0 protocol) \n"+
+		"POST /auth/register \nPOST /auth/login \nGET /auth/me \nGET /auth/keys \nPOST /auth/keys \nDELETE /auth/keys/:id \n"+
+		"GET /roles \nGET /users \nPOST /users \nGET /users/:id \nPATCH /users/:id \nDELETE /users/:id (admins only) \n"+
+		"\nEndpoints that change songs, uploads or stations need a session token from POST /auth/login or an API key, sent as \"Authorization: Bearer <token>\" \n"+
+		"\nListeners may only listen, uploaders may also upload and control stations and delete the songs they uploaded, admins may do anything \n"+
+		"\nPOST /songs takes one or more files, or a ZIP or TAR of an album (example: curl -X POST http://127.0.0.1:9000/api/v1/songs -F \"file=@/Users/guest/Music/Johannes Brahms - Hungarian Dance No.5.mp3\") \n"+
+		"\nGET /search?q= finds songs by the words of their title, artist, album, genre and lyrics, with phrases in quotes and fields like artist:queen \n"+
+		"\nDeprecated, without /api/v1: the same endpoints except for the new song ones, and GET /songs/:param, GET /search/:param, GET /play/:param, GET /remove/:param and POST /upload \n"+
+		"\nEnjoy!")
 }
 
-func PlaySong(c *gin.Context) {
-	song, err := getSong(c)
+func (sc *SongController) PlaySong(c *gin.Context) {
+	song, err := sc.getSong(c)
 	if err != nil {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "song not found"})
+		songError(c, err)
+		return
+	}
+	if song.Missing {
+		respondError(c, http.StatusNotFound, "song file not found")
+		return
+	}
+	if !audiopipeline.Playable(song.FileType) {
+		notPlayable(c, song)
 		return
 	}
 
-	connPool := audiopipeline.NewConnectionPool()
+	// tune in to the song's station, starting its stream if nobody is listening yet
+	station := sc.stations.Join(fmt.Sprintf("song:%d", song.ID), func(connPool *audiopipeline.ConnectionPool, stop <-chan struct{}) {
+		file, err := sc.openFile(song)
+		if err != nil {
+			log.Printf("Could not open the file of song %d for its station\n", song.ID)
+			return
+		}
+		defer file.Close()
+
+		frames, err := audiopipeline.ParseFrames(file)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		connPool.SetMetadata(audiopipeline.Metadata{
+			Name:    sc.cfg.Radio.Name,
+			Genre:   sc.cfg.Radio.Genre,
+			Bitrate: audiopipeline.AverageBitrate(frames),
+			Title:   audiopipeline.StreamTitle(song.Artist, song.Title),
+		})
 
-	file, err := openFile(song)
+		audiopipeline.Stream(connPool, frames, stop)
+	})
+	defer sc.stations.Leave(station)
+
+	audiopipeline.PlayAudiofile(station.Pool, song.FileType, c)
+}
+
+// StreamSong serves the song's file on demand. Unlike PlaySong it supports
+// range requests and conditional requests, so players can seek, resume and cache.
+func (sc *SongController) StreamSong(c *gin.Context) {
+	song, err := sc.getSong(c)
 	if err != nil {
-		log.Println(err)
+		songError(c, err)
+		return
 	}
 
-	bytes, err := readSongContents(file)
+	info, err := sc.store.Stat(song.Path)
 	if err != nil {
 		log.Println(err)
+		respondError(c, http.StatusNotFound, "song file not found")
+		return
+	}
+
+	file, err := sc.openFile(song)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "could not open song")
+		return
 	}
+	defer file.Close()
 
-	// create a go routine to stream the song
-	go audiopipeline.Stream(connPool, bytes, float32(calculatePlayLength("../songs/"+song.Path)))
+	contentType := mime.TypeByExtension("." + song.FileType)
+	if contentType == "" {
+		contentType = "audio/" + song.FileType
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("ETag", `"`+info.ETag+`"`)
 
-	audiopipeline.PlayAudiofile(connPool, song.FileType, c)
+	// ServeContent takes care of Range, If-Range, If-None-Match and If-Modified-Since
+	http.ServeContent(c.Writer, c.Request, path.Base(song.Path), info.ModTime, file)
 }
 
-func GetSongs(c *gin.Context) {
-	var songs = database.GetSongs(db)
-	if songs != nil {
-		c.IndentedJSON(http.StatusOK, songs)
-	} else {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "Such empty"})
+// SongHLS serves a song as HLS, with a VOD playlist of segments cut on frame boundaries.
+func (sc *SongController) SongHLS(c *gin.Context) {
+	song, err := sc.getSong(c)
+	if err != nil {
+		songError(c, err)
+		return
+	}
+	if !audiopipeline.Playable(song.FileType) {
+		notPlayable(c, song)
+		return
 	}
-}
 
-func RemoveSong(c *gin.Context) {
-	song, err := getSong(c)
-	fmt.Println("song:", song, "err:", err)
+	info, err := sc.store.Stat(song.Path)
 	if err != nil {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "song not found"})
-	} else {
-		c.IndentedJSON(http.StatusOK, song)
-		err = database.RemoveSong(db, song)
+		log.Println(err)
+		respondError(c, http.StatusNotFound, "song file not found")
+		return
+	}
+
+	// the ETag changes with the file, so a replaced song is segmented again
+	segmenter, err := sc.segments.get(song.Path+"@"+info.ETag, func() (*audiopipeline.Segmenter, error) {
+		file, err := sc.openFile(song)
 		if err != nil {
-			log.Println(err)
+			return nil, err
+		}
+		defer file.Close()
+
+		frames, err := audiopipeline.ParseFrames(file)
+		if err != nil {
+			return nil, err
 		}
-		database.RemoveSong(db, song)
 
+		segmenter := newSegmenter(sc.cfg.HLS, false)
+		segmenter.Write(frames)
+		segmenter.Flush()
+		return segmenter, nil
+	})
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not segment song")
+		return
 	}
+
+	serveHLS(c, segmenter, false)
 }
 
-func UploadSong(c *gin.Context) {
-	fileHeader, err := c.FormFile("file")
+// SongCover serves the song's cover image, resized to fit ?size= pixels if given.
+func (sc *SongController) SongCover(c *gin.Context) {
+	song, err := sc.getSong(c)
 	if err != nil {
-		log.Println(err)
+		songError(c, err)
+		return
 	}
 
-	err = c.SaveUploadedFile(fileHeader, "../songs/"+fileHeader.Filename)
+	serveCover(c, sc.store, sc.covers, song.CoverHash)
+}
+
+// GetSongs responds with a page of the songs, filtered by artist, album,
+// genre, file_type and min_duration or max_duration, sorted by the sort field
+// and paged through limit and either offset or cursor. The fields parameter
+// picks the fields of the songs. The total count of songs that match is in
+// the X-Total-Count header and the other pages are in the Link header.
+// A page without songs, like that of an empty library, is an empty list.
+func (sc *SongController) GetSongs(c *gin.Context) {
+	query, fields, invalid := songQuery(c)
+	if invalid != nil {
+		respondErrorDetails(c, http.StatusBadRequest, "invalid query parameter", invalid)
+		return
+	}
+
+	// one more song than the page tells whether there is a next one
+	query.Limit++
+	songs, total, err := sc.songs.Query(query)
+	query.Limit--
+	if errors.Is(err, database.ErrInvalidQuery) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not list songs")
+		return
 	}
 
-	file, err := os.Open(fileHeader.Filename)
+	more := len(songs) > query.Limit
+	if more {
+		songs = songs[:query.Limit]
+	}
+
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	// added to, as the legacy routes link to their successor
+	for _, link := range pageLinks(c, query, songs, total, more) {
+		c.Writer.Header().Add("Link", link)
+	}
+
+	if fields == nil {
+		c.IndentedJSON(http.StatusOK, songs)
+		return
+	}
+	result, err := sparse(songs, fields)
 	if err != nil {
 		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not list songs")
+		return
 	}
+	c.IndentedJSON(http.StatusOK, result)
+}
 
-	database.ParseFileToSongDatatype(db, file)
+// RemoveSong removes the song like DeleteSong, but responds with the removed song as it always has.
+func (sc *SongController) RemoveSong(c *gin.Context) {
+	song, ok := sc.removeSong(c)
+	if ok {
+		c.IndentedJSON(http.StatusOK, song)
+	}
+}
+
+// UploadSong stores the files sent in the "file" fields, reading the request
+// as it comes in so large uploads go to disk rather than into memory. A single
+// audio file is answered with the song created for it, like before. Several
+// files or a ZIP or TAR archive, which is unpacked into an album folder, are
+// answered with the result of every file.
+func (sc *SongController) UploadSong(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, sc.cfg.Upload.MaxRequestSize)
 
-	c.String(http.StatusOK, fmt.Sprintf("'%s' uploaded!", fileHeader.Filename))
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "a file is required in the 'file' field")
+		return
+	}
+
+	var results []upload.Result
+	single := true
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			uploadError(c, sc.cfg, err)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		partResults, archive := sc.uploads.Owner(currentUserID(c)).Unpack(part.FileName(), part)
+		part.Close()
+		results = append(results, partResults...)
+		single = single && !archive
+	}
+
+	switch {
+	case len(results) == 0:
+		respondError(c, http.StatusBadRequest, "a file is required in the 'file' field")
+	case !single || len(results) > 1:
+		c.IndentedJSON(http.StatusOK, results)
+	case results[0].Status == upload.CREATED:
+		c.IndentedJSON(http.StatusCreated, results[0].Song)
+	case results[0].Status == upload.DUPLICATE:
+		respondErrorDetails(c, http.StatusConflict, results[0].Err.Error(), gin.H{"song": results[0].Song})
+	default:
+		uploadError(c, sc.cfg, results[0].Err)
+	}
 }
 
-func SearchSong(c *gin.Context) {
-	searchTerm := c.Param("param")
-	songs, err := database.GetSongByTitle(db, searchTerm)
+// GetSongBySlug responds with the song whose slug is in the path.
+func (sc *SongController) GetSongBySlug(c *gin.Context) {
+	song, err := sc.songs.FindSlug(c.Param("slug"))
 	if err != nil {
-		log.Println(err)
+		songError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, song)
+}
+
+// SongPatch holds the fields of a song that can be changed, named like in the
+// song itself. The fields that are left out keep their value.
+type SongPatch struct {
+	Title       *string
+	Artist      *string
+	Album       *string
+	AlbumArtist *string
+	Genre       *string
+	Composer    *string
+	Year        *int
+	Track       *int
+	TrackTotal  *int
+	Disc        *int
+	DiscTotal   *int
+	Lyrics      *string
+}
+
+// UpdateSong changes the fields of the song sent in the body and responds
+// with the song, linked to its new artist and album if those changed.
+func (sc *SongController) UpdateSong(c *gin.Context) {
+	song, err := sc.getSong(c)
+	if err != nil {
+		songError(c, err)
+		return
+	}
+
+	var patch SongPatch
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&patch)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, "invalid song", gin.H{"error": err.Error()})
+		return
+	}
+
+	if patch.Title != nil {
+		title := strings.TrimSpace(*patch.Title)
+		if title == "" {
+			respondErrorDetails(c, http.StatusBadRequest, "invalid song", gin.H{"field": "Title", "error": "must not be empty"})
+			return
+		}
+		if title != song.Title {
+			// a new slug is made from the new title
+			song.Title, song.Slug = title, ""
+		}
+	}
+	for field, value := range map[string]*int{"Year": patch.Year, "Track": patch.Track, "TrackTotal": patch.TrackTotal, "Disc": patch.Disc, "DiscTotal": patch.DiscTotal} {
+		if value != nil && *value < 0 {
+			respondErrorDetails(c, http.StatusBadRequest, "invalid song", gin.H{"field": field, "error": "must not be negative"})
+			return
+		}
 	}
+	patch.apply(&song)
 
-	c.IndentedJSON(http.StatusOK, songs)
+	err = sc.songs.Update(&song)
+	if errors.Is(err, database.ErrDuplicate) {
+		respondErrorDetails(c, http.StatusConflict, "a song with this title exists already", gin.H{"field": "Title"})
+		return
+	}
+	if err != nil {
+		songError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, song)
+}
+
+// DeleteSong removes the song and its file. Only admins and whoever uploaded the song may.
+func (sc *SongController) DeleteSong(c *gin.Context) {
+	_, ok := sc.removeSong(c)
+	if ok {
+		c.Status(http.StatusNoContent)
+	}
 }
 
 /**
 	Private functions
 **/
 
-func getSong(c *gin.Context) (model.Song, error) {
+// removeSong removes the song in the path from the library and the storage
+// backend, if the user may. It responds with the error and returns false if
+// the song isn't removed, leaving the response to the caller otherwise.
+func (sc *SongController) removeSong(c *gin.Context) (model.Song, bool) {
+	song, err := sc.getSong(c)
+	if err != nil {
+		songError(c, err)
+		return song, false
+	}
+	if !auth.CanDelete(currentUser(c), c.GetString(ROLEKEY), song) {
+		forbidDelete(c)
+		return song, false
+	}
+
+	err = database.RemoveSong(sc.songs, sc.store, song)
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not remove song")
+		return song, false
+	}
+	return song, true
+}
+
+// forbidDelete refuses to delete a song the user didn't upload.
+func forbidDelete(c *gin.Context) {
+	respondError(c, http.StatusForbidden, "songs can only be deleted by whoever uploaded them or by an admin")
+}
+
+// errInvalidID is returned for song ids that aren't positive numbers.
+var errInvalidID = errors.New("song id must be a positive number")
+
+// getSong looks up the song named in the path. The /api/v1 routes name it by
+// its id, the deprecated ones by its id or else by a title it matches.
+func (sc *SongController) getSong(c *gin.Context) (model.Song, error) {
+	if param, ok := c.Params.Get("id"); ok {
+		id, err := strconv.ParseUint(param, 10, 0)
+		if err != nil || id == 0 {
+			return model.Song{}, errInvalidID
+		}
+
+		song, err := sc.songs.Get(uint(id))
+		if err != nil {
+			return model.Song{}, err
+		}
+		return *song, nil
+	}
+
 	param := c.Param("param")
 
-	id, succes := strconv.Atoi(param)
+	id, succes := strconv.ParseUint(param, 10, 0)
 	if succes == nil {
-		song, err := database.GetSongById(db, id)
+		song, err := sc.songs.Get(uint(id))
 		if err == nil {
 			return *song, nil
 		}
 		return model.Song{}, err
 	} else {
-		songs, err := database.GetSongByTitle(db, param)
-		if err == nil && len(songs) > 0 {
-			return songs[0], nil
+		songs, err := sc.songs.Search(param)
+		if err != nil {
+			return model.Song{}, err
 		}
-		err = fmt.Errorf("song not found")
-		return model.Song{}, err
+		if len(songs) == 0 {
+			return model.Song{}, database.ErrNotFound
+		}
+		return songs[0], nil
 	}
 }
 
-// source: https://stackoverflow.com/questions/60281655/how-to-find-the-length-of-mp3-file-in-golang
-func calculatePlayLength(path string) float64 {
-	t := 0.0
-
-	r, err := os.Open(path)
-	if err != nil {
-		fmt.Println(err)
-		return 0.0
+// songError responds with the status matching why a song couldn't be looked up or saved.
+func songError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		respondError(c, http.StatusNotFound, "song not found")
+	case errors.Is(err, errInvalidID):
+		respondError(c, http.StatusBadRequest, err.Error())
+	default:
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not get song")
 	}
+}
 
-	d := mp3.NewDecoder(r)
-	var f mp3.Frame
-	skipped := 0
-
-	for {
-
-		if err := d.Decode(&f, &skipped); err != nil {
-			if err == io.EOF {
-				break
-			}
-			fmt.Println(err)
-			return 0.0
-		}
-
-		t = t + f.Duration().Seconds()
+// uploadError responds with the status matching why an upload was refused.
+func uploadError(c *gin.Context, cfg *config.Config, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload exceeds the maximum size of %d bytes", cfg.Upload.MaxRequestSize))
+	case errors.Is(err, upload.ErrTooLarge):
+		respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds the maximum size of %d bytes", cfg.Upload.MaxFileSize))
+	case errors.Is(err, upload.ErrNotAudio):
+		respondError(c, http.StatusUnsupportedMediaType, err.Error())
+	case errors.Is(err, upload.ErrUnreadable):
+		respondError(c, http.StatusUnprocessableEntity, err.Error())
+	default:
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not store upload")
 	}
-	return t
 }
 
-func openFile(song model.Song) (*os.File, error) {
-	fname := "../songs/" + song.Path
-	file, err := os.Open(fname)
+// notPlayable responds that the song can only be streamed as a file, as only MP3 songs are split into frames.
+func notPlayable(c *gin.Context, song model.Song) {
+	respondErrorDetails(c, http.StatusUnsupportedMediaType, "only MP3 songs can be played live, others can be streamed",
+		gin.H{"file_type": song.FileType, "stream": fmt.Sprintf("/api/v1/songs/%d/stream", song.ID)})
+}
+
+func (sc *SongController) openFile(song model.Song) (storage.File, error) {
+	file, err := sc.store.Open(song.Path)
 	if err != nil {
 		log.Println(err)
 	}
@@ -181,11 +537,32 @@ func openFile(song model.Song) (*os.File, error) {
 	return file, err
 }
 
-func readSongContents(file *os.File) ([]byte, error) {
-	ctn, err := io.ReadAll(file)
-	if err != nil {
-		log.Println(err)
+// apply sets the fields of the song that are in the patch, apart from the title.
+func (patch SongPatch) apply(song *model.Song) {
+	texts := map[*string]*string{
+		&song.Artist:      patch.Artist,
+		&song.Album:       patch.Album,
+		&song.AlbumArtist: patch.AlbumArtist,
+		&song.Genre:       patch.Genre,
+		&song.Composer:    patch.Composer,
+		&song.Lyrics:      patch.Lyrics,
+	}
+	for field, value := range texts {
+		if value != nil {
+			*field = strings.TrimSpace(*value)
+		}
 	}
 
-	return ctn, err
+	numbers := map[*int]*int{
+		&song.Year:       patch.Year,
+		&song.Track:      patch.Track,
+		&song.TrackTotal: patch.TrackTotal,
+		&song.Disc:       patch.Disc,
+		&song.DiscTotal:  patch.DiscTotal,
+	}
+	for field, value := range numbers {
+		if value != nil {
+			*field = *value
+		}
+	}
 }
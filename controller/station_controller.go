@@ -0,0 +1,374 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"infiniti.com/config"
+	"infiniti.com/internal/audiopipeline"
+	"infiniti.com/internal/database"
+	"infiniti.com/internal/storage"
+	model "infiniti.com/model"
+)
+
+// station names end up in URLs, so they are kept to lowercase letters, digits and dashes
+var stationName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// StationController manages radio stations that play songs from the library back to back.
+type StationController struct {
+	songs database.SongRepository
+	store storage.Backend
+	cfg   *config.Config
+
+	stations *audiopipeline.StationRegistry
+}
+
+// StationRequest describes a station to create. Mode is one of
+//   - "playlist": plays Songs in the given order on repeat
+//   - "shuffle": plays the whole library shuffled
+//   - "filter": plays the songs matching Artist and/or FileType, shuffled if Shuffle is set
+type StationRequest struct {
+	Name     string `json:"name"`
+	Mode     string `json:"mode"`
+	Songs    []uint `json:"songs"`
+	Artist   string `json:"artist"`
+	FileType string `json:"file_type"`
+	Shuffle  bool   `json:"shuffle"`
+}
+
+// EnqueueRequest names the song to enqueue, by its ID or else by its title.
+type EnqueueRequest struct {
+	ID    uint   `json:"id"`
+	Title string `json:"title"`
+}
+
+// MoveRequest gives the new position of a queue entry, counting from 0 for the next track to play.
+type MoveRequest struct {
+	Position *int `json:"position"`
+}
+
+func NewStationController(songs database.SongRepository, store storage.Backend, cfg *config.Config) *StationController {
+	return &StationController{
+		songs:    songs,
+		store:    store,
+		cfg:      cfg,
+		stations: audiopipeline.NewStationRegistry(),
+	}
+}
+
+func (stc *StationController) CreateStation(c *gin.Context) {
+	var request StationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid station: "+err.Error())
+		return
+	}
+
+	if !stationName.MatchString(request.Name) {
+		respondError(c, http.StatusBadRequest, "station name may only contain lowercase letters, digits and dashes")
+		return
+	}
+
+	source, err := stc.source(request)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	queue := audiopipeline.NewQueue(source)
+	remote := audiopipeline.NewRemote()
+	metadata := audiopipeline.Metadata{
+		Name:  stc.cfg.Radio.Name + " - " + request.Name,
+		Genre: stc.cfg.Radio.Genre,
+	}
+
+	_, err = stc.stations.Create(request.Name, queue, remote, newSegmenter(stc.cfg.HLS, true), func(connPool *audiopipeline.ConnectionPool, stop <-chan struct{}) {
+		connPool.SetMetadata(metadata)
+		audiopipeline.StreamQueue(connPool, queue, remote, stc.loadTrack, stop)
+	})
+	if errors.Is(err, audiopipeline.ErrStationExists) {
+		respondError(c, http.StatusConflict, "station already exists")
+		return
+	}
+
+	info, _ := stc.stations.Info(request.Name)
+	c.IndentedJSON(http.StatusCreated, info)
+}
+
+func (stc *StationController) GetStations(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, stc.stations.List())
+}
+
+func (stc *StationController) GetStation(c *gin.Context) {
+	info, err := stc.stations.Info(c.Param("name"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "station not found")
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, info)
+}
+
+func (stc *StationController) DeleteStation(c *gin.Context) {
+	err := stc.stations.Delete(c.Param("name"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "station not found")
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "station deleted"})
+}
+
+func (stc *StationController) ListenStation(c *gin.Context) {
+	station, err := stc.stations.Tune(c.Param("name"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "station not found")
+		return
+	}
+	defer stc.stations.Leave(station)
+
+	audiopipeline.PlayAudiofile(station.Pool, "mpeg", c)
+}
+
+// StationHLS serves the station as HLS, with a live playlist of its latest segments.
+func (stc *StationController) StationHLS(c *gin.Context) {
+	station, ok := stc.getStation(c)
+	if !ok {
+		return
+	}
+
+	serveHLS(c, station.Pool.Segmenter(), true)
+}
+
+func (stc *StationController) SkipTrack(c *gin.Context) {
+	station, ok := stc.getStation(c)
+	if !ok {
+		return
+	}
+
+	station.Remote.Skip()
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "track skipped"})
+}
+
+// PauseStation pauses playback. Listeners get silence by default, or are held
+// without any data with ?mode=hold.
+func (stc *StationController) PauseStation(c *gin.Context) {
+	station, ok := stc.getStation(c)
+	if !ok {
+		return
+	}
+
+	mode := c.DefaultQuery("mode", "silence")
+	if mode != "silence" && mode != "hold" {
+		respondError(c, http.StatusBadRequest, "mode must be silence or hold")
+		return
+	}
+
+	station.Remote.Pause(mode == "silence")
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "station paused"})
+}
+
+func (stc *StationController) ResumeStation(c *gin.Context) {
+	station, ok := stc.getStation(c)
+	if !ok {
+		return
+	}
+
+	station.Remote.Resume()
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "station resumed"})
+}
+
+func (stc *StationController) GetQueue(c *gin.Context) {
+	station, ok := stc.getStation(c)
+	if !ok {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, station.Queue.Upcoming())
+}
+
+func (stc *StationController) EnqueueSong(c *gin.Context) {
+	station, ok := stc.getStation(c)
+	if !ok {
+		return
+	}
+
+	var request EnqueueRequest
+	if err := c.ShouldBindJSON(&request); err != nil || (request.ID == 0 && request.Title == "") {
+		respondError(c, http.StatusBadRequest, "a song id or title is required")
+		return
+	}
+
+	var song *model.Song
+	var err error
+	if request.ID != 0 {
+		song, err = stc.songs.Get(request.ID)
+	} else {
+		song, err = stc.songs.Find(request.Title)
+		if err != nil {
+			// like elsewhere, fall back to the best match when there is no exact one
+			var songs []model.Song
+			songs, err = stc.songs.Search(request.Title)
+			if err == nil && len(songs) == 0 {
+				err = database.ErrNotFound
+			}
+			if err == nil {
+				song = &songs[0]
+			}
+		}
+	}
+	if err != nil {
+		respondError(c, http.StatusNotFound, "song not found")
+		return
+	}
+
+	entry := station.Queue.Enqueue(songTrack(*song))
+	c.IndentedJSON(http.StatusCreated, entry)
+}
+
+func (stc *StationController) RemoveQueueEntry(c *gin.Context) {
+	station, entryID, ok := stc.getEntry(c)
+	if !ok {
+		return
+	}
+
+	if err := station.Queue.Remove(entryID); err != nil {
+		respondError(c, http.StatusNotFound, "queue entry not found")
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, station.Queue.Upcoming())
+}
+
+func (stc *StationController) MoveQueueEntry(c *gin.Context) {
+	station, entryID, ok := stc.getEntry(c)
+	if !ok {
+		return
+	}
+
+	var request MoveRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.Position == nil {
+		respondError(c, http.StatusBadRequest, "a position is required")
+		return
+	}
+
+	if err := station.Queue.Move(entryID, *request.Position); err != nil {
+		respondError(c, http.StatusNotFound, "queue entry not found")
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, station.Queue.Upcoming())
+}
+
+/**
+	Private functions
+**/
+
+// getStation looks up the station named in the path, responding with 404 if there is none.
+func (stc *StationController) getStation(c *gin.Context) (*audiopipeline.Station, bool) {
+	station, err := stc.stations.Find(c.Param("name"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "station not found")
+		return nil, false
+	}
+	return station, true
+}
+
+// getEntry looks up the station and parses the queue entry named in the path.
+func (stc *StationController) getEntry(c *gin.Context) (*audiopipeline.Station, uint64, bool) {
+	station, ok := stc.getStation(c)
+	if !ok {
+		return nil, 0, false
+	}
+
+	entryID, err := strconv.ParseUint(c.Param("entry"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusNotFound, "queue entry not found")
+		return nil, 0, false
+	}
+	return station, entryID, true
+}
+
+// source picks the tracks of a station as described by the request.
+func (stc *StationController) source(request StationRequest) (audiopipeline.Source, error) {
+	switch request.Mode {
+	case "playlist":
+		if len(request.Songs) == 0 {
+			return nil, errors.New("a playlist needs at least one song")
+		}
+
+		tracks := make([]audiopipeline.Track, 0, len(request.Songs))
+		for _, id := range request.Songs {
+			song, err := stc.songs.Get(id)
+			if err != nil {
+				return nil, errors.New("playlist contains an unknown song")
+			}
+			tracks = append(tracks, songTrack(*song))
+		}
+		return audiopipeline.NewPlaylistSource(tracks), nil
+
+	case "shuffle":
+		return audiopipeline.NewLibrarySource(stc.library(func(model.Song) bool { return true }), true), nil
+
+	case "filter":
+		if request.Artist == "" && request.FileType == "" {
+			return nil, errors.New("a filter needs an artist or a file type")
+		}
+
+		matches := func(song model.Song) bool {
+			return (request.Artist == "" || strings.EqualFold(song.Artist, request.Artist)) &&
+				(request.FileType == "" || strings.EqualFold(song.FileType, request.FileType))
+		}
+		return audiopipeline.NewLibrarySource(stc.library(matches), request.Shuffle), nil
+	}
+
+	return nil, errors.New("mode must be one of playlist, shuffle or filter")
+}
+
+// library returns the songs in the repository that match, leaving out those missing their file.
+func (stc *StationController) library(matches func(model.Song) bool) audiopipeline.Library {
+	return func() ([]audiopipeline.Track, error) {
+		songs, err := stc.songs.List()
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+
+		var tracks []audiopipeline.Track
+		for _, song := range songs {
+			if !song.Missing && matches(song) {
+				tracks = append(tracks, songTrack(song))
+			}
+		}
+		return tracks, nil
+	}
+}
+
+// loadTrack reads the frames of a track's song, which may have changed or been removed since it was queued.
+func (stc *StationController) loadTrack(track audiopipeline.Track) ([]audiopipeline.Frame, error) {
+	song, err := stc.songs.Get(track.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := stc.store.Open(song.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return audiopipeline.ParseFrames(file)
+}
+
+func songTrack(song model.Song) audiopipeline.Track {
+	return audiopipeline.Track{
+		ID:       song.ID,
+		Title:    song.Title,
+		Artist:   song.Artist,
+		FileType: song.FileType,
+	}
+}
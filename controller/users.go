@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"infiniti.com/internal/auth"
+	"infiniti.com/internal/database"
+)
+
+// NewUser is what an admin sends to create a user, listener unless another role is given.
+type NewUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// UserPatch is what an admin sends to change a user.
+type UserPatch struct {
+	Role string `json:"role"`
+}
+
+// GetRoles responds with every role and its permissions.
+func (ac *AuthController) GetRoles(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, auth.Roles())
+}
+
+// GetUsers responds with every user.
+func (ac *AuthController) GetUsers(c *gin.Context) {
+	users, err := ac.auth.Users()
+	if err != nil {
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not list users")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, users)
+}
+
+// GetUser responds with the user whose id is in the path.
+func (ac *AuthController) GetUser(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		userError(c, err)
+		return
+	}
+
+	user, err := ac.auth.User(id)
+	if err != nil {
+		userError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, user)
+}
+
+// CreateUser creates a user with the role in the body, whether or not registration is open.
+func (ac *AuthController) CreateUser(c *gin.Context) {
+	var request NewUser
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, "invalid user", gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := ac.auth.CreateUser(request.Username, request.Password, cmp.Or(request.Role, auth.LISTENER))
+	if err != nil {
+		userError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, user)
+}
+
+// UpdateUser gives the user whose id is in the path the role in the body.
+func (ac *AuthController) UpdateUser(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		userError(c, err)
+		return
+	}
+
+	var patch UserPatch
+	err = c.ShouldBindJSON(&patch)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, "invalid user", gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := ac.auth.SetRole(id, patch.Role)
+	if err != nil {
+		userError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, user)
+}
+
+// DeleteUser deletes the user whose id is in the path, along with their API keys.
+func (ac *AuthController) DeleteUser(c *gin.Context) {
+	id, err := userID(c)
+	if err == nil {
+		err = ac.auth.DeleteUser(id)
+	}
+	if err != nil {
+		userError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+/**
+	Private functions
+**/
+
+// userID returns the user id in the path, ErrUserNotFound if it isn't one.
+func userID(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil || id == 0 {
+		return 0, database.ErrUserNotFound
+	}
+	return uint(id), nil
+}
+
+// userError responds with the status for an error managing users.
+func userError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		respondError(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, auth.ErrInvalidUsername), errors.Is(err, auth.ErrInvalidPassword), errors.Is(err, auth.ErrUnknownRole):
+		respondError(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, database.ErrUserExists), errors.Is(err, auth.ErrLastAdmin):
+		respondError(c, http.StatusConflict, err.Error())
+	default:
+		log.Println(err)
+		respondError(c, http.StatusInternalServerError, "could not manage users")
+	}
+}
@@ -8,7 +8,6 @@
 package audiopipeline
 
 import (
-	"bytes"
 	"io"
 	"log"
 	"net/http"
@@ -21,18 +20,29 @@ import (
 const (
 	BUFFERSIZE = 16384
 
-	// hardcoded delay for if track length hasn't been provided correctly
-	DELAY         = 150
-	PRODUCTFACTOR = 8
+	// PRELOAD is how far ahead of real time frames are sent, so listeners have
+	// some audio buffered to cover network hiccups.
+	PRELOAD = time.Second
+
+	// CONNECTIONBACKLOG is the amount of chunks queued for a listener before
+	// chunks are dropped for that listener.
+	CONNECTIONBACKLOG = 16
 )
 
+// BufferSize is the largest chunk in bytes broadcast to listeners, although a
+// chunk always holds at least one whole frame. It should only be changed
+// before the first stream is started.
+var BufferSize = BUFFERSIZE
+
 type Connection struct {
 	bufferChannel chan []byte
-	buffer        []byte
 }
 
 type ConnectionPool struct {
 	ConnectionMap map[*Connection]struct{}
+	metadata      Metadata
+	segmenter     *Segmenter
+	done          chan struct{}
 	mu            sync.Mutex
 }
 
@@ -53,55 +63,314 @@ func (cp *ConnectionPool) Broadcast(buffer []byte) {
 	defer cp.mu.Unlock()
 	cp.mu.Lock()
 
+	// the streamer reuses its buffer, so listeners get a copy that is only read from
+	chunk := make([]byte, len(buffer))
+	copy(chunk, buffer)
+
 	for connection := range cp.ConnectionMap {
-		copy(connection.buffer, buffer)
 		select {
-		case connection.bufferChannel <- connection.buffer:
+		case connection.bufferChannel <- chunk:
 		default:
 		}
 	}
 }
 
+// SetMetadata updates what the pool is playing. ICY clients pick up the new
+// title with the next metadata block.
+func (cp *ConnectionPool) SetMetadata(metadata Metadata) {
+	defer cp.mu.Unlock()
+	cp.mu.Lock()
+	cp.metadata = metadata
+}
+
+func (cp *ConnectionPool) Metadata() Metadata {
+	defer cp.mu.Unlock()
+	cp.mu.Lock()
+	return cp.metadata
+}
+
+// Count returns the amount of connections currently in the pool.
+func (cp *ConnectionPool) Count() int {
+	defer cp.mu.Unlock()
+	cp.mu.Lock()
+	return len(cp.ConnectionMap)
+}
+
+// SetSegmenter makes the pool cut everything it broadcasts into HLS segments as well.
+func (cp *ConnectionPool) SetSegmenter(segmenter *Segmenter) {
+	defer cp.mu.Unlock()
+	cp.mu.Lock()
+
+	cp.segmenter = segmenter
+}
+
+// Segmenter returns the pool's HLS segmenter, or nil if it has none.
+func (cp *ConnectionPool) Segmenter() *Segmenter {
+	defer cp.mu.Unlock()
+	cp.mu.Lock()
+
+	return cp.segmenter
+}
+
+// Close disconnects every listener of the pool, for when its station goes away for good.
+func (cp *ConnectionPool) Close() {
+	defer cp.mu.Unlock()
+	cp.mu.Lock()
+
+	select {
+	case <-cp.done:
+	default:
+		close(cp.done)
+	}
+}
+
 func NewConnectionPool() *ConnectionPool {
 	connectionMap := make(map[*Connection]struct{})
-	return &ConnectionPool{ConnectionMap: connectionMap}
+	return &ConnectionPool{ConnectionMap: connectionMap, done: make(chan struct{})}
+}
+
+// IDLE is how long a station waits before checking its queue again when
+// there is nothing to play.
+const IDLE = 5 * time.Second
+
+// Loader loads the frames of a track.
+type Loader func(track Track) ([]Frame, error)
+
+// streamer broadcasts frames to a connection pool in chunks of whole frames.
+// Every chunk is scheduled against the wall clock by the play time of all
+// frames before it, so the stream neither drifts nor runs ahead, also across tracks.
+type streamer struct {
+	pool       *ConnectionPool
+	remote     *Remote
+	bufferSize int
+	buffer     []byte
+
+	start   time.Time
+	elapsed time.Duration
+	timer   *time.Timer
+
+	// metadata is applied to the pool along with the next chunk, when a new track starts
+	metadata *Metadata
+}
+
+// the outcomes of waiting for a chunk to be due
+const (
+	due = iota
+	stopped
+	interrupted
+)
+
+func newStreamer(connectionPool *ConnectionPool, remote *Remote) *streamer {
+	bufferSize := BufferSize
+	return &streamer{
+		pool:       connectionPool,
+		remote:     remote,
+		bufferSize: bufferSize,
+		buffer:     make([]byte, 0, bufferSize),
+		start:      time.Now(),
+		timer:      time.NewTimer(0),
+	}
 }
 
-func Stream(connectionPool *ConnectionPool, content []byte, track_length float32) {
-	buffer := make([]byte, BUFFERSIZE)
+// resync restarts the clock, after the stream has been silent for a while.
+func (s *streamer) resync() {
+	s.start = time.Now()
+	s.elapsed = 0
+}
+
+// wait waits until the next chunk is due, stop is closed or the remote was used.
+func (s *streamer) wait(stop <-chan struct{}) int {
+	s.timer.Reset(time.Until(s.start.Add(s.elapsed - PRELOAD)))
+
+	select {
+	case <-s.timer.C:
+		return due
+	case <-stop:
+		return stopped
+	case <-s.remote.wake:
+	}
+
+	if !s.timer.Stop() {
+		<-s.timer.C
+	}
+	return interrupted
+}
+
+// broadcast sends a chunk of frames that is due and moves the clock on by its play time.
+func (s *streamer) broadcast(frames []Frame) {
+	s.buffer = s.buffer[:0]
+	for _, frame := range frames {
+		s.buffer = append(s.buffer, frame.Data...)
+		s.elapsed += frame.Duration
+	}
+
+	if s.metadata != nil {
+		s.pool.SetMetadata(*s.metadata)
+		s.metadata = nil
+	}
+	s.pool.Broadcast(s.buffer)
+
+	if segmenter := s.pool.Segmenter(); segmenter != nil {
+		segmenter.Write(frames)
+	}
+}
+
+// play broadcasts the frames once, or until the remote skips them. It
+// returns false if stop was closed before all frames were sent.
+func (s *streamer) play(frames []Frame, stop <-chan struct{}) bool {
+	for i := 0; i < len(frames); {
+		if paused, _ := s.remote.Paused(); paused {
+			if !s.hold(frames[i], stop) {
+				return false
+			}
+		}
+		if s.remote.skipped() {
+			return true
+		}
+
+		// gather as many whole frames as fit in a chunk
+		first := i
+		size := 0
+		for ; i < len(frames); i++ {
+			if size > 0 && size+len(frames[i].Data) > s.bufferSize {
+				break
+			}
+			size += len(frames[i].Data)
+		}
+
+		switch s.wait(stop) {
+		case stopped:
+			return false
+		case interrupted:
+			// the chunk is sent again once the remote has had its say
+			i = first
+			continue
+		}
+
+		s.broadcast(frames[first:i])
+	}
+
+	return true
+}
+
+// hold keeps the stream paused at the frame, until playback is resumed or
+// skipped. It returns false if stop was closed in the meantime.
+func (s *streamer) hold(frame Frame, stop <-chan struct{}) bool {
+	silence, ok := silentFrame(frame)
+	var silences []Frame
 
 	for {
-		clear(buffer)
-		tempfile := bytes.NewReader(content)
+		paused, withSilence := s.remote.Paused()
+		if !paused || s.remote.skipping() {
+			return true
+		}
 
-		ticker := time.NewTicker(time.Millisecond * DELAY)
+		// listeners are held without data, so the clock starts over once playback resumes
+		if !withSilence || !ok {
+			select {
+			case <-stop:
+				return false
+			case <-s.remote.wake:
+			}
+			s.resync()
+			continue
+		}
 
-		if track_length != 0.0 {
-			timer := time.Duration(float32(time.Millisecond) * float32(track_length*float32(BUFFERSIZE)/float32(len(content))) * PRODUCTFACTOR)
-			// log.Printf("Time: %v, Track Length: %v, Buffer Size: %v, Content Length: %v, time.Millisecond * DELAY: %v", timer, track_length, BUFFERSIZE, len(content), time.Millisecond*DELAY)
-			ticker = time.NewTicker(timer)
+		if len(silences) == 0 {
+			silences = make([]Frame, max(1, s.bufferSize/len(silence.Data)))
+			for i := range silences {
+				silences[i] = silence
+			}
 		}
 
-		for range ticker.C {
-			_, err := tempfile.Read(buffer)
+		switch s.wait(stop) {
+		case stopped:
+			return false
+		case interrupted:
+			continue
+		}
 
-			if err == io.EOF {
-				ticker.Stop()
-				break
+		s.broadcast(silences)
+	}
+}
+
+// Stream broadcasts the frames to the connection pool on repeat until stop is closed.
+func Stream(connectionPool *ConnectionPool, frames []Frame, stop <-chan struct{}) {
+	if len(frames) == 0 {
+		log.Println("Nothing to stream")
+		return
+	}
+
+	s := newStreamer(connectionPool, NewRemote())
+	defer s.timer.Stop()
+
+	for s.play(frames, stop) {
+	}
+}
+
+// StreamQueue broadcasts the tracks of the queue one after another until stop
+// is closed, updating the pool's metadata whenever a new track starts. The
+// remote skips, pauses and resumes the tracks as they play.
+func StreamQueue(connectionPool *ConnectionPool, queue *Queue, remote *Remote, load Loader, stop <-chan struct{}) {
+	s := newStreamer(connectionPool, remote)
+	defer s.timer.Stop()
+
+	failures := 0
+
+	for {
+		track, ok := queue.Advance()
+
+		var frames []Frame
+		var err error
+		if ok {
+			frames, err = load(track)
+			if err != nil {
+				log.Printf("Skipping '%s': %v\n", track.Title, err)
+				failures++
+			}
+		}
+
+		// wait for something playable to come up rather than spinning
+		if !ok || (err != nil && failures > UPCOMING) {
+			select {
+			case <-stop:
+				return
+			case <-queue.changed:
+			case <-time.After(IDLE):
 			}
-			connectionPool.Broadcast(buffer)
+			s.resync()
+			failures = 0
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		failures = 0
+
+		// a skip that came in between tracks must not cut the next one short
+		remote.skipped()
+
+		metadata := connectionPool.Metadata()
+		metadata.Title = StreamTitle(track.Artist, track.Title)
+		metadata.Bitrate = AverageBitrate(frames)
+		s.metadata = &metadata
+
+		if !s.play(frames, stop) {
+			return
 		}
 	}
 }
 
 func MakeConnection() *Connection {
-	return &Connection{bufferChannel: make(chan []byte), buffer: make([]byte, BUFFERSIZE)}
+	return &Connection{bufferChannel: make(chan []byte, CONNECTIONBACKLOG)}
 }
 
-func GetConnectionBuffers(conn *Connection) (chan []byte, []byte) {
-	return conn.bufferChannel, conn.buffer
+func GetConnectionBuffer(conn *Connection) chan []byte {
+	return conn.bufferChannel
 }
 
+// PlayAudiofile sends the pool's broadcast to the client until it disconnects.
+// Clients sending "Icy-MetaData: 1" get the current title interleaved in the audio.
 func PlayAudiofile(connPool *ConnectionPool, filetype string, c *gin.Context) {
 	w := c.Writer
 
@@ -120,16 +389,44 @@ func PlayAudiofile(connPool *ConnectionPool, filetype string, c *gin.Context) {
 	connPool.AddConnection(connection)
 	log.Printf("%s has connected to the audio stream\n", r.Host)
 
+	defer connPool.DeleteConnection(connection)
+
+	metaint := 0
+	if r.Header.Get("Icy-MetaData") == "1" {
+		metaint = ICYMETAINT
+	}
+
+	var out io.Writer = w
+	headersWritten := false
+
 	for {
 		// Receive data from the buffer channel
-		bufferChannel, buffer := GetConnectionBuffers(connection)
-		buf := <-bufferChannel
-		if _, err := w.Write(buf); err != nil {
-			connPool.DeleteConnection(connection)
-			log.Printf("%s's connection to the audio stream has been closed\\n", r.Host)
+		bufferChannel := GetConnectionBuffer(connection)
+
+		var buf []byte
+		select {
+		case <-r.Context().Done():
+			log.Printf("%s's connection to the audio stream has been closed\n", r.Host)
+			return
+		case <-connPool.done:
+			log.Printf("%s has been disconnected, the station went off air\n", r.Host)
+			return
+		case buf = <-bufferChannel:
+		}
+
+		// the station's metadata is complete once it broadcasts, so headers wait for the first chunk
+		if !headersWritten {
+			writeICYHeaders(w.Header(), connPool.Metadata(), metaint)
+			if metaint > 0 {
+				out = newICYWriter(w, metaint, func() string { return connPool.Metadata().Title })
+			}
+			headersWritten = true
+		}
+
+		if _, err := out.Write(buf); err != nil {
+			log.Printf("%s's connection to the audio stream has been closed\n", r.Host)
 			return
 		}
 		flusher.Flush()
-		clear(buffer)
 	}
 }
@@ -1,3 +1,731 @@
 package audiopipeline
 
-// TODO - Add tests for the audiopipeline package
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestStationRegistryShareStation(t *testing.T) {
+	registry := NewStationRegistry()
+
+	var started atomic.Int32
+	start := func(pool *ConnectionPool, stop <-chan struct{}) {
+		started.Add(1)
+		<-stop
+	}
+
+	first := registry.Join("song:1", start)
+	second := registry.Join("song:1", start)
+
+	if first != second {
+		t.Errorf("Expected listeners of the same song to share a station")
+	}
+	if first.Pool != second.Pool {
+		t.Errorf("Expected listeners of the same song to share a connection pool")
+	}
+
+	other := registry.Join("song:2", start)
+	if other == first {
+		t.Errorf("Expected different songs to get different stations")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if started.Load() != 2 {
+		t.Errorf("Expected 2 streams to be started; got %d", started.Load())
+	}
+
+	registry.Leave(first)
+	registry.Leave(second)
+	registry.Leave(other)
+}
+
+func TestStationRegistryStopsWhenEmpty(t *testing.T) {
+	registry := NewStationRegistry()
+
+	stopped := make(chan struct{})
+	start := func(pool *ConnectionPool, stop <-chan struct{}) {
+		<-stop
+		close(stopped)
+	}
+
+	first := registry.Join("song:1", start)
+	second := registry.Join("song:1", start)
+
+	registry.Leave(first)
+	if _, ok := registry.Get("song:1"); !ok {
+		t.Errorf("Expected station to stay on air while it has listeners")
+	}
+
+	registry.Leave(second)
+	if _, ok := registry.Get("song:1"); ok {
+		t.Errorf("Expected station to go off air after the last listener left")
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Errorf("Expected stream to be stopped after the last listener left")
+	}
+
+	third := registry.Join("song:1", start)
+	if third == first {
+		t.Errorf("Expected a fresh station after the previous one went off air")
+	}
+}
+
+func TestStreamStops(t *testing.T) {
+	pool := NewConnectionPool()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		Stream(pool, testFrames(100, 2, 50*time.Millisecond), stop)
+		close(done)
+	}()
+
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Expected Stream to return after stop was closed")
+	}
+}
+
+const TEST_SONG = "../../resources/test_songs/Recording.mp3"
+
+// testFrames creates frames of the given size, with the index of the frame as contents.
+func testFrames(count int, size int, duration time.Duration) []Frame {
+	frames := make([]Frame, count)
+	for i := range frames {
+		frames[i] = Frame{Data: bytes.Repeat([]byte{byte(i)}, size), Duration: duration}
+	}
+	return frames
+}
+
+func TestParseFrames(t *testing.T) {
+	content, err := os.ReadFile(TEST_SONG)
+	if err != nil {
+		t.Fatalf("Failed to read test song: %v", err)
+	}
+
+	frames, err := ParseFrames(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to parse frames: %v", err)
+	}
+
+	size := 0
+	for _, frame := range frames {
+		if frame.Data[0] != 0xFF || frame.Data[1]&0xE0 != 0xE0 {
+			t.Fatalf("Expected every frame to start with a sync word")
+		}
+		if frame.Duration <= 0 || frame.Bitrate <= 0 {
+			t.Fatalf("Expected every frame to have a duration and bitrate; got %+v", frame)
+		}
+		size += len(frame.Data)
+	}
+
+	if size > len(content) {
+		t.Errorf("Expected frames to not exceed the file; got %d of %d bytes", size, len(content))
+	}
+	if Duration(frames) <= 0 {
+		t.Errorf("Expected a play time; got %v", Duration(frames))
+	}
+
+	_, err = ParseFrames(bytes.NewReader([]byte("not an mp3 file")))
+	if err == nil {
+		t.Errorf("Expected an error for content without frames")
+	}
+}
+
+func TestStreamSendsWholeFrames(t *testing.T) {
+	defer func(size int) { BufferSize = size }(BufferSize)
+	BufferSize = 7
+
+	pool := NewConnectionPool()
+	connection := MakeConnection()
+	pool.AddConnection(connection)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go Stream(pool, testFrames(10, 3, time.Millisecond), stop)
+
+	for i := 0; i < 5; i++ {
+		chunk := <-GetConnectionBuffer(connection)
+		if len(chunk) == 0 || len(chunk)%3 != 0 || len(chunk) > BufferSize {
+			t.Errorf("Expected chunks of whole frames within the buffer size; got %d bytes", len(chunk))
+		}
+	}
+}
+
+func TestStreamPacesRealTime(t *testing.T) {
+	pool := NewConnectionPool()
+	connection := MakeConnection()
+	pool.AddConnection(connection)
+
+	// 2 seconds of audio in chunks of a single frame
+	frames := testFrames(40, BufferSize, 50*time.Millisecond)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	start := time.Now()
+	go Stream(pool, frames, stop)
+
+	arrivals := make(map[byte]time.Duration)
+	for len(arrivals) < len(frames) {
+		select {
+		case chunk := <-GetConnectionBuffer(connection):
+			// the stream repeats, only the first time a frame is sent counts
+			if _, ok := arrivals[chunk[0]]; !ok {
+				arrivals[chunk[0]] = time.Since(start)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected all frames to be sent; got %d", len(arrivals))
+		}
+	}
+
+	if arrivals[0] > 100*time.Millisecond {
+		t.Errorf("Expected the first frames to be sent right away; got %v", arrivals[0])
+	}
+
+	// the last frame starts playing at 1.95s and is sent PRELOAD ahead of that
+	due := 39*50*time.Millisecond - PRELOAD
+	last := arrivals[39]
+	if last < due-20*time.Millisecond || last > due+200*time.Millisecond {
+		t.Errorf("Expected the last frame around %v; got %v", due, last)
+	}
+}
+
+// readICY splits an ICY stream into its audio and the metadata blocks found in it.
+func readICY(t *testing.T, stream []byte, metaint int) ([]byte, []string) {
+	var audio []byte
+	var metadata []string
+
+	for len(stream) > 0 {
+		n := min(metaint, len(stream))
+		audio = append(audio, stream[:n]...)
+		stream = stream[n:]
+		if len(stream) == 0 {
+			break
+		}
+
+		length := int(stream[0]) * 16
+		if len(stream) < 1+length {
+			t.Fatalf("Metadata block of %d bytes is cut off", length)
+		}
+		metadata = append(metadata, string(bytes.TrimRight(stream[1:1+length], "\x00")))
+		stream = stream[1+length:]
+	}
+
+	return audio, metadata
+}
+
+func TestICYWriter(t *testing.T) {
+	var out bytes.Buffer
+	title := "Artist - First"
+	writer := newICYWriter(&out, 10, func() string { return title })
+
+	audio := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	writer.Write(audio[:7])
+	writer.Write(audio[7:25])
+	title = "Artist - Rock 'n' Roll"
+	writer.Write(audio[25:])
+
+	received, metadata := readICY(t, out.Bytes(), 10)
+	if !bytes.Equal(received, audio) {
+		t.Errorf("Expected the audio to be unchanged; got %q", received)
+	}
+
+	expected := []string{"StreamTitle='Artist - First';", "", "StreamTitle='Artist - Rock ’n’ Roll';"}
+	if len(metadata) != len(expected) {
+		t.Fatalf("Expected %d metadata blocks; got %q", len(expected), metadata)
+	}
+	for i := range expected {
+		if metadata[i] != expected[i] {
+			t.Errorf("Expected metadata block %q; got %q", expected[i], metadata[i])
+		}
+	}
+}
+
+func TestICYWriterLongTitle(t *testing.T) {
+	var out bytes.Buffer
+	writer := newICYWriter(&out, 10, func() string { return strings.Repeat("é", 3000) })
+	writer.Write([]byte("0123456789"))
+
+	_, metadata := readICY(t, out.Bytes(), 10)
+	if len(metadata) != 1 {
+		t.Fatalf("Expected 1 metadata block; got %d", len(metadata))
+	}
+	if len(metadata[0]) > icyMaxMetadata || !strings.HasSuffix(metadata[0], "é';") || !utf8.ValidString(metadata[0]) {
+		t.Errorf("Expected the title to be cut between characters; got %d bytes ending in %q", len(metadata[0]), metadata[0][len(metadata[0])-4:])
+	}
+}
+
+func TestStreamTitle(t *testing.T) {
+	if title := StreamTitle("Queen", "Bohemian Rhapsody"); title != "Queen - Bohemian Rhapsody" {
+		t.Errorf("Unexpected title %q", title)
+	}
+	if title := StreamTitle("", "Recording"); title != "Recording" {
+		t.Errorf("Unexpected title %q", title)
+	}
+}
+
+func testTracks(count int) []Track {
+	tracks := make([]Track, count)
+	for i := range tracks {
+		tracks[i] = Track{ID: uint(i + 1), Title: fmt.Sprintf("Track %d", i+1)}
+	}
+	return tracks
+}
+
+func TestPlaylistSource(t *testing.T) {
+	source := NewPlaylistSource(testTracks(2))
+
+	for _, expected := range []uint{1, 2, 1} {
+		track, ok := source.Next()
+		if !ok || track.ID != expected {
+			t.Errorf("Expected track %d; got %+v, %v", expected, track, ok)
+		}
+	}
+
+	if _, ok := NewPlaylistSource(nil).Next(); ok {
+		t.Errorf("Expected an empty playlist to have nothing to play")
+	}
+}
+
+func TestLibrarySourceShuffle(t *testing.T) {
+	loads := 0
+	source := NewLibrarySource(func() ([]Track, error) {
+		loads++
+		return testTracks(10), nil
+	}, true)
+
+	var last uint
+	for round := 0; round < 20; round++ {
+		played := make(map[uint]bool)
+		for i := 0; i < 10; i++ {
+			track, ok := source.Next()
+			if !ok {
+				t.Fatalf("Expected a track to play")
+			}
+			if track.ID == last {
+				t.Fatalf("Expected no track to be played twice in a row; got %d", track.ID)
+			}
+			played[track.ID] = true
+			last = track.ID
+		}
+		if len(played) != 10 {
+			t.Fatalf("Expected every track to be played once per round; got %v", played)
+		}
+	}
+
+	if loads != 20 {
+		t.Errorf("Expected the library to be loaded every round; got %d loads", loads)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	queue := NewQueue(NewPlaylistSource(testTracks(2)))
+
+	if _, ok := queue.Current(); ok {
+		t.Errorf("Expected nothing to be playing before advancing")
+	}
+
+	track, ok := queue.Advance()
+	current, _ := queue.Current()
+	if !ok || track.ID != 1 || current.ID != 1 {
+		t.Errorf("Expected the first track to be playing; got %+v, %+v", track, current)
+	}
+
+	upcoming := queue.Upcoming()
+	if len(upcoming) != UPCOMING || upcoming[0].ID != 2 || upcoming[1].ID != 1 {
+		t.Errorf("Expected the playlist to come up next; got %+v", upcoming)
+	}
+
+	empty := NewQueue(NewPlaylistSource(nil))
+	if _, ok := empty.Advance(); ok {
+		t.Errorf("Expected an empty queue to have nothing to play")
+	}
+}
+
+func TestStreamQueue(t *testing.T) {
+	pool := NewConnectionPool()
+	connection := MakeConnection()
+	pool.AddConnection(connection)
+	pool.SetMetadata(Metadata{Name: "Station"})
+
+	queue := NewQueue(NewPlaylistSource(testTracks(3)))
+	load := func(track Track) ([]Frame, error) {
+		if track.ID == 2 {
+			return nil, errors.New("broken file")
+		}
+		return []Frame{{Data: []byte{byte(track.ID)}, Duration: time.Second}}, nil
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go StreamQueue(pool, queue, NewRemote(), load, stop)
+
+	// the track that fails to load is skipped
+	for _, expected := range []byte{1, 3} {
+		select {
+		case chunk := <-GetConnectionBuffer(connection):
+			if !bytes.Equal(chunk, []byte{expected}) {
+				t.Errorf("Expected track %d to be broadcast; got %v", expected, chunk)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected track %d to be broadcast", expected)
+		}
+	}
+
+	metadata := pool.Metadata()
+	if metadata.Title != "Track 3" || metadata.Name != "Station" {
+		t.Errorf("Expected the title of the playing track next to the station name; got %+v", metadata)
+	}
+}
+
+func TestStationRegistryStreamEnds(t *testing.T) {
+	registry := NewStationRegistry()
+
+	// a stream that ends on its own, like that of a song that can't be read
+	station := registry.Join("song:1", func(pool *ConnectionPool, stop <-chan struct{}) {})
+	defer registry.Leave(station)
+
+	select {
+	case <-station.Pool.done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the listeners to be disconnected once the stream ended")
+	}
+	if _, ok := registry.Get("song:1"); ok {
+		t.Errorf("Expected the station to go off air once its stream ended")
+	}
+
+	// the next listener starts the stream again
+	started := make(chan struct{})
+	again := registry.Join("song:1", func(pool *ConnectionPool, stop <-chan struct{}) {
+		close(started)
+		<-stop
+	})
+	defer registry.Leave(again)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the stream to be started again")
+	}
+}
+
+func TestStationRegistryCreate(t *testing.T) {
+	registry := NewStationRegistry()
+	started := make(chan struct{}, 2)
+	start := func(connectionPool *ConnectionPool, stop <-chan struct{}) {
+		started <- struct{}{}
+		<-stop
+	}
+
+	_, err := registry.Create("office", NewQueue(NewPlaylistSource(nil)), NewRemote(), nil, start)
+	if err != nil {
+		t.Fatalf("Failed to create station: %v", err)
+	}
+	_, err = registry.Create("office", NewQueue(NewPlaylistSource(nil)), NewRemote(), nil, start)
+	if !errors.Is(err, ErrStationExists) {
+		t.Errorf("Expected ErrStationExists; got %v", err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the station to go on air without listeners")
+	}
+
+	station, err := registry.Tune("office")
+	if err != nil {
+		t.Fatalf("Failed to tune in: %v", err)
+	}
+	registry.Leave(station)
+
+	// created stations stay on air without listeners
+	if infos := registry.List(); len(infos) != 1 || infos[0].Name != "office" {
+		t.Errorf("Expected the station to stay on air; got %+v", infos)
+	}
+
+	if err := registry.Delete("office"); err != nil {
+		t.Fatalf("Failed to delete station: %v", err)
+	}
+	if _, err := registry.Tune("office"); !errors.Is(err, ErrStationNotFound) {
+		t.Errorf("Expected ErrStationNotFound after deleting; got %v", err)
+	}
+
+	// the stations of single songs can't be tuned in to or deleted by name
+	song := registry.Join("song:1", start)
+	defer registry.Leave(song)
+	if _, err := registry.Tune("song:1"); !errors.Is(err, ErrStationNotFound) {
+		t.Errorf("Expected ErrStationNotFound for a song's station; got %v", err)
+	}
+	if err := registry.Delete("song:1"); !errors.Is(err, ErrStationNotFound) {
+		t.Errorf("Expected ErrStationNotFound for a song's station; got %v", err)
+	}
+}
+
+func TestQueueEnqueueRemoveMove(t *testing.T) {
+	queue := NewQueue(NewPlaylistSource(testTracks(1)))
+	queue.Advance()
+
+	first := queue.Enqueue(Track{ID: 10})
+	second := queue.Enqueue(Track{ID: 11})
+
+	upcoming := queue.Upcoming()
+	if upcoming[0].EntryID != first.EntryID || upcoming[1].EntryID != second.EntryID || upcoming[2].Requested {
+		t.Fatalf("Expected requested tracks in order before the picked ones; got %+v", upcoming)
+	}
+
+	err := queue.Move(second.EntryID, 0)
+	if err != nil {
+		t.Fatalf("Failed to move entry: %v", err)
+	}
+	if upcoming := queue.Upcoming(); upcoming[0].ID != 11 || upcoming[1].ID != 10 {
+		t.Errorf("Expected the moved entry to play next; got %+v", upcoming)
+	}
+
+	err = queue.Move(second.EntryID, 100)
+	if upcoming := queue.Upcoming(); err != nil || upcoming[len(upcoming)-1].ID != 11 {
+		t.Errorf("Expected moving past the end to move the entry last; got %+v, %v", upcoming, err)
+	}
+
+	err = queue.Remove(first.EntryID)
+	if err != nil {
+		t.Fatalf("Failed to remove entry: %v", err)
+	}
+	if upcoming := queue.Upcoming(); len(upcoming) < UPCOMING || slices.ContainsFunc(upcoming, func(entry Entry) bool { return entry.ID == 10 }) {
+		t.Errorf("Expected the entry to be removed and the queue topped up; got %+v", upcoming)
+	}
+
+	if err := queue.Remove(first.EntryID); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("Expected ErrEntryNotFound when removing twice; got %v", err)
+	}
+	if err := queue.Move(first.EntryID, 0); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("Expected ErrEntryNotFound when moving a removed entry; got %v", err)
+	}
+}
+
+func TestSilentFrame(t *testing.T) {
+	frames := mp3Frames(1, 1)
+
+	silence, ok := silentFrame(frames[0])
+	if !ok || len(silence.Data) != len(frames[0].Data) || silence.Duration != frames[0].Duration {
+		t.Fatalf("Expected a silent frame as long as the frame; got %+v, %v", silence, ok)
+	}
+	if !isSilent(silence.Data) || silence.Data[1]&0x1 != 0x1 {
+		t.Errorf("Expected a header without CRC and nothing but zeroes; got %v", silence.Data)
+	}
+
+	if _, ok := silentFrame(testFrames(1, 10, time.Second)[0]); ok {
+		t.Errorf("Expected no silent frame for data without a header")
+	}
+}
+
+// mp3Frames returns single chunk frames with MPEG-1 layer III headers, marked with the track and their index.
+func mp3Frames(track byte, count int) []Frame {
+	frames := make([]Frame, count)
+	for i := range frames {
+		data := bytes.Repeat([]byte{0xAA}, 100)
+		copy(data, []byte{0xFF, 0xFB, 0x90, 0x00, track, byte(i)})
+		frames[i] = Frame{Data: data, Duration: 200 * time.Millisecond}
+	}
+	return frames
+}
+
+func isSilent(frame []byte) bool {
+	return bytes.Count(frame[4:], []byte{0}) == len(frame)-4
+}
+
+// streamRemote streams two tracks of mp3Frames through a remote and returns the connection receiving them.
+func streamRemote(t *testing.T, remote *Remote) *Connection {
+	size := BufferSize
+	BufferSize = 100
+	t.Cleanup(func() { BufferSize = size })
+
+	pool := NewConnectionPool()
+	connection := MakeConnection()
+	pool.AddConnection(connection)
+
+	queue := NewQueue(NewPlaylistSource(testTracks(2)))
+	load := func(track Track) ([]Frame, error) {
+		return mp3Frames(byte(track.ID), 40), nil
+	}
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go StreamQueue(pool, queue, remote, load, stop)
+
+	return connection
+}
+
+func receive(t *testing.T, connection *Connection) []byte {
+	select {
+	case chunk := <-GetConnectionBuffer(connection):
+		return chunk
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected a chunk to be broadcast")
+		return nil
+	}
+}
+
+func TestStreamQueueSkip(t *testing.T) {
+	remote := NewRemote()
+	connection := streamRemote(t, remote)
+
+	receive(t, connection)
+	remote.Skip()
+
+	for chunk := receive(t, connection); chunk[4] == 1; chunk = receive(t, connection) {
+		if chunk[5] >= 10 {
+			t.Fatalf("Expected the first track to be skipped; got frame %d", chunk[5])
+		}
+	}
+}
+
+func TestStreamQueuePauseSilence(t *testing.T) {
+	remote := NewRemote()
+	connection := streamRemote(t, remote)
+
+	receive(t, connection)
+	remote.Pause(true)
+
+	// frames sent ahead before the pause are followed by silence
+	last := byte(0)
+	chunk := receive(t, connection)
+	for ; !isSilent(chunk); chunk = receive(t, connection) {
+		last = chunk[5]
+	}
+	if last >= 10 {
+		t.Fatalf("Expected the pause to take effect right away; got frame %d", last)
+	}
+
+	remote.Resume()
+
+	for ; isSilent(chunk); chunk = receive(t, connection) {
+	}
+	if chunk[4] != 1 || chunk[5] != last+1 {
+		t.Errorf("Expected playback to resume at frame %d; got track %d frame %d", last+1, chunk[4], chunk[5])
+	}
+}
+
+func TestStreamQueuePauseHold(t *testing.T) {
+	remote := NewRemote()
+	connection := streamRemote(t, remote)
+
+	last := receive(t, connection)[5]
+	remote.Pause(false)
+
+	// listeners get no data at all while held
+	for held := false; !held; {
+		select {
+		case chunk := <-GetConnectionBuffer(connection):
+			last = chunk[5]
+		case <-time.After(500 * time.Millisecond):
+			held = true
+		}
+	}
+
+	remote.Resume()
+
+	chunk := receive(t, connection)
+	if chunk[4] != 1 || chunk[5] != last+1 {
+		t.Errorf("Expected playback to resume at frame %d; got track %d frame %d", last+1, chunk[4], chunk[5])
+	}
+}
+
+func TestSegmenter(t *testing.T) {
+	// frames of 200ms make segments of 2 frames
+	segmenter := NewSegmenter(400*time.Millisecond, 2, 1)
+	segmenter.Write(mp3Frames(1, 10)[:9])
+
+	window := segmenter.Window()
+	if len(window) != 2 || window[0].Sequence != 2 || window[1].Sequence != 3 {
+		t.Fatalf("Expected the last 2 of 4 segments in the window; got %+v", window)
+	}
+
+	segmenter.Flush()
+	window = segmenter.Window()
+	last := window[len(window)-1]
+	if last.Sequence != 4 || last.Duration != 200*time.Millisecond || last.Start != 1600*time.Millisecond {
+		t.Errorf("Expected the remaining frame to be flushed into a short segment; got %+v", last)
+	}
+
+	// one segment is retained after dropping out of the window
+	if _, ok := segmenter.Segment(2); !ok {
+		t.Errorf("Expected segment 2 to be retained")
+	}
+	if _, ok := segmenter.Segment(1); ok {
+		t.Errorf("Expected segment 1 to be dropped")
+	}
+	if _, ok := segmenter.Segment(5); ok {
+		t.Errorf("Expected no segment beyond the last one")
+	}
+
+	segment, _ := segmenter.Segment(3)
+	audio := segment.Data[len(timestampTag(0)):]
+	if segment.Duration != 400*time.Millisecond || len(audio) != 200 || audio[5] != 6 || audio[105] != 7 {
+		t.Errorf("Expected segment 3 to hold frames 6 and 7; got %+v", segment)
+	}
+}
+
+func TestSegmenterKeepsEverythingWithoutWindow(t *testing.T) {
+	segmenter := NewSegmenter(time.Second, 0, 0)
+	segmenter.Write(mp3Frames(1, 40))
+	segmenter.Flush()
+
+	if window := segmenter.Window(); len(window) != 8 || window[0].Sequence != 0 {
+		t.Errorf("Expected every segment to be kept; got %d", len(window))
+	}
+}
+
+func TestTimestampTag(t *testing.T) {
+	tag := timestampTag(10 * time.Second)
+
+	if string(tag[:3]) != "ID3" || int(tag[9]) != len(tag)-10 {
+		t.Fatalf("Expected an ID3 header with the size of the tag; got %v", tag[:10])
+	}
+	if string(tag[10:14]) != "PRIV" || !bytes.Contains(tag, []byte(TIMESTAMPOWNER+"\x00")) {
+		t.Errorf("Expected a PRIV frame owned by %s", TIMESTAMPOWNER)
+	}
+
+	ticks := binary.BigEndian.Uint64(tag[len(tag)-8:])
+	if ticks != 900000 {
+		t.Errorf("Expected 10 seconds in 90kHz ticks; got %d", ticks)
+	}
+}
+
+func TestWritePlaylist(t *testing.T) {
+	segments := []Segment{
+		{Sequence: 7, Duration: 5900 * time.Millisecond},
+		{Sequence: 8, Duration: 6100 * time.Millisecond},
+	}
+	uri := func(sequence uint64) string { return fmt.Sprintf("%d.mp3", sequence) }
+
+	var live strings.Builder
+	WritePlaylist(&live, segments, 6*time.Second, true, uri)
+
+	expected := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:7\n#EXT-X-MEDIA-SEQUENCE:7\n" +
+		"#EXTINF:5.900,\n7.mp3\n#EXTINF:6.100,\n8.mp3\n"
+	if live.String() != expected {
+		t.Errorf("Expected live playlist\n%s\ngot\n%s", expected, live.String())
+	}
+
+	var vod strings.Builder
+	WritePlaylist(&vod, segments, 6*time.Second, false, uri)
+
+	if !strings.Contains(vod.String(), "#EXT-X-PLAYLIST-TYPE:VOD\n") || !strings.HasSuffix(vod.String(), "#EXT-X-ENDLIST\n") {
+		t.Errorf("Expected a closed VOD playlist; got\n%s", vod.String())
+	}
+}
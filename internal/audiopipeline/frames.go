@@ -0,0 +1,71 @@
+package audiopipeline
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tcolgate/mp3"
+)
+
+// Frame is a single MP3 frame along with the time it takes to play it.
+type Frame struct {
+	Data     []byte
+	Duration time.Duration
+	// Bitrate of the frame in bits per second, which differs per frame in VBR files.
+	Bitrate int
+}
+
+// Playable reports whether songs of the file type can be split into frames, and
+// so be played live on a station or cut into HLS segments.
+func Playable(fileType string) bool {
+	return strings.EqualFold(fileType, "mp3")
+}
+
+// ParseFrames splits MP3 content into whole frames. Anything in between frames,
+// such as ID3 tags, is skipped.
+func ParseFrames(r io.Reader) ([]Frame, error) {
+	var frames []Frame
+
+	decoder := mp3.NewDecoder(bufio.NewReader(r))
+	var frame mp3.Frame
+	skipped := 0
+
+	for {
+		err := decoder.Decode(&frame, &skipped)
+		if err != nil {
+			// a truncated last frame is dropped rather than failing the whole file
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return frames, err
+		}
+
+		data, err := io.ReadAll(frame.Reader())
+		if err != nil {
+			return frames, err
+		}
+
+		frames = append(frames, Frame{
+			Data:     data,
+			Duration: frame.Duration(),
+			Bitrate:  int(frame.Header().BitRate()),
+		})
+	}
+
+	if len(frames) == 0 {
+		return nil, errors.New("no mp3 frames found")
+	}
+	return frames, nil
+}
+
+// Duration returns the total play time of the frames.
+func Duration(frames []Frame) time.Duration {
+	var total time.Duration
+	for _, frame := range frames {
+		total += frame.Duration
+	}
+	return total
+}
@@ -0,0 +1,174 @@
+package audiopipeline
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TIMESTAMPOWNER names the ID3 PRIV frame carrying the start time of a packed audio segment.
+const TIMESTAMPOWNER = "com.apple.streaming.transportStreamTimestamp"
+
+// Segment is a piece of an HLS stream made of whole frames, prefixed with an
+// ID3 tag carrying its start time as HLS requires for packed audio.
+type Segment struct {
+	Sequence uint64
+	Start    time.Duration
+	Duration time.Duration
+	Data     []byte
+}
+
+// Segmenter cuts frames into HLS segments of at most the target duration. It
+// keeps the last window segments for the live playlist, plus retention segments
+// that dropped out of it for clients that are still catching up. A window of 0
+// keeps every segment, as a VOD playlist needs.
+type Segmenter struct {
+	target    time.Duration
+	window    int
+	retention int
+
+	segments []Segment
+	next     uint64
+	elapsed  time.Duration
+
+	pending         []byte
+	pendingDuration time.Duration
+	mu              sync.Mutex
+}
+
+func NewSegmenter(target time.Duration, window int, retention int) *Segmenter {
+	return &Segmenter{target: target, window: window, retention: retention}
+}
+
+// Write adds frames, cutting a segment whenever the next frame would take it past the target duration.
+func (s *Segmenter) Write(frames []Frame) {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+
+	for _, frame := range frames {
+		if len(s.pending) > 0 && s.pendingDuration+frame.Duration > s.target {
+			s.cut()
+		}
+		s.pending = append(s.pending, frame.Data...)
+		s.pendingDuration += frame.Duration
+	}
+}
+
+// Flush cuts the frames written so far into a segment, even if it is shorter than the target duration.
+func (s *Segmenter) Flush() {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+
+	if len(s.pending) > 0 {
+		s.cut()
+	}
+}
+
+// Segment returns the segment with the sequence number, as long as it is retained.
+func (s *Segmenter) Segment(sequence uint64) (Segment, bool) {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+
+	if len(s.segments) == 0 || sequence < s.segments[0].Sequence || sequence >= s.next {
+		return Segment{}, false
+	}
+	return s.segments[sequence-s.segments[0].Sequence], true
+}
+
+// Window returns the segments of the live playlist, oldest first.
+func (s *Segmenter) Window() []Segment {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+
+	segments := s.segments
+	if s.window > 0 && len(segments) > s.window {
+		segments = segments[len(segments)-s.window:]
+	}
+	return append([]Segment{}, segments...)
+}
+
+// Target returns the longest a segment may be.
+func (s *Segmenter) Target() time.Duration {
+	return s.target
+}
+
+func (s *Segmenter) cut() {
+	data := append(timestampTag(s.elapsed), s.pending...)
+	s.segments = append(s.segments, Segment{
+		Sequence: s.next,
+		Start:    s.elapsed,
+		Duration: s.pendingDuration,
+		Data:     data,
+	})
+	s.next++
+	s.elapsed += s.pendingDuration
+
+	s.pending = nil
+	s.pendingDuration = 0
+
+	if s.window > 0 && len(s.segments) > s.window+s.retention {
+		s.segments = append([]Segment{}, s.segments[len(s.segments)-s.window-s.retention:]...)
+	}
+}
+
+// timestampTag returns an ID3v2.4 tag with a PRIV frame holding the start time
+// as a 33 bit MPEG-2 timestamp of 90kHz ticks.
+func timestampTag(start time.Duration) []byte {
+	ticks := (uint64(start/time.Microsecond) * 90000 / 1000000) & (1<<33 - 1)
+
+	payload := append([]byte(TIMESTAMPOWNER), 0)
+	payload = binary.BigEndian.AppendUint64(payload, ticks)
+
+	frame := append([]byte("PRIV"), syncsafe(len(payload))...)
+	frame = append(frame, 0, 0)
+	frame = append(frame, payload...)
+
+	tag := append([]byte("ID3"), 4, 0, 0)
+	tag = append(tag, syncsafe(len(frame))...)
+	return append(tag, frame...)
+}
+
+// syncsafe encodes a size in 4 bytes of 7 bits each, as ID3v2 does.
+func syncsafe(size int) []byte {
+	return []byte{byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+}
+
+// WritePlaylist writes an HLS media playlist of the segments. A live playlist
+// is left open for the segments to come, any other ends with the last segment.
+// uri names the segment with the sequence number.
+func WritePlaylist(w io.Writer, segments []Segment, target time.Duration, live bool, uri func(sequence uint64) string) error {
+	// every segment must fit the target duration once rounded
+	longest := target
+	for _, segment := range segments {
+		longest = max(longest, segment.Duration)
+	}
+
+	var sequence uint64
+	if len(segments) > 0 {
+		sequence = segments[0].Sequence
+	}
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	playlist.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(longest.Seconds())))
+	fmt.Fprintf(&playlist, "#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	if !live {
+		playlist.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+
+	for _, segment := range segments {
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%s\n", segment.Duration.Seconds(), uri(segment.Sequence))
+	}
+
+	if !live {
+		playlist.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	_, err := io.WriteString(w, playlist.String())
+	return err
+}
@@ -0,0 +1,132 @@
+package audiopipeline
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// ICYMETAINT is the amount of audio bytes between two metadata blocks.
+	ICYMETAINT = 16000
+
+	// the length of a metadata block is sent as a single byte, in units of 16 bytes
+	icyMaxMetadata = 255 * 16
+)
+
+// Metadata describes a station and what it is playing, as shown to ICY (Shoutcast) clients.
+type Metadata struct {
+	Name  string
+	Genre string
+	// Bitrate in kbit/s.
+	Bitrate int
+	// Title is the current track, formatted as "Artist - Title".
+	Title string
+}
+
+// StreamTitle formats a track the way radio clients expect it.
+func StreamTitle(artist string, title string) string {
+	if artist == "" {
+		return title
+	}
+	return artist + " - " + title
+}
+
+// AverageBitrate returns the average bitrate of the frames in kbit/s.
+func AverageBitrate(frames []Frame) int {
+	duration := Duration(frames)
+	if duration <= 0 {
+		return 0
+	}
+
+	size := 0
+	for _, frame := range frames {
+		size += len(frame.Data)
+	}
+
+	return int(float64(size*8) / duration.Seconds() / 1000)
+}
+
+// writeICYHeaders describes the station in the response headers. The metadata
+// interval is only announced to clients that asked for inline metadata.
+func writeICYHeaders(header http.Header, metadata Metadata, metaint int) {
+	if metadata.Name != "" {
+		header.Set("icy-name", metadata.Name)
+	}
+	if metadata.Genre != "" {
+		header.Set("icy-genre", metadata.Genre)
+	}
+	if metadata.Bitrate > 0 {
+		header.Set("icy-br", strconv.Itoa(metadata.Bitrate))
+	}
+	if metaint > 0 {
+		header.Set("icy-metaint", strconv.Itoa(metaint))
+	}
+}
+
+// icyWriter interleaves the audio with a metadata block every metaint bytes.
+// The title is only sent again after it changed, otherwise an empty block is sent.
+type icyWriter struct {
+	w         io.Writer
+	metaint   int
+	remaining int
+
+	title     func() string
+	lastTitle string
+}
+
+func newICYWriter(w io.Writer, metaint int, title func() string) *icyWriter {
+	return &icyWriter{w: w, metaint: metaint, remaining: metaint, title: title}
+}
+
+func (iw *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		n := min(len(p), iw.remaining)
+		n, err := iw.w.Write(p[:n])
+		written += n
+		iw.remaining -= n
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+
+		if iw.remaining == 0 {
+			_, err = iw.w.Write(iw.metadataBlock())
+			if err != nil {
+				return written, err
+			}
+			iw.remaining = iw.metaint
+		}
+	}
+
+	return written, nil
+}
+
+func (iw *icyWriter) metadataBlock() []byte {
+	title := iw.title()
+	if title == iw.lastTitle {
+		return []byte{0}
+	}
+	iw.lastTitle = title
+
+	// there is no way to escape quotes, so they are replaced to not end the title early
+	metadata := "StreamTitle='" + strings.ReplaceAll(title, "'", "’") + "';"
+	if len(metadata) > icyMaxMetadata {
+		// cut at the start of a character, not halfway through one
+		cut := icyMaxMetadata - 2
+		for cut > 0 && !utf8.RuneStart(metadata[cut]) {
+			cut--
+		}
+		metadata = metadata[:cut] + "';"
+	}
+
+	blocks := (len(metadata) + 15) / 16
+	block := make([]byte, 1+blocks*16)
+	block[0] = byte(blocks)
+	copy(block[1:], metadata)
+	return block
+}
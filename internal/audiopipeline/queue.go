@@ -0,0 +1,228 @@
+package audiopipeline
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+)
+
+// UPCOMING is the amount of tracks a queue plans ahead, so listeners can see what's next.
+const UPCOMING = 5
+
+// Track is a song a station can play.
+type Track struct {
+	ID       uint   `json:"id"`
+	Title    string `json:"title"`
+	Artist   string `json:"artist"`
+	FileType string `json:"file_type"`
+}
+
+// Source picks the tracks a station plays.
+type Source interface {
+	// Next returns the next track to play, or false if there is nothing to play.
+	Next() (Track, bool)
+}
+
+// Library returns the tracks a library source picks from. It is called again
+// every time the source runs out, so songs added in the meantime get played too.
+type Library func() ([]Track, error)
+
+// PlaylistSource plays a fixed list of tracks on repeat.
+type PlaylistSource struct {
+	tracks []Track
+	next   int
+}
+
+func NewPlaylistSource(tracks []Track) *PlaylistSource {
+	return &PlaylistSource{tracks: tracks}
+}
+
+func (ps *PlaylistSource) Next() (Track, bool) {
+	if len(ps.tracks) == 0 {
+		return Track{}, false
+	}
+
+	track := ps.tracks[ps.next]
+	ps.next = (ps.next + 1) % len(ps.tracks)
+	return track, true
+}
+
+// LibrarySource plays every track in the library before starting over,
+// either in order or shuffled anew every round.
+type LibrarySource struct {
+	library Library
+	shuffle bool
+
+	round []Track
+	last  Track
+}
+
+func NewLibrarySource(library Library, shuffle bool) *LibrarySource {
+	return &LibrarySource{library: library, shuffle: shuffle}
+}
+
+func (ls *LibrarySource) Next() (Track, bool) {
+	if len(ls.round) == 0 {
+		tracks, err := ls.library()
+		if err != nil || len(tracks) == 0 {
+			return Track{}, false
+		}
+
+		ls.round = append([]Track{}, tracks...)
+		if ls.shuffle {
+			rand.Shuffle(len(ls.round), func(i, j int) { ls.round[i], ls.round[j] = ls.round[j], ls.round[i] })
+
+			// don't play the same track twice in a row when a new round starts
+			if len(ls.round) > 1 && ls.round[0].ID == ls.last.ID {
+				ls.round[0], ls.round[1] = ls.round[1], ls.round[0]
+			}
+		}
+	}
+
+	ls.last = ls.round[0]
+	ls.round = ls.round[1:]
+	return ls.last, true
+}
+
+// ErrEntryNotFound is returned for queue entries that already played or were removed.
+var ErrEntryNotFound = errors.New("queue entry not found")
+
+// Entry is a track planned in a queue. Its EntryID tells apart entries of the same track.
+type Entry struct {
+	Track
+	EntryID uint64 `json:"entry_id"`
+	// Requested entries were enqueued by hand, rather than picked by the source.
+	Requested bool `json:"requested"`
+}
+
+// Queue is the running order of a station: the track that is playing and the
+// ones planned after it, topped up from its source. Requested tracks play
+// before the ones the source picked.
+type Queue struct {
+	source   Source
+	current  *Track
+	upcoming []Entry
+	lastID   uint64
+
+	// changed nudges an idle station when a track is enqueued
+	changed chan struct{}
+	mu      sync.Mutex
+}
+
+func NewQueue(source Source) *Queue {
+	return &Queue{source: source, changed: make(chan struct{}, 1)}
+}
+
+// Advance moves on to the next track and returns it, or false if there is nothing to play.
+func (q *Queue) Advance() (Track, bool) {
+	defer q.mu.Unlock()
+	q.mu.Lock()
+
+	q.fill()
+	if len(q.upcoming) == 0 {
+		q.current = nil
+		return Track{}, false
+	}
+
+	track := q.upcoming[0].Track
+	q.upcoming = q.upcoming[1:]
+	q.current = &track
+	q.fill()
+
+	return track, true
+}
+
+// Current returns the track that is playing.
+func (q *Queue) Current() (Track, bool) {
+	defer q.mu.Unlock()
+	q.mu.Lock()
+
+	if q.current == nil {
+		return Track{}, false
+	}
+	return *q.current, true
+}
+
+// Upcoming returns the entries planned after the current track.
+func (q *Queue) Upcoming() []Entry {
+	defer q.mu.Unlock()
+	q.mu.Lock()
+
+	q.fill()
+	return append([]Entry{}, q.upcoming...)
+}
+
+// Enqueue plans the track after the other requested ones.
+func (q *Queue) Enqueue(track Track) Entry {
+	defer q.mu.Unlock()
+	q.mu.Lock()
+
+	position := 0
+	for position < len(q.upcoming) && q.upcoming[position].Requested {
+		position++
+	}
+
+	entry := q.newEntry(track, true)
+	q.upcoming = slices.Insert(q.upcoming, position, entry)
+
+	select {
+	case q.changed <- struct{}{}:
+	default:
+	}
+
+	return entry
+}
+
+// Remove takes the entry out of the queue.
+func (q *Queue) Remove(entryID uint64) error {
+	defer q.mu.Unlock()
+	q.mu.Lock()
+
+	index := q.find(entryID)
+	if index < 0 {
+		return ErrEntryNotFound
+	}
+
+	q.upcoming = slices.Delete(q.upcoming, index, index+1)
+	q.fill()
+	return nil
+}
+
+// Move puts the entry at the position in the queue, counting from 0 for the
+// next track to play. Positions past the end move the entry to the end.
+func (q *Queue) Move(entryID uint64, position int) error {
+	defer q.mu.Unlock()
+	q.mu.Lock()
+
+	index := q.find(entryID)
+	if index < 0 {
+		return ErrEntryNotFound
+	}
+
+	entry := q.upcoming[index]
+	q.upcoming = slices.Delete(q.upcoming, index, index+1)
+	position = min(max(position, 0), len(q.upcoming))
+	q.upcoming = slices.Insert(q.upcoming, position, entry)
+	return nil
+}
+
+func (q *Queue) find(entryID uint64) int {
+	return slices.IndexFunc(q.upcoming, func(entry Entry) bool { return entry.EntryID == entryID })
+}
+
+func (q *Queue) newEntry(track Track, requested bool) Entry {
+	q.lastID++
+	return Entry{Track: track, EntryID: q.lastID, Requested: requested}
+}
+
+// fill tops up the upcoming tracks from the source.
+func (q *Queue) fill() {
+	for len(q.upcoming) < UPCOMING {
+		track, ok := q.source.Next()
+		if !ok {
+			return
+		}
+		q.upcoming = append(q.upcoming, q.newEntry(track, false))
+	}
+}
@@ -0,0 +1,98 @@
+package audiopipeline
+
+import "sync"
+
+// Remote steers a running station: it skips tracks and pauses or resumes playback.
+type Remote struct {
+	paused  bool
+	silence bool
+	skip    bool
+
+	// wake nudges the stream whenever something changed
+	wake chan struct{}
+	mu   sync.Mutex
+}
+
+func NewRemote() *Remote {
+	return &Remote{wake: make(chan struct{}, 1)}
+}
+
+// Skip stops the current track, so the station moves on to the next one.
+func (r *Remote) Skip() {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	r.skip = true
+	r.nudge()
+}
+
+// Pause holds playback where it is. With silence the station keeps
+// broadcasting silent frames, so players stay connected and in sync,
+// otherwise listeners are held without any data until playback resumes.
+func (r *Remote) Pause(silence bool) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	r.paused = true
+	r.silence = silence
+	r.nudge()
+}
+
+// Resume continues playback where it was paused.
+func (r *Remote) Resume() {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	r.paused = false
+	r.nudge()
+}
+
+// Paused reports whether playback is paused and whether silence is sent meanwhile.
+func (r *Remote) Paused() (paused bool, silence bool) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	return r.paused, r.silence
+}
+
+// skipping reports whether the current track should be skipped.
+func (r *Remote) skipping() bool {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	return r.skip
+}
+
+// skipped reports whether the current track should be skipped, and clears the request.
+func (r *Remote) skipped() bool {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	skip := r.skip
+	r.skip = false
+	return skip
+}
+
+func (r *Remote) nudge() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// silentFrame returns a frame as long as the given MPEG audio layer III frame
+// that decodes to silence, or false if the frame isn't one. With all side
+// information zeroed no audio data is decoded, and the CRC is dropped so the
+// zeroes don't need a checksum.
+func silentFrame(frame Frame) (Frame, bool) {
+	data := frame.Data
+	if len(data) < 4 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 || (data[1]>>1)&0x3 != 0x1 {
+		return Frame{}, false
+	}
+
+	silence := make([]byte, len(data))
+	copy(silence, data[:4])
+	silence[1] |= 0x1
+
+	return Frame{Data: silence, Duration: frame.Duration, Bitrate: frame.Bitrate}, true
+}
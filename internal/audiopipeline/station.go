@@ -0,0 +1,228 @@
+package audiopipeline
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrStationExists   = errors.New("station already exists")
+	ErrStationNotFound = errors.New("station not found")
+)
+
+// StreamFunc feeds a station's connection pool until stop is closed.
+type StreamFunc func(connectionPool *ConnectionPool, stop <-chan struct{})
+
+// Station is a single live broadcast shared by every listener tuned in to it.
+type Station struct {
+	Name string
+	Pool *ConnectionPool
+
+	// Queue is the running order of a created station, nil for the stations of single songs.
+	Queue *Queue
+	// Remote steers the playback of a created station.
+	Remote *Remote
+
+	listeners int
+	stop      chan struct{}
+}
+
+// StationInfo describes a created station and what it is playing.
+type StationInfo struct {
+	Name       string  `json:"name"`
+	Listeners  int     `json:"listeners"`
+	Paused     bool    `json:"paused"`
+	NowPlaying *Track  `json:"now_playing"`
+	Upcoming   []Entry `json:"upcoming"`
+}
+
+// StationRegistry keeps one live station per name, so listeners tuning in to
+// the same song or station share a single ConnectionPool and Stream.
+type StationRegistry struct {
+	stations map[string]*Station
+	mu       sync.Mutex
+}
+
+func NewStationRegistry() *StationRegistry {
+	return &StationRegistry{stations: make(map[string]*Station)}
+}
+
+// Join attaches a listener to the named station. If the station isn't on air
+// yet, it is created and start is run in its own goroutine to feed it. Should
+// start return before the station is stopped, like when its song can't be
+// read, the station goes off air and its listeners are disconnected.
+func (sr *StationRegistry) Join(name string, start StreamFunc) *Station {
+	defer sr.mu.Unlock()
+	sr.mu.Lock()
+
+	station, ok := sr.stations[name]
+	if !ok {
+		station = &Station{
+			Name: name,
+			Pool: NewConnectionPool(),
+			stop: make(chan struct{}),
+		}
+		sr.stations[name] = station
+
+		log.Printf("Station '%s' is going on air\n", name)
+		go sr.run(station, start)
+	}
+
+	station.listeners++
+	return station
+}
+
+// Create puts a station playing from queue on air, steered by remote and cut
+// into HLS segments by segmenter if it isn't nil. Unlike the stations of Join
+// it keeps broadcasting without listeners until it is deleted.
+func (sr *StationRegistry) Create(name string, queue *Queue, remote *Remote, segmenter *Segmenter, start StreamFunc) (*Station, error) {
+	defer sr.mu.Unlock()
+	sr.mu.Lock()
+
+	if _, ok := sr.stations[name]; ok {
+		return nil, ErrStationExists
+	}
+
+	station := &Station{
+		Name:   name,
+		Pool:   NewConnectionPool(),
+		Queue:  queue,
+		Remote: remote,
+		stop:   make(chan struct{}),
+	}
+	station.Pool.SetSegmenter(segmenter)
+	sr.stations[name] = station
+
+	log.Printf("Station '%s' is going on air\n", name)
+	go sr.run(station, start)
+
+	return station, nil
+}
+
+// Tune attaches a listener to a created station.
+func (sr *StationRegistry) Tune(name string) (*Station, error) {
+	defer sr.mu.Unlock()
+	sr.mu.Lock()
+
+	station, ok := sr.stations[name]
+	if !ok || station.Queue == nil {
+		return nil, ErrStationNotFound
+	}
+
+	station.listeners++
+	return station, nil
+}
+
+// Delete takes a created station off air and disconnects its listeners.
+func (sr *StationRegistry) Delete(name string) error {
+	defer sr.mu.Unlock()
+	sr.mu.Lock()
+
+	station, ok := sr.stations[name]
+	if !ok || station.Queue == nil {
+		return ErrStationNotFound
+	}
+
+	delete(sr.stations, name)
+	close(station.stop)
+	station.Pool.Close()
+	log.Printf("Station '%s' went off air\n", name)
+
+	return nil
+}
+
+// Find returns the named created station.
+func (sr *StationRegistry) Find(name string) (*Station, error) {
+	defer sr.mu.Unlock()
+	sr.mu.Lock()
+
+	station, ok := sr.stations[name]
+	if !ok || station.Queue == nil {
+		return nil, ErrStationNotFound
+	}
+	return station, nil
+}
+
+// Info describes the named created station.
+func (sr *StationRegistry) Info(name string) (StationInfo, error) {
+	defer sr.mu.Unlock()
+	sr.mu.Lock()
+
+	station, ok := sr.stations[name]
+	if !ok || station.Queue == nil {
+		return StationInfo{}, ErrStationNotFound
+	}
+	return station.info(), nil
+}
+
+// List describes every created station, ordered by name.
+func (sr *StationRegistry) List() []StationInfo {
+	defer sr.mu.Unlock()
+	sr.mu.Lock()
+
+	infos := []StationInfo{}
+	for _, station := range sr.stations {
+		if station.Queue != nil {
+			infos = append(infos, station.info())
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return infos
+}
+
+// Leave detaches a listener from the station. When the last listener leaves
+// the station of a song, its stream is stopped and the station is taken off air.
+func (sr *StationRegistry) Leave(station *Station) {
+	defer sr.mu.Unlock()
+	sr.mu.Lock()
+
+	station.listeners--
+	if station.listeners > 0 || station.Queue != nil {
+		return
+	}
+
+	if sr.stations[station.Name] == station {
+		delete(sr.stations, station.Name)
+	}
+	close(station.stop)
+	log.Printf("Station '%s' went off air\n", station.Name)
+}
+
+// run feeds the station with start, taking the station off air once start returns.
+func (sr *StationRegistry) run(station *Station, start StreamFunc) {
+	start(station.Pool, station.stop)
+
+	sr.mu.Lock()
+	if sr.stations[station.Name] == station {
+		delete(sr.stations, station.Name)
+		log.Printf("Station '%s' went off air, its stream ended\n", station.Name)
+	}
+	sr.mu.Unlock()
+	station.Pool.Close()
+}
+
+// Get returns the named station if it is currently on air.
+func (sr *StationRegistry) Get(name string) (*Station, bool) {
+	defer sr.mu.Unlock()
+	sr.mu.Lock()
+
+	station, ok := sr.stations[name]
+	return station, ok
+}
+
+func (s *Station) info() StationInfo {
+	paused, _ := s.Remote.Paused()
+	info := StationInfo{
+		Name:      s.Name,
+		Listeners: s.listeners,
+		Paused:    paused,
+		Upcoming:  s.Queue.Upcoming(),
+	}
+	if track, ok := s.Queue.Current(); ok {
+		info.NowPlaying = &track
+	}
+	return info
+}
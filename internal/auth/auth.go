@@ -0,0 +1,280 @@
+// Package auth manages the accounts of the users and tells who sent a request,
+// from the session token they got by logging in or from one of their API keys.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"infiniti.com/config"
+	"infiniti.com/internal/database"
+	model "infiniti.com/model"
+)
+
+// KEYPREFIX starts every API key, which tells them apart from session tokens.
+const KEYPREFIX = "inf_"
+
+// MINPASSWORDLEN is the shortest a password may be in bytes, bcrypt limits the longest to 72.
+const MINPASSWORDLEN = 8
+
+// tokenHeader is the header of every session token, which are JWTs signed with HMAC-SHA256.
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{2,31}$`)
+
+var (
+	// ErrInvalidCredentials is returned for logins with an unknown username or a wrong password.
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrInvalidToken is returned for session tokens and API keys that aren't valid, or no longer.
+	ErrInvalidToken = errors.New("invalid or expired credentials")
+	// ErrRegistrationClosed is returned for registrations once the first user exists, unless registration is open.
+	ErrRegistrationClosed = errors.New("registration is closed")
+	// ErrInvalidUsername is returned for usernames that can't be used.
+	ErrInvalidUsername = errors.New("username must be 3 to 32 letters, digits, dots, dashes or underscores")
+	// ErrInvalidPassword is returned for passwords that are too short or too long.
+	ErrInvalidPassword = errors.New("password must be 8 to 72 bytes long")
+)
+
+// claims are what a session token says about who it was given to.
+type claims struct {
+	Subject  string `json:"sub"`
+	Name     string `json:"name"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+// Authenticator registers and logs in users, and checks their credentials.
+type Authenticator struct {
+	users        database.UserRepository
+	secret       []byte
+	expiry       time.Duration
+	registration bool
+	// anonymous is the role of requests sent without credentials
+	anonymous string
+
+	// dummy is compared against for unknown usernames, so logging in takes as long whether the user exists or not
+	dummy []byte
+	// mu makes checking for the first user and creating it one step, and likewise for the last admin
+	mu sync.Mutex
+}
+
+func New(users database.UserRepository, cfg config.Auth) (*Authenticator, error) {
+	if cfg.Anonymous != NOROLE && !validRole(cfg.Anonymous) {
+		return nil, ErrUnknownRole
+	}
+
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		log.Println("No auth secret is set, logins end when the server restarts")
+		secret = make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dummy, err := bcrypt.GenerateFromPassword(secret[:MINPASSWORDLEN], bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Authenticator{
+		users:        users,
+		secret:       secret,
+		expiry:       time.Duration(cfg.TokenExpiry) * time.Hour,
+		registration: cfg.Registration,
+		anonymous:    cfg.Anonymous,
+		dummy:        dummy,
+	}
+	err = a.ensureAdmin()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Register creates a user with the username, lowercased, and the password.
+// Only the first user may register unless registration is open. The first
+// user is an admin, the others are listeners until an admin says otherwise.
+func (a *Authenticator) Register(username string, password string) (*model.User, error) {
+	user, err := newUser(username, password, LISTENER)
+	if err != nil {
+		return nil, err
+	}
+
+	defer a.mu.Unlock()
+	a.mu.Lock()
+
+	count, err := a.users.CountUsers()
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 && !a.registration {
+		return nil, ErrRegistrationClosed
+	}
+	if count == 0 {
+		user.Role = ADMIN
+	}
+
+	err = a.users.CreateUser(user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Login checks the password of the user and returns a session token for it, along with when it expires.
+func (a *Authenticator) Login(username string, password string) (string, time.Time, *model.User, error) {
+	user, err := a.users.FindUser(strings.ToLower(strings.TrimSpace(username)))
+	if errors.Is(err, database.ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(a.dummy, []byte(password))
+		return "", time.Time{}, nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	if err != nil {
+		return "", time.Time{}, nil, ErrInvalidCredentials
+	}
+
+	token, expires := a.Token(user, time.Now())
+	return token, expires, user, nil
+}
+
+// Token returns a session token for the user issued at now, along with when it expires.
+func (a *Authenticator) Token(user *model.User, now time.Time) (string, time.Time) {
+	expires := now.Add(a.expiry)
+	payload, _ := json.Marshal(claims{
+		Subject:  strconv.FormatUint(uint64(user.ID), 10),
+		Name:     user.Username,
+		IssuedAt: now.Unix(),
+		Expires:  expires.Unix(),
+	})
+
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + a.sign(unsigned), expires
+}
+
+// Authenticate returns the user a session token was given to, or an API key belongs to.
+func (a *Authenticator) Authenticate(credential string) (*model.User, error) {
+	var id uint
+	if strings.HasPrefix(credential, KEYPREFIX) {
+		key, err := a.users.FindAPIKey(hashKey(credential))
+		if errors.Is(err, database.ErrKeyNotFound) {
+			return nil, ErrInvalidToken
+		}
+		if err != nil {
+			return nil, err
+		}
+		id = key.UserID
+	} else {
+		c, err := a.verify(credential)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := strconv.ParseUint(c.Subject, 10, 0)
+		if err != nil {
+			return nil, ErrInvalidToken
+		}
+		id = uint(parsed)
+	}
+
+	// users that are gone lose access, whatever their credentials say
+	user, err := a.users.GetUser(id)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, ErrInvalidToken
+	}
+	return user, err
+}
+
+// CreateKey creates an API key named name for the user. The key is returned
+// along with it, as it can't be looked up again.
+func (a *Authenticator) CreateKey(user *model.User, name string) (string, *model.APIKey, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret := KEYPREFIX + base64.RawURLEncoding.EncodeToString(random)
+	key := &model.APIKey{UserID: user.ID, Name: strings.TrimSpace(name), Prefix: secret[:len(KEYPREFIX)+8], Hash: hashKey(secret)}
+	err = a.users.CreateAPIKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, key, nil
+}
+
+// Keys returns the API keys of the user.
+func (a *Authenticator) Keys(user *model.User) ([]model.APIKey, error) {
+	return a.users.APIKeys(user.ID)
+}
+
+// DeleteKey deletes the API key of the user with the id.
+func (a *Authenticator) DeleteKey(user *model.User, id uint) error {
+	return a.users.DeleteAPIKey(user.ID, id)
+}
+
+// verify checks the signature and expiry of the session token and returns its claims.
+func (a *Authenticator) verify(token string) (claims, error) {
+	var c claims
+	header, rest, _ := strings.Cut(token, ".")
+	payload, signature, _ := strings.Cut(rest, ".")
+	// only tokens signed the way the server signs them are accepted, whatever algorithm they name
+	if header != tokenHeader || !hmac.Equal([]byte(signature), []byte(a.sign(header+"."+payload))) {
+		return c, ErrInvalidToken
+	}
+
+	content, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(content, &c) != nil {
+		return c, ErrInvalidToken
+	}
+	if time.Now().Unix() >= c.Expires {
+		return c, ErrInvalidToken
+	}
+	return c, nil
+}
+
+func (a *Authenticator) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newUser returns a user with the username, lowercased, the hash of the password and the role.
+func newUser(username string, password string, role string) (*model.User, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrInvalidUsername
+	}
+	if len(password) < MINPASSWORDLEN || len(password) > 72 {
+		return nil, ErrInvalidPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return &model.User{Username: username, PasswordHash: string(hash), Role: role}, nil
+}
+
+// hashKey returns the hash an API key is stored under. Keys are random enough
+// that a fast hash will do, unlike passwords.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
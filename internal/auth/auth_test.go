@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"infiniti.com/config"
+	"infiniti.com/internal/database"
+	model "infiniti.com/model"
+)
+
+func createAuthenticator(t *testing.T, registration bool) *Authenticator {
+	a, err := New(database.NewMemoryUserRepository(), config.Auth{Secret: strings.Repeat("s", 32), TokenExpiry: 24, Registration: registration, Anonymous: LISTENER})
+	if err != nil {
+		t.Fatalf("Failed to create authenticator: %v", err)
+	}
+	return a
+}
+
+func TestRegister(t *testing.T) {
+	a := createAuthenticator(t, false)
+
+	tests := map[string]error{
+		"ab":                    ErrInvalidUsername,
+		"has space":             ErrInvalidUsername,
+		"-dash":                 ErrInvalidUsername,
+		strings.Repeat("a", 33): ErrInvalidUsername,
+	}
+	for username, expected := range tests {
+		if _, err := a.Register(username, "long enough"); !errors.Is(err, expected) {
+			t.Errorf("Expected %v for %q; got %v", expected, username, err)
+		}
+	}
+	for _, password := range []string{"short", strings.Repeat("p", 73)} {
+		if _, err := a.Register("alice", password); !errors.Is(err, ErrInvalidPassword) {
+			t.Errorf("Expected ErrInvalidPassword for a password of %d bytes; got %v", len(password), err)
+		}
+	}
+
+	user, err := a.Register(" Alice ", "correct horse")
+	if err != nil || user.Username != "alice" || user.PasswordHash == "correct horse" || user.Role != ADMIN {
+		t.Fatalf("Expected the first user to register as an admin with a hashed password; got %+v, %v", user, err)
+	}
+	if _, err := a.Register("bob", "correct horse"); !errors.Is(err, ErrRegistrationClosed) {
+		t.Errorf("Expected ErrRegistrationClosed; got %v", err)
+	}
+
+	a.registration = true
+	if _, err := a.Register("ALICE", "correct horse"); !errors.Is(err, database.ErrUserExists) {
+		t.Errorf("Expected ErrUserExists; got %v", err)
+	}
+	if user, err := a.Register("bob", "correct horse"); err != nil || user.Role != LISTENER {
+		t.Errorf("Expected registration to be open to listeners; got %+v, %v", user, err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	a := createAuthenticator(t, false)
+	a.Register("alice", "correct horse")
+
+	for _, credentials := range [][2]string{{"alice", "wrong horse"}, {"bob", "correct horse"}} {
+		if _, _, _, err := a.Login(credentials[0], credentials[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Expected ErrInvalidCredentials for %v; got %v", credentials, err)
+		}
+	}
+
+	token, expires, user, err := a.Login("Alice", "correct horse")
+	if err != nil || user.Username != "alice" {
+		t.Fatalf("Expected to log in; got %+v, %v", user, err)
+	}
+	if expires.Before(time.Now().Add(23 * time.Hour)) {
+		t.Errorf("Expected the token to last a day; got %v", expires)
+	}
+	if found, err := a.Authenticate(token); err != nil || found.ID != user.ID {
+		t.Errorf("Expected the token to authenticate alice; got %+v, %v", found, err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := createAuthenticator(t, false)
+	user := &model.User{Username: "alice"}
+	a.users.CreateUser(user)
+
+	valid, _ := a.Token(user, time.Now())
+	expired, _ := a.Token(user, time.Now().Add(-25*time.Hour))
+	other := createAuthenticator(t, false)
+	other.secret = []byte(strings.Repeat("o", 32))
+	foreign, _ := other.Token(user, time.Now())
+	header, rest, _ := strings.Cut(valid, ".")
+	_, signature, _ := strings.Cut(rest, ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + rest
+
+	tests := map[string]string{
+		"expired":            expired,
+		"signed by another":  foreign,
+		"with another alg":   none,
+		"without a payload":  header + ".." + signature,
+		"empty":              "",
+		"an unknown api key": KEYPREFIX + "nope",
+	}
+	for name, token := range tests {
+		if _, err := a.Authenticate(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected ErrInvalidToken for a token %s; got %v", name, err)
+		}
+	}
+
+	secret, key, err := a.CreateKey(user, " script ")
+	if err != nil || key.Name != "script" || !strings.HasPrefix(secret, key.Prefix) || key.Hash == secret {
+		t.Fatalf("Expected a named key stored by its hash; got %+v, %v", key, err)
+	}
+	if found, err := a.Authenticate(secret); err != nil || found.ID != user.ID {
+		t.Errorf("Expected the key to authenticate alice; got %+v, %v", found, err)
+	}
+	err = a.DeleteKey(user, key.ID)
+	if err != nil {
+		t.Fatalf("Failed to delete the key: %v", err)
+	}
+	if _, err := a.Authenticate(secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected the deleted key to be refused; got %v", err)
+	}
+
+	// tokens of users that are gone are refused, even though they are signed
+	gone, _ := a.Token(&model.User{ID: 100, Username: "gone"}, time.Now())
+	if _, err := a.Authenticate(gone); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected the token of a missing user to be refused; got %v", err)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	tests := []struct {
+		role       string
+		permission string
+		expected   bool
+	}{
+		{ADMIN, USERSADMIN, true},
+		{ADMIN, SONGSDELETE, true},
+		{UPLOADER, SONGSWRITE, true},
+		{UPLOADER, STATIONSCONTROL, true},
+		{UPLOADER, USERSADMIN, false},
+		{LISTENER, SONGSREAD, true},
+		{LISTENER, SONGSWRITE, false},
+		{NOROLE, SONGSREAD, false},
+		{"", SONGSREAD, false},
+	}
+	for _, test := range tests {
+		if Can(test.role, test.permission) != test.expected {
+			t.Errorf("Expected %q to have %s: %v", test.role, test.permission, test.expected)
+		}
+	}
+
+	owner := &model.User{ID: 1}
+	other := &model.User{ID: 2}
+	owned := model.Song{OwnerID: 1}
+	found := model.Song{}
+	deletions := []struct {
+		user     *model.User
+		role     string
+		song     model.Song
+		expected bool
+	}{
+		{owner, UPLOADER, owned, true},
+		{other, UPLOADER, owned, false},
+		{owner, UPLOADER, found, false},
+		{owner, LISTENER, owned, false},
+		{other, ADMIN, owned, true},
+		{nil, ADMIN, found, true},
+		{nil, UPLOADER, found, false},
+	}
+	for _, test := range deletions {
+		if CanDelete(test.user, test.role, test.song) != test.expected {
+			t.Errorf("Expected %+v as %s deleting a song owned by %d: %v", test.user, test.role, test.song.OwnerID, test.expected)
+		}
+	}
+}
+
+func TestManageUsers(t *testing.T) {
+	a := createAuthenticator(t, false)
+	admin := &model.User{Username: "admin", Role: ADMIN}
+	a.users.CreateUser(admin)
+
+	if _, err := a.CreateUser("bob", "correct horse", "root"); !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("Expected ErrUnknownRole; got %v", err)
+	}
+	bob, err := a.CreateUser("Bob", "correct horse", UPLOADER)
+	if err != nil || bob.Username != "bob" || bob.Role != UPLOADER {
+		t.Fatalf("Expected an uploader, although registration is closed; got %+v, %v", bob, err)
+	}
+
+	if _, err := a.SetRole(admin.ID, LISTENER); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Expected ErrLastAdmin demoting the last admin; got %v", err)
+	}
+	if err := a.DeleteUser(admin.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Expected ErrLastAdmin deleting the last admin; got %v", err)
+	}
+	if _, err := a.SetRole(100, LISTENER); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound; got %v", err)
+	}
+
+	if user, err := a.SetRole(bob.ID, ADMIN); err != nil || user.Role != ADMIN {
+		t.Fatalf("Expected bob to be an admin; got %+v, %v", user, err)
+	}
+	// with another admin, the first one may go
+	if err := a.DeleteUser(admin.ID); err != nil {
+		t.Errorf("Failed to delete the admin: %v", err)
+	}
+	if users, _ := a.Users(); len(users) != 1 || users[0].ID != bob.ID {
+		t.Errorf("Expected only bob to be left; got %+v", users)
+	}
+}
+
+func TestEnsureAdmin(t *testing.T) {
+	users := database.NewMemoryUserRepository()
+	cfg := config.Auth{Secret: strings.Repeat("s", 32), TokenExpiry: 24, Anonymous: LISTENER}
+
+	// accounts from before there were roles are all listeners
+	first, second := &model.User{Username: "first"}, &model.User{Username: "second"}
+	users.CreateUser(first)
+	users.CreateUser(second)
+
+	_, err := New(users, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create authenticator: %v", err)
+	}
+	if user, _ := users.GetUser(first.ID); user.Role != ADMIN {
+		t.Errorf("Expected the first user to become the admin; got %q", user.Role)
+	}
+	if user, _ := users.GetUser(second.ID); user.Role != LISTENER {
+		t.Errorf("Expected the second user to stay a listener; got %q", user.Role)
+	}
+
+	cfg.Anonymous = "guest"
+	if _, err := New(users, cfg); !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("Expected ErrUnknownRole for the anonymous role; got %v", err)
+	}
+}
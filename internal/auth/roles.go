@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+
+	model "infiniti.com/model"
+)
+
+// The permissions the routes require.
+const (
+	SONGSREAD       = "songs:read"
+	SONGSWRITE      = "songs:write"
+	SONGSDELETE     = "songs:delete"
+	STATIONSCONTROL = "stations:control"
+	USERSADMIN      = "users:admin"
+)
+
+// The roles of the users.
+const (
+	ADMIN    = "admin"
+	UPLOADER = "uploader"
+	LISTENER = "listener"
+)
+
+// NOROLE is the anonymous role of servers that require logging in, which has no permissions.
+const NOROLE = "none"
+
+var (
+	// ErrUnknownRole is returned for roles other than admin, uploader and listener.
+	ErrUnknownRole = errors.New("role must be admin, uploader or listener")
+	// ErrLastAdmin is returned for changes that would leave no admin.
+	ErrLastAdmin = errors.New("the last admin can't be removed or demoted")
+)
+
+// Role is a set of permissions given to users.
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// roles are every role, from the most permissions to the least. Uploaders may
+// only delete the songs they uploaded themselves, admins may delete any song.
+var roles = []Role{
+	{ADMIN, []string{SONGSREAD, SONGSWRITE, SONGSDELETE, STATIONSCONTROL, USERSADMIN}},
+	{UPLOADER, []string{SONGSREAD, SONGSWRITE, SONGSDELETE, STATIONSCONTROL}},
+	{LISTENER, []string{SONGSREAD}},
+}
+
+// Roles returns every role with its permissions.
+func Roles() []Role {
+	return slices.Clone(roles)
+}
+
+// Can reports whether the role has the permission.
+func Can(role string, permission string) bool {
+	i := slices.IndexFunc(roles, func(r Role) bool { return r.Name == role })
+	return i >= 0 && slices.Contains(roles[i].Permissions, permission)
+}
+
+// CanDelete reports whether the user, nil for anonymous requests, with the
+// role may delete the song: admins may delete any song, others only the songs
+// they uploaded, given they may delete songs at all.
+func CanDelete(user *model.User, role string, song model.Song) bool {
+	if Can(role, USERSADMIN) {
+		return true
+	}
+	return Can(role, SONGSDELETE) && user != nil && song.OwnerID != 0 && song.OwnerID == user.ID
+}
+
+func validRole(role string) bool {
+	return slices.ContainsFunc(roles, func(r Role) bool { return r.Name == role })
+}
+
+// Anonymous returns the role of requests sent without credentials.
+func (a *Authenticator) Anonymous() string {
+	return a.anonymous
+}
+
+// Users returns every user.
+func (a *Authenticator) Users() ([]model.User, error) {
+	return a.users.ListUsers()
+}
+
+// User returns the user with the id.
+func (a *Authenticator) User(id uint) (*model.User, error) {
+	return a.users.GetUser(id)
+}
+
+// CreateUser creates a user with the role, whether or not registration is open.
+func (a *Authenticator) CreateUser(username string, password string, role string) (*model.User, error) {
+	if !validRole(role) {
+		return nil, ErrUnknownRole
+	}
+	user, err := newUser(username, password, role)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.users.CreateUser(user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetRole gives the user with the id the role, unless they are the last admin.
+func (a *Authenticator) SetRole(id uint, role string) (*model.User, error) {
+	if !validRole(role) {
+		return nil, ErrUnknownRole
+	}
+
+	defer a.mu.Unlock()
+	a.mu.Lock()
+
+	user, err := a.users.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == role {
+		return user, nil
+	}
+	err = a.keepAdmin(user)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Role = role
+	err = a.users.UpdateUser(user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DeleteUser deletes the user with the id and their API keys, unless they are
+// the last admin. The songs they uploaded stay, for admins to delete.
+func (a *Authenticator) DeleteUser(id uint) error {
+	defer a.mu.Unlock()
+	a.mu.Lock()
+
+	user, err := a.users.GetUser(id)
+	if err != nil {
+		return err
+	}
+	err = a.keepAdmin(user)
+	if err != nil {
+		return err
+	}
+	return a.users.DeleteUser(id)
+}
+
+// keepAdmin returns ErrLastAdmin if the user is the only admin.
+func (a *Authenticator) keepAdmin(user *model.User) error {
+	if user.Role != ADMIN {
+		return nil
+	}
+
+	users, err := a.users.ListUsers()
+	if err != nil {
+		return err
+	}
+	for _, other := range users {
+		if other.Role == ADMIN && other.ID != user.ID {
+			return nil
+		}
+	}
+	return ErrLastAdmin
+}
+
+// ensureAdmin makes the first user an admin if there are users but no admin,
+// as happens to the accounts created before there were roles.
+func (a *Authenticator) ensureAdmin() error {
+	users, err := a.users.ListUsers()
+	if err != nil || len(users) == 0 {
+		return err
+	}
+	if slices.ContainsFunc(users, func(u model.User) bool { return u.Role == ADMIN }) {
+		return nil
+	}
+
+	first := users[0]
+	first.Role = ADMIN
+	return a.users.UpdateUser(&first)
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+
+	"infiniti.com/internal/storage"
+)
+
+// COVERDIR is the directory of the storage backend holding cover images, each
+// stored once under the hex encoded SHA-256 of its content.
+const COVERDIR = ".covers"
+
+// coverFiles are the names of the images used as the cover of every song in
+// their directory, tried in order when a song has no embedded picture.
+var coverFiles = []string{"cover.jpg", "cover.jpeg", "cover.png", "folder.jpg", "folder.jpeg", "folder.png", "Cover.jpg", "Folder.jpg"}
+
+// IsCoverFile reports whether the file stored under name is used as the cover of the songs in its directory.
+func IsCoverFile(name string) bool {
+	return slices.Contains(coverFiles, path.Base(name))
+}
+
+// CoverPath returns the name under which the cover with the hash is stored.
+func CoverPath(hash string) string {
+	return path.Join(COVERDIR, hash)
+}
+
+// storeCover stores the cover image, unless an identical one is already stored, and returns its hash.
+func storeCover(store storage.Backend, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	_, err := store.Stat(CoverPath(hash))
+	if err == nil {
+		return hash, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	return hash, store.Put(CoverPath(hash), bytes.NewReader(data))
+}
+
+// findCover returns the embedded picture if there is one, or else the content
+// of the first cover file next to the song stored under name. It returns nil
+// if the song has no cover.
+func findCover(store storage.Backend, name string, embedded []byte) ([]byte, error) {
+	if len(embedded) > 0 {
+		return embedded, nil
+	}
+
+	for _, file := range coverFiles {
+		cover, err := store.Open(path.Join(path.Dir(name), file))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer cover.Close()
+
+		return io.ReadAll(cover)
+	}
+
+	return nil, nil
+}
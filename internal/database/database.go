@@ -1,14 +1,23 @@
 package database
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/dhowden/tag"
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"infiniti.com/config"
+	"infiniti.com/internal/metadata"
+	"infiniti.com/internal/storage"
 	model "infiniti.com/model"
 )
 
@@ -18,134 +27,138 @@ const (
 	colorBlue  = "\033[34m"
 )
 
-func checkErrorAndExit(err error) {
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func Connect(username string, password string, database string) (*gorm.DB, error) {
-	dsn := username + ":" + password + "@tcp(db:3306)/" + database + "?charset=utf8mb4&parseTime=True&loc=Local"
-	fmt.Println(colorRed + "DSN: " + dsn + colorReset)
-
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// Connect opens the database selected by the configured driver.
+func Connect(cfg config.Database) (*gorm.DB, error) {
+	dialector, err := dialector(cfg)
 	if err != nil {
 		return nil, err
 	}
-	return db, nil
-}
 
-func Migrate(db *gorm.DB) {
-	err := db.AutoMigrate(&model.Song{})
+	db, err := gorm.Open(dialector, &gorm.Config{
+		TranslateError: true,
+		// lookups for songs that don't exist yet are expected while seeding
+		Logger: logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
+			SlowThreshold:             200 * time.Millisecond,
+			LogLevel:                  logger.Warn,
+			IgnoreRecordNotFoundError: true,
+			Colorful:                  true,
+		}),
+	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-}
 
-func Seed(db *gorm.DB, path string) {
-	// getting all the songs in the songs directory
-	fmt.Println(colorRed + "Initializing songs" + colorReset)
-	err := os.Chdir(path)
-	checkErrorAndExit(err)
-
-	// reading files in current directory
-	files, err := os.ReadDir(".")
-	checkErrorAndExit(err)
-
-	// iterating over files
-	for _, file := range files {
-		fileInfo, err := os.Stat(file.Name())
-		checkErrorAndExit(err)
-
-		// if file is not a directory
-		if !fileInfo.IsDir() {
-			file, err := os.Open(file.Name())
-			if err != nil {
-				log.Fatal(err)
-			}
-			ParseFileToSongDatatype(db, file)
+	if cfg.Driver == "sqlite" {
+		// every connection to an in-memory database gets a database of its own,
+		// and sqlite only allows a single writer anyway
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
 		}
+		sqlDB.SetMaxOpenConns(1)
 	}
+
+	return db, nil
 }
 
-func ParseFileToSongDatatype(db *gorm.DB, file *os.File) {
-	// reading metadata from the file
-	m, err := tag.ReadFrom(file)
-	if err == nil {
-		if (db.Where("title = ?", strings.Split(file.Name(), ".")[0]).First(&model.Song{}).Error != nil) {
-			// save position of extension
-			extensionsPosition := len(strings.Split(file.Name(), ".")) - 1
-
-			// Make sure a . is allowed in the title as long as the extension isn't taken into account.
-			title := strings.Join(strings.Split(file.Name(), ".")[:extensionsPosition], ".")
-
-			// adding song to database
-			AddSong(db, model.Song{
-				Title:    title,
-				FileType: strings.Split(file.Name(), ".")[extensionsPosition],
-				Artist:   m.Artist(),
-				Path:     file.Name(),
-			})
+func dialector(cfg config.Database) (gorm.Dialector, error) {
+	switch cfg.Driver {
+	case "mysql":
+		port := cfg.Port
+		if port == 0 {
+			port = 3306
 		}
-	}
-}
 
-func GetSongById(db *gorm.DB, id int) (*model.Song, error) {
-	var song model.Song
-	err := db.First(&song, id).Error
-	if err != nil {
-		log.Println(err)
-		return nil, err
-	}
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local", cfg.User, cfg.Password, cfg.Host, port, cfg.Name)
+		fmt.Println(colorRed + "DSN: " + strings.Replace(dsn, ":"+cfg.Password+"@", ":****@", 1) + colorReset)
 
-	return &song, nil
-}
+		return mysql.Open(dsn), nil
+	case "postgres":
+		port := cfg.Port
+		if port == 0 {
+			port = 5432
+		}
 
-func GetSongByTitle(db *gorm.DB, searchTerm string) ([]model.Song, error) {
-	searchTerm = strings.ToLower(strings.ReplaceAll(searchTerm, " ", ""))
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(cfg.User, cfg.Password),
+			Host:     fmt.Sprintf("%s:%d", cfg.Host, port),
+			Path:     "/" + cfg.Name,
+			RawQuery: url.Values{"sslmode": {cfg.SSLMode}}.Encode(),
+		}
+		fmt.Println(colorRed + "DSN: " + dsn.Redacted() + colorReset)
 
-	fmt.Println("Searching for: ", searchTerm)
+		return postgres.Open(dsn.String()), nil
+	case "sqlite":
+		fmt.Println(colorRed + "DSN: " + cfg.Path + colorReset)
 
-	var songs []model.Song
-	err := db.Where("LOWER(REPLACE(title, ' ', '')) LIKE ?", "%"+searchTerm+"%").Find(&songs).Error
-	if err != nil {
-		log.Println(err)
-		return nil, err
+		return sqlite.Open(cfg.Path + "?_pragma=foreign_keys(1)"), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver '%s'", cfg.Driver)
 	}
-
-	return songs, nil
 }
 
-func GetSongs(db *gorm.DB) []model.Song {
-	var songs []model.Song
-
-	err := db.Find(&songs).Error
+// Migrate creates or updates the tables. Columns added to existing tables start
+// out empty, Seed fills in the metadata of songs that don't have it yet.
+func Migrate(db *gorm.DB) {
+	err := db.AutoMigrate(&model.Song{}, &model.Artist{}, &model.Album{}, &model.FeaturedArtist{}, &model.User{}, &model.APIKey{})
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
 	}
-
-	return songs
 }
 
-func RemoveSong(db *gorm.DB, song model.Song) error {
-	err := os.Remove(song.Path)
-	if err == nil {
-		return err
+// Seed scans the storage backend for songs, adding new ones, updating changed
+// ones and marking those whose file is gone as missing. Files that can't be
+// read are skipped, so one bad file never keeps the server from starting.
+func Seed(songs SongRepository, store storage.Backend) {
+	fmt.Println(colorRed + "Initializing songs" + colorReset)
+	summary, err := Scan(songs, store)
+	if err != nil {
+		log.Println("Could not scan the songs:", err)
+		return
 	}
 
-	err = db.Delete(&model.Song{}, song.ID).Error
-	if err != nil {
-		return err
+	for name, reason := range summary.Failed {
+		log.Printf("Skipping '%s': %s\n", name, reason)
 	}
+	for _, name := range summary.Missing {
+		log.Printf("Missing the file of '%s'\n", name)
+	}
+	fmt.Println(colorBlue + "Songs: " + summary.String() + colorReset)
+}
 
-	return nil
+// unlinked reports whether the song names an artist or album it isn't linked
+// to, or has no slug yet, like the songs stored before there were any.
+func unlinked(song *model.Song) bool {
+	return (song.ArtistID == 0 && song.Artist != "") || (song.AlbumID == 0 && song.Album != "") || song.Slug == ""
 }
 
-func AddSong(db *gorm.DB, song model.Song) error {
-	err := db.Create(&song).Error
-	if err != nil {
+func applyMetadata(song *model.Song, m metadata.Metadata) {
+	song.Artist = m.Artist
+	song.Album = m.Album
+	song.AlbumArtist = m.AlbumArtist
+	song.Genre = m.Genre
+	song.Composer = m.Composer
+	song.Year = m.Year
+	song.Track = m.Track
+	song.TrackTotal = m.TrackTotal
+	song.Disc = m.Disc
+	song.DiscTotal = m.DiscTotal
+	song.Lyrics = m.Lyrics
+	song.Duration = m.Duration.Seconds()
+	song.Bitrate = m.Bitrate
+	song.SampleRate = m.SampleRate
+	song.Channels = m.Channels
+	song.FileSize = m.FileSize
+	song.Hash = m.Hash
+}
+
+// RemoveSong deletes the song's file from the storage backend and its row from the database.
+func RemoveSong(songs SongRepository, store storage.Backend, song model.Song) error {
+	err := store.Delete(song.Path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
 
-	return nil
+	return songs.Delete(song.ID)
 }
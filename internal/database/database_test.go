@@ -1,12 +1,21 @@
 package database
 
 import (
+	"bytes"
+	"errors"
 	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"infiniti.com/config"
+	"infiniti.com/internal/storage"
 	model "infiniti.com/model"
 )
 
@@ -30,108 +39,748 @@ func createMockDB() (*gorm.DB, sqlmock.Sqlmock, error) {
 	return gormDB, mock, nil
 }
 
-func closeDB(db *gorm.DB) {
-	sqlDB, _ := db.DB()
-	sqlDB.Close()
+func createStore(t *testing.T, path string) storage.Backend {
+	store, err := storage.NewLocal(path)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	return store
 }
 
-func TestMigrate(t *testing.T) {
-	db, mock, err := createMockDB()
+// createTempStore copies the test songs into a temporary directory, so tests may remove them.
+func createTempStore(t *testing.T) storage.Backend {
+	store := createStore(t, t.TempDir())
+
+	files, err := os.ReadDir(PATH)
 	if err != nil {
-		t.Errorf("Failed to open database: %v", err)
+		t.Fatalf("Failed to read test songs: %v", err)
 	}
 
-	mock.ExpectExec("CREATE TABLE `songs`").WillReturnResult(sqlmock.NewResult(0, 1))
-	Migrate(db)
+	for _, file := range files {
+		content, err := os.Open(filepath.Join(PATH, file.Name()))
+		if err != nil {
+			t.Fatalf("Failed to open test song: %v", err)
+		}
+		err = store.Put(file.Name(), content)
+		content.Close()
+		if err != nil {
+			t.Fatalf("Failed to copy test song: %v", err)
+		}
+	}
 
-	closeDB(db)
+	return store
 }
 
-func TestSeed(t *testing.T) {
-	db, mock, err := createMockDB()
+func createSQLiteDB(t *testing.T) *gorm.DB {
+	db, err := Connect(config.Database{Driver: "sqlite", Path: ":memory:"})
 	if err != nil {
-		t.Errorf("Failed to open database: %v", err)
+		t.Fatalf("Failed to open sqlite database: %v", err)
 	}
+	t.Cleanup(func() { closeDB(db) })
 
-	mock.ExpectExec("CREATE TABLE `songs`").WillReturnResult(sqlmock.NewResult(0, 1))
 	Migrate(db)
+	return db
+}
 
-	mock.ExpectExec("INSERT INTO `songs`").WillReturnResult(sqlmock.NewResult(0, 1))
-	Seed(db, PATH)
+func closeDB(db *gorm.DB) {
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+}
 
-	closeDB(db)
+// repositories returns a fresh instance of every SongRepository implementation.
+func repositories(t *testing.T) map[string]SongRepository {
+	return map[string]SongRepository{
+		"memory": NewMemorySongRepository(),
+		"gorm":   NewGormSongRepository(createSQLiteDB(t)),
+	}
 }
 
-func TestGetSongById(t *testing.T) {
+func TestMigrate(t *testing.T) {
 	db, mock, err := createMockDB()
 	if err != nil {
 		t.Errorf("Failed to open database: %v", err)
 	}
 
 	mock.ExpectExec("CREATE TABLE `songs`").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("CREATE TABLE `artists`").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("CREATE TABLE `albums`").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("CREATE TABLE `featured_artists`").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("CREATE TABLE `users`").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("CREATE TABLE `api_keys`").WillReturnResult(sqlmock.NewResult(0, 1))
 	Migrate(db)
 
-	mock.ExpectExec("INSERT INTO `songs`").WillReturnResult(sqlmock.NewResult(0, 1))
-	Seed(db, PATH)
+	closeDB(db)
+}
 
-	mock.ExpectExec("SELECT * FROM `songs` WHERE `songs`.`id` = 1").WillReturnResult(sqlmock.NewResult(0, 1))
-	GetSongById(db, 1)
+func TestConnectUnknownDriver(t *testing.T) {
+	_, err := Connect(config.Database{Driver: "oracle"})
+	if err == nil {
+		t.Errorf("Expected an error for an unknown driver")
+	}
+}
 
-	closeDB(db)
+func TestSeed(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			store := createStore(t, PATH)
+
+			Seed(songs, store)
+			// seeding twice must not add the songs again
+			Seed(songs, store)
+
+			list, err := songs.List()
+			if err != nil || len(list) != 1 {
+				t.Fatalf("Expected 1 song after seeding; got %d, %v", len(list), err)
+			}
+			if list[0].Title != "Recording" || list[0].FileType != "mp3" || list[0].Path != "Recording.mp3" {
+				t.Errorf("Unexpected song %+v", list[0])
+			}
+
+			info, _ := store.Stat("Recording.mp3")
+			if list[0].Duration <= 0 || list[0].Bitrate <= 0 || list[0].FileSize != info.Size || len(list[0].Hash) != 64 {
+				t.Errorf("Expected the song's metadata to be stored; got %+v", list[0])
+			}
+		})
+	}
 }
 
-func TestGetSongByTitle(t *testing.T) {
-	db, mock, err := createMockDB()
-	if err != nil {
-		t.Errorf("Failed to open database: %v", err)
+func TestSeedBackfillsMetadata(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			// a song stored before its metadata was
+			old := model.Song{Title: "Recording", FileType: "mp3", Path: "Recording.mp3"}
+			songs.Create(&old)
+
+			Seed(songs, createStore(t, PATH))
+
+			song, err := songs.Get(old.ID)
+			if err != nil || song.Hash == "" || song.Duration <= 0 {
+				t.Errorf("Expected the metadata to be filled in; got %+v, %v", song, err)
+			}
+		})
 	}
+}
 
-	mock.ExpectExec("CREATE TABLE `songs`").WillReturnResult(sqlmock.NewResult(0, 1))
-	Migrate(db)
+func TestSeedCovers(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			store := createTempStore(t)
+			content, _ := os.ReadFile(filepath.Join(PATH, "Recording.mp3"))
+			store.Put("album/One.mp3", bytes.NewReader(content))
+			store.Put("album/Two.mp3", bytes.NewReader(content))
+			store.Put("album/folder.png", strings.NewReader("front"))
+
+			Seed(songs, store)
+
+			one, _ := songs.Find("One")
+			two, _ := songs.Find("Two")
+			if one == nil || two == nil || one.CoverHash == "" || one.CoverHash != two.CoverHash {
+				t.Fatalf("Expected both songs to share the folder's cover; got %+v and %+v", one, two)
+			}
+
+			covers, _ := store.List(COVERDIR)
+			if len(covers) != 1 || covers[0].Name != CoverPath(one.CoverHash) {
+				t.Errorf("Expected the cover to be stored once under its hash; got %+v", covers)
+			}
+
+			recording, _ := songs.Find("Recording")
+			if recording.CoverHash != "" {
+				t.Errorf("Expected no cover for a song without one; got %q", recording.CoverHash)
+			}
+
+			list, _ := songs.List()
+			if len(list) != 3 {
+				t.Errorf("Expected covers not to be taken for songs; got %d songs", len(list))
+			}
+		})
+	}
+}
 
-	mock.ExpectExec("INSERT INTO `songs`").WillReturnResult(sqlmock.NewResult(0, 1))
-	Seed(db, PATH)
+func TestScan(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			store := createTempStore(t)
+			content, _ := os.ReadFile(filepath.Join(PATH, "Recording.mp3"))
+			// trailing bytes tell the copies apart without breaking their frames
+			store.Put("Artist/Album/01 Intro.mp3", bytes.NewReader(append(content, "one"...)))
+			store.Put("Other/Album/01 Intro.mp3", bytes.NewReader(append(content, "two"...)))
+			store.Put("broken.mp3", strings.NewReader("not a song"))
+
+			summary, err := Scan(songs, store)
+			if err != nil {
+				t.Fatalf("Failed to scan: %v", err)
+			}
+			if len(summary.Added) != 3 || len(summary.Failed) != 1 || summary.Failed["broken.mp3"] == "" {
+				t.Fatalf("Expected 3 songs added and the broken one failed; got %v %+v", summary, summary.Failed)
+			}
+
+			intro, _ := songs.FindPath("Artist/Album/01 Intro.mp3")
+			other, _ := songs.FindPath("Other/Album/01 Intro.mp3")
+			if intro == nil || other == nil || intro.Title != "01 Intro" || other.Title != "01 Intro (Other/Album)" {
+				t.Errorf("Expected songs sharing a file name to be told apart by directory; got %+v and %+v", intro, other)
+			}
+
+			summary, _ = Scan(songs, store)
+			if summary.Unchanged != 3 || len(summary.Added)+len(summary.Updated)+len(summary.Missing) != 0 {
+				t.Errorf("Expected a rescan to leave every song alone; got %v", summary)
+			}
+
+			store.Put("Recording.mp3", bytes.NewReader(append(content, "changed"...)))
+			store.Put("Other/Album/02 Intro.mp3", bytes.NewReader(append(content, "two"...)))
+			store.Delete("Other/Album/01 Intro.mp3")
+			store.Delete("Artist/Album/01 Intro.mp3")
+
+			summary, err = Scan(songs, store)
+			if err != nil {
+				t.Fatalf("Failed to rescan: %v", err)
+			}
+			if !slices.Equal(summary.Updated, []string{"Other/Album/02 Intro.mp3", "Recording.mp3"}) || !slices.Equal(summary.Missing, []string{"Artist/Album/01 Intro.mp3"}) {
+				t.Errorf("Expected the changed and moved songs updated and the deleted one missing; got %+v", summary)
+			}
+
+			moved, err := songs.FindPath("Other/Album/02 Intro.mp3")
+			if err != nil || moved.ID != other.ID || moved.Title != "02 Intro" {
+				t.Errorf("Expected the moved file to keep its song; got %+v, %v", moved, err)
+			}
+
+			list, _ := songs.List()
+			if len(list) != 3 {
+				t.Errorf("Expected the missing song to be kept; got %d songs", len(list))
+			}
+			if gone, _ := songs.FindPath("Artist/Album/01 Intro.mp3"); gone == nil || !gone.Missing {
+				t.Errorf("Expected the song of the deleted file to be marked as missing; got %+v", gone)
+			}
+
+			// a storage backend listing nothing leaves the library alone
+			for _, name := range []string{"Recording.mp3", "Other/Album/02 Intro.mp3", "broken.mp3"} {
+				store.Delete(name)
+			}
+			summary, err = Scan(songs, store)
+			if err != nil || len(summary.Missing) != 3 {
+				t.Errorf("Expected every song to be missing; got %v, %v", summary, err)
+			}
+			if list, _ := songs.List(); len(list) != 3 {
+				t.Errorf("Expected the songs to be kept; got %d", len(list))
+			}
+
+			// a file that is back is no longer missing
+			store.Put("Recording.mp3", bytes.NewReader(append(content, "changed"...)))
+			summary, _ = Scan(songs, store)
+			back, _ := songs.FindPath("Recording.mp3")
+			if !slices.Equal(summary.Updated, []string{"Recording.mp3"}) || back == nil || back.Missing {
+				t.Errorf("Expected the song of the file that is back not to be missing; got %v, %+v", summary, back)
+			}
+		})
+	}
+}
 
-	searchTerm := "summer"
+func TestScanStoredModTime(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			store := createTempStore(t)
+			content, _ := os.ReadFile(filepath.Join(PATH, "Recording.mp3"))
+			store.Put("Recording.mp3", bytes.NewReader(content))
+			Scan(songs, store)
+
+			// the database keeps the modification time to the millisecond, or the second
+			for _, precision := range []time.Duration{time.Millisecond, time.Second} {
+				song, _ := songs.FindPath("Recording.mp3")
+				song.ModTime = song.ModTime.Truncate(precision)
+				songs.Update(song)
+
+				summary, err := Scan(songs, store)
+				if err != nil || summary.Unchanged != 1 {
+					t.Errorf("Expected a song stored to the %v to be unchanged; got %v, %v", precision, summary, err)
+				}
+				// a song that is read again is saved with the modification time of its file
+				if stored, _ := songs.FindPath("Recording.mp3"); !stored.ModTime.Equal(song.ModTime) {
+					t.Errorf("Expected a song stored to the %v not to be read again; got %v for %v", precision, stored.ModTime, song.ModTime)
+				}
+			}
+		})
+	}
+}
 
-	mock.ExpectExec("SELECT * FROM `songs` WHERE `songs`.`title` LIKE ?" + "%" + searchTerm + "%").WillReturnResult(sqlmock.NewResult(0, 1))
-	GetSongByTitle(db, searchTerm)
+func TestRemoveSong(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			store := createTempStore(t)
+
+			Seed(songs, store)
+			song, err := songs.Find("Recording")
+			if err != nil {
+				t.Fatalf("Expected the song to be seeded; got %v", err)
+			}
+
+			err = RemoveSong(songs, store, *song)
+			if err != nil {
+				t.Fatalf("Failed to remove song: %v", err)
+			}
+
+			if _, err := songs.Get(song.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected the song to be removed from the database; got %v", err)
+			}
+			if _, err := store.Stat(song.Path); err == nil {
+				t.Errorf("Expected the song to be removed from storage")
+			}
+		})
+	}
+}
 
-	closeDB(db)
+func TestRepositoryCreateAndGet(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			song := model.Song{Title: "Summer Nights", FileType: "mp3", Artist: "Someone", Path: "summer.mp3"}
+			err := songs.Create(&song)
+			if err != nil {
+				t.Fatalf("Failed to create song: %v", err)
+			}
+			if song.ID == 0 {
+				t.Errorf("Expected the created song to get an id")
+			}
+
+			found, err := songs.Get(song.ID)
+			if err != nil || *found != song {
+				t.Errorf("Expected to get the created song; got %+v, %v", found, err)
+			}
+
+			found, err = songs.Find("summer nights")
+			if err != nil || found.ID != song.ID {
+				t.Errorf("Expected to find the song by its title ignoring case; got %+v, %v", found, err)
+			}
+
+			_, err = songs.Get(song.ID + 1)
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for a missing song; got %v", err)
+			}
+
+			_, err = songs.Find("Winter Nights")
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for a missing title; got %v", err)
+			}
+
+			err = songs.Create(&model.Song{Title: "SUMMER NIGHTS", Path: "other.mp3"})
+			if !errors.Is(err, ErrDuplicate) {
+				t.Errorf("Expected ErrDuplicate for an existing title; got %v", err)
+			}
+		})
+	}
 }
 
-func TestGetSongs(t *testing.T) {
-	db, mock, err := createMockDB()
-	if err != nil {
-		t.Errorf("Failed to open database: %v", err)
+func TestRepositoryListUpdateDelete(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			first := model.Song{Title: "First", Path: "first.mp3"}
+			second := model.Song{Title: "Second", Path: "second.mp3"}
+			songs.Create(&first)
+			songs.Create(&second)
+
+			list, err := songs.List()
+			if err != nil || len(list) != 2 || list[0].ID != first.ID || list[1].ID != second.ID {
+				t.Fatalf("Expected both songs ordered by id; got %+v, %v", list, err)
+			}
+
+			first.Artist = "Artist"
+			err = songs.Update(&first)
+			if err != nil {
+				t.Fatalf("Failed to update song: %v", err)
+			}
+			found, _ := songs.Get(first.ID)
+			if found.Artist != "Artist" {
+				t.Errorf("Expected the update to be stored; got %+v", found)
+			}
+
+			second.Title = "first"
+			err = songs.Update(&second)
+			if !errors.Is(err, ErrDuplicate) {
+				t.Errorf("Expected ErrDuplicate when renaming to an existing title; got %v", err)
+			}
+
+			err = songs.Update(&model.Song{ID: 100, Title: "Missing"})
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound when updating a missing song; got %v", err)
+			}
+
+			err = songs.Delete(first.ID)
+			if err != nil {
+				t.Fatalf("Failed to delete song: %v", err)
+			}
+			err = songs.Delete(first.ID)
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound when deleting twice; got %v", err)
+			}
+
+			list, _ = songs.List()
+			if len(list) != 1 {
+				t.Errorf("Expected 1 song after deleting; got %d", len(list))
+			}
+		})
 	}
+}
 
-	mock.ExpectExec("CREATE TABLE `songs`").WillReturnResult(sqlmock.NewResult(0, 1))
-	Migrate(db)
+func TestRepositorySearch(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			songs.Create(&model.Song{Title: "Summer Nights", FileType: "mp3", Path: "summer.mp3"})
+			songs.Create(&model.Song{Title: "100% Pure", FileType: "mp3", Path: "pure.mp3"})
+			songs.Create(&model.Song{Title: "1000 Pure", FileType: "mp3", Path: "thousand.mp3"})
+
+			tests := map[string]int{
+				"summer":      1,
+				"SUMMERNIGHT": 1,
+				"summer nigh": 1,
+				"winter":      0,
+				"100%":        1,
+				"100":         2,
+				"_":           0,
+			}
+
+			for term, expected := range tests {
+				found, err := songs.Search(term)
+				if err != nil {
+					t.Fatalf("Failed to search for %q: %v", term, err)
+				}
+				if len(found) != expected {
+					t.Errorf("Expected %d songs for %q; got %d", expected, term, len(found))
+				}
+			}
+		})
+	}
+}
 
-	mock.ExpectExec("INSERT INTO `songs`").WillReturnResult(sqlmock.NewResult(0, 1))
-	Seed(db, PATH)
+func TestRepositoryQuery(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			songs.Create(&model.Song{Title: "b side", Artist: "Queen", Album: "Jazz", Duration: 200, FileType: "mp3", Path: "b.mp3"})
+			songs.Create(&model.Song{Title: "A Side", Artist: "Queen", Album: "Jazz", Duration: 100, FileType: "flac", Path: "a.flac"})
+			songs.Create(&model.Song{Title: "Intro", Artist: "ABBA", Duration: 200, FileType: "mp3", Path: "intro.mp3"})
+			songs.Create(&model.Song{Title: "Outro", Artist: "abba", Duration: 30, FileType: "ogg", Path: "outro.ogg"})
+
+			titles := func(query SongQuery) []string {
+				found, _, err := songs.Query(query)
+				if err != nil {
+					t.Fatalf("Failed to query %+v: %v", query, err)
+				}
+				var titles []string
+				for _, song := range found {
+					titles = append(titles, song.Title)
+				}
+				return titles
+			}
+
+			tests := []struct {
+				query    SongQuery
+				expected []string
+			}{
+				{SongQuery{}, []string{"b side", "A Side", "Intro", "Outro"}},
+				{SongQuery{Sort: "title"}, []string{"A Side", "b side", "Intro", "Outro"}},
+				{SongQuery{Sort: "added", Desc: true}, []string{"Outro", "Intro", "A Side", "b side"}},
+				{SongQuery{Sort: "duration"}, []string{"Outro", "A Side", "b side", "Intro"}},
+				{SongQuery{Sort: "duration", Desc: true}, []string{"Intro", "b side", "A Side", "Outro"}},
+				{SongQuery{Artist: "ABBA"}, []string{"Intro", "Outro"}},
+				{SongQuery{Album: "jazz", Sort: "title"}, []string{"A Side", "b side"}},
+				{SongQuery{FileTypes: []string{"MP3", "ogg"}}, []string{"b side", "Intro", "Outro"}},
+				{SongQuery{MinDuration: 100, MaxDuration: 150}, []string{"A Side"}},
+				{SongQuery{Offset: 1, Limit: 2}, []string{"A Side", "Intro"}},
+				{SongQuery{Offset: 10}, nil},
+			}
+			for _, test := range tests {
+				if found := titles(test.query); !slices.Equal(found, test.expected) {
+					t.Errorf("Expected %v for %+v; got %v", test.expected, test.query, found)
+				}
+			}
+
+			_, total, _ := songs.Query(SongQuery{Artist: "queen", Limit: 1})
+			if total != 2 {
+				t.Errorf("Expected a total of 2 songs; got %d", total)
+			}
+
+			// paging by cursor goes through every song once, in order
+			for _, sort := range []string{"title", "duration", "id"} {
+				for _, desc := range []bool{false, true} {
+					query := SongQuery{Sort: sort, Desc: desc}
+					expected := titles(query)
+					query.Limit = 1
+
+					var paged []string
+					for range expected {
+						found, _, err := songs.Query(query)
+						if err != nil || len(found) != 1 {
+							t.Fatalf("Failed to page through %+v: %v, %v", query, found, err)
+						}
+						paged = append(paged, found[0].Title)
+						query.Cursor = NewCursor(found[0], query)
+					}
+					if !slices.Equal(paged, expected) {
+						t.Errorf("Expected %v paging by %s; got %v", expected, sort, paged)
+					}
+					if last, _, _ := songs.Query(query); len(last) != 0 {
+						t.Errorf("Expected no songs after the last one; got %v", last)
+					}
+				}
+			}
+
+			invalid := []SongQuery{
+				{Sort: "genre"},
+				{Cursor: "!"},
+				{Cursor: NewCursor(model.Song{ID: 1}, SongQuery{Sort: "title"})},
+			}
+			for _, query := range invalid {
+				if _, _, err := songs.Query(query); !errors.Is(err, ErrInvalidQuery) {
+					t.Errorf("Expected ErrInvalidQuery for %+v; got %v", query, err)
+				}
+			}
+		})
+	}
+}
 
-	mock.ExpectQuery("SELECT * FROM `songs`").WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "album", "genre", "path"}))
-	GetSongs(db)
+func TestRepositorySlugs(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			titles := map[string]string{
+				"Hungarian Dance No.5": "hungarian-dance-no-5",
+				"Hungarian Dance No 5": "hungarian-dance-no-5-2",
+				"Ça Plane Pour Moi!":   "ça-plane-pour-moi",
+				"???":                  "song",
+			}
+
+			for title, slug := range titles {
+				song := &model.Song{Title: title, FileType: "mp3", Path: title + ".mp3"}
+				err := songs.Create(song)
+				if err != nil {
+					t.Fatalf("Failed to create %q: %v", title, err)
+				}
+				// the numbered slug goes to whichever song comes last
+				if song.Slug != slug && song.Slug != slug+"-2" && song.Slug+"-2" != slug {
+					t.Errorf("Expected the slug %q for %q; got %q", slug, title, song.Slug)
+				}
+
+				found, err := songs.FindSlug(song.Slug)
+				if err != nil || found.ID != song.ID {
+					t.Errorf("Expected %q to be found by its slug; got %+v, %v", title, found, err)
+				}
+			}
+
+			song, _ := songs.FindSlug("song")
+			song.Title = "Question Marks"
+			songs.Update(song)
+			if updated, _ := songs.Get(song.ID); updated.Slug != "song" {
+				t.Errorf("Expected the slug to be kept; got %q", updated.Slug)
+			}
+
+			if _, err := songs.FindSlug("unknown"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for an unknown slug; got %v", err)
+			}
+		})
+	}
+}
 
-	closeDB(db)
+func TestRepositoryLibrary(t *testing.T) {
+	for name, songs := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			library := songs.(LibraryRepository)
+
+			tracks := []model.Song{
+				{Title: "Closer", Artist: "The Band", Album: "Second", Year: 2004, Track: 2},
+				{Title: "Opener", Artist: "the  band", Album: "second", Year: 2004, Track: 1, CoverHash: "cover"},
+				{Title: "Debut", Artist: "The Band feat. Guest & Other", Album: "First", Year: 2001, Track: 1},
+				{Title: "Encore (ft. The Band)", Artist: "Guest", Album: "Live", AlbumArtist: "Various Artists"},
+			}
+			for i := range tracks {
+				err := songs.Create(&tracks[i])
+				if err != nil {
+					t.Fatalf("Failed to create %q: %v", tracks[i].Title, err)
+				}
+			}
+
+			artists, err := library.Artists()
+			if err != nil {
+				t.Fatalf("Failed to list artists: %v", err)
+			}
+			var names []string
+			for _, artist := range artists {
+				names = append(names, artist.Name)
+			}
+			if !slices.Equal(names, []string{"Guest", "Other", "The Band", "Various Artists"}) {
+				t.Errorf("Expected every artist once, ordered by name; got %v", names)
+			}
+
+			band := tracks[0].ArtistID
+			if band == 0 || tracks[1].ArtistID != band || tracks[2].ArtistID != band {
+				t.Errorf("Expected the spellings of The Band to be one artist; got %d, %d and %d", band, tracks[1].ArtistID, tracks[2].ArtistID)
+			}
+
+			albums, err := library.ArtistAlbums(band)
+			if err != nil || len(albums) != 2 || albums[0].Title != "First" || albums[1].Title != "Second" || albums[1].Year != 2004 {
+				t.Fatalf("Expected the albums of The Band ordered by year; got %+v, %v", albums, err)
+			}
+
+			if albums[1].CoverHash != "cover" {
+				t.Errorf("Expected the album to take the cover of its first song that has one; got %q", albums[1].CoverHash)
+			}
+
+			ordered, err := library.AlbumTracks(albums[1].ID)
+			if err != nil || len(ordered) != 2 || ordered[0].Title != "Opener" || ordered[1].Title != "Closer" {
+				t.Errorf("Expected the tracks ordered by track number; got %+v, %v", ordered, err)
+			}
+
+			featured, err := library.FeaturedArtists(tracks[2].ID)
+			if err != nil || len(featured) != 2 || featured[0].Name != "Guest" || featured[1].Name != "Other" {
+				t.Errorf("Expected Guest and Other to be featured; got %+v, %v", featured, err)
+			}
+			featured, _ = library.FeaturedArtists(tracks[3].ID)
+			if len(featured) != 1 || featured[0].ID != band {
+				t.Errorf("Expected The Band to be featured from the title; got %+v", featured)
+			}
+
+			live, _ := library.GetAlbum(tracks[3].AlbumID)
+			various, _ := library.GetArtist(live.ArtistID)
+			if various == nil || various.Name != "Various Artists" {
+				t.Errorf("Expected the album artist to release Live; got %+v", various)
+			}
+
+			// the last song of an album takes the album, and artists nobody credits anymore, along
+			songs.Delete(tracks[3].ID)
+			songs.Delete(tracks[2].ID)
+			_, err = library.GetAlbum(tracks[3].AlbumID)
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected the empty album to be removed; got %v", err)
+			}
+			artists, _ = library.Artists()
+			if len(artists) != 1 || artists[0].ID != band {
+				t.Errorf("Expected only The Band to be left; got %+v", artists)
+			}
+			albums, _ = library.Albums()
+			if len(albums) != 1 || albums[0].Title != "Second" {
+				t.Errorf("Expected only Second to be left; got %+v", albums)
+			}
+		})
+	}
 }
 
-func TestRemoveSong(t *testing.T) {
-	db, mock, err := createMockDB()
+func TestPruneNullLinks(t *testing.T) {
+	db := createSQLiteDB(t)
+	songs := NewGormSongRepository(db)
+
+	// songs stored before there were albums and artists have no links at all
+	old := &model.Song{Title: "Old", Path: "old.mp3"}
+	songs.Create(old)
+	db.Exec("UPDATE songs SET album_id = NULL, artist_id = NULL WHERE id = ?", old.ID)
+
+	song := &model.Song{Title: "New", Artist: "Band", Album: "First"}
+	songs.Create(song)
+	song.Album = "Second"
+	err := songs.Update(song)
 	if err != nil {
-		t.Errorf("Failed to open database: %v", err)
+		t.Fatalf("Failed to update the song: %v", err)
 	}
 
-	mock.ExpectExec("CREATE TABLE `songs`").WillReturnResult(sqlmock.NewResult(0, 1))
-	Migrate(db)
+	var titles []string
+	db.Model(&model.Album{}).Order("title").Pluck("title", &titles)
+	if !slices.Equal(titles, []string{"Second"}) {
+		t.Errorf("Expected the album the song left to be removed; got %v", titles)
+	}
+	var artists []string
+	db.Model(&model.Artist{}).Pluck("name", &artists)
+	if !slices.Equal(artists, []string{"Band"}) {
+		t.Errorf("Expected the artist of the song to be kept; got %v", artists)
+	}
 
-	mock.ExpectExec("INSERT INTO `songs`").WillReturnResult(sqlmock.NewResult(0, 1))
-	Seed(db, PATH)
+	unlinked, _ := songs.Get(old.ID)
+	if err := songs.Update(unlinked); err != nil {
+		t.Errorf("Failed to update a song without links: %v", err)
+	}
+}
 
-	mock.ExpectExec("SELECT * FROM `songs` WHERE `songs`.`id` = 1").WillReturnResult(sqlmock.NewResult(0, 1))
-	RemoveSong(db, model.Song{ID: 1, Path: PATH + "/Recording.mp3"})
+// userRepositories returns a fresh instance of every UserRepository implementation.
+func userRepositories(t *testing.T) map[string]UserRepository {
+	return map[string]UserRepository{
+		"memory": NewMemoryUserRepository(),
+		"gorm":   NewGormUserRepository(createSQLiteDB(t)),
+	}
+}
 
-	closeDB(db)
+func TestUserRepository(t *testing.T) {
+	for name, users := range userRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			alice := &model.User{Username: "alice", PasswordHash: "hash"}
+			err := users.CreateUser(alice)
+			if err != nil || alice.ID == 0 {
+				t.Fatalf("Expected the user to be created with an id; got %+v, %v", alice, err)
+			}
+			err = users.CreateUser(&model.User{Username: "alice"})
+			if !errors.Is(err, ErrUserExists) {
+				t.Errorf("Expected ErrUserExists; got %v", err)
+			}
+			bob := &model.User{Username: "bob"}
+			users.CreateUser(bob)
+
+			if count, _ := users.CountUsers(); count != 2 {
+				t.Errorf("Expected 2 users; got %d", count)
+			}
+			if found, err := users.FindUser("alice"); err != nil || found.ID != alice.ID || found.PasswordHash != "hash" || found.Role != "listener" {
+				t.Errorf("Expected to find alice the listener; got %+v, %v", found, err)
+			}
+			if _, err := users.FindUser("carol"); !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("Expected ErrUserNotFound; got %v", err)
+			}
+			if _, err := users.GetUser(100); !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("Expected ErrUserNotFound; got %v", err)
+			}
+
+			first := &model.APIKey{UserID: alice.ID, Name: "first", Hash: "1"}
+			second := &model.APIKey{UserID: alice.ID, Name: "second", Hash: "2"}
+			for _, key := range []*model.APIKey{first, second, {UserID: bob.ID, Name: "bob's", Hash: "3"}} {
+				err := users.CreateAPIKey(key)
+				if err != nil {
+					t.Fatalf("Failed to create api key: %v", err)
+				}
+			}
+
+			keys, _ := users.APIKeys(alice.ID)
+			if len(keys) != 2 || keys[0].Name != "first" || keys[1].Name != "second" {
+				t.Errorf("Expected the keys of alice in order; got %+v", keys)
+			}
+			if key, err := users.FindAPIKey("2"); err != nil || key.ID != second.ID {
+				t.Errorf("Expected to find the second key; got %+v, %v", key, err)
+			}
+
+			// a key can only be deleted by its user
+			if err := users.DeleteAPIKey(bob.ID, first.ID); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected ErrKeyNotFound deleting another user's key; got %v", err)
+			}
+			if err := users.DeleteAPIKey(alice.ID, first.ID); err != nil {
+				t.Errorf("Failed to delete api key: %v", err)
+			}
+			if _, err := users.FindAPIKey("1"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected the deleted key to be gone; got %v", err)
+			}
+
+			alice.Role = "admin"
+			err = users.UpdateUser(alice)
+			if err != nil {
+				t.Errorf("Failed to update user: %v", err)
+			}
+			if found, _ := users.GetUser(alice.ID); found.Role != "admin" {
+				t.Errorf("Expected alice to be an admin; got %q", found.Role)
+			}
+			if err := users.UpdateUser(&model.User{ID: 100, Username: "nobody"}); !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("Expected ErrUserNotFound updating a missing user; got %v", err)
+			}
+
+			err = users.DeleteUser(alice.ID)
+			if err != nil {
+				t.Errorf("Failed to delete user: %v", err)
+			}
+			if _, err := users.FindAPIKey("2"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected the keys of the deleted user to be gone; got %v", err)
+			}
+			if list, _ := users.ListUsers(); len(list) != 1 || list[0].ID != bob.ID {
+				t.Errorf("Expected only bob to be left; got %+v", list)
+			}
+			if err := users.DeleteUser(alice.ID); !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("Expected ErrUserNotFound deleting alice again; got %v", err)
+			}
+		})
+	}
 }
@@ -0,0 +1,578 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+	model "infiniti.com/model"
+)
+
+// likeEscaper escapes the wildcards of a LIKE pattern, using the same escape
+// character on every database.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// GormSongRepository stores songs in one of the databases supported by Connect.
+type GormSongRepository struct {
+	db *gorm.DB
+}
+
+func NewGormSongRepository(db *gorm.DB) *GormSongRepository {
+	return &GormSongRepository{db: db}
+}
+
+func (r *GormSongRepository) Get(id uint) (*model.Song, error) {
+	var song model.Song
+	err := r.db.First(&song, id).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &song, nil
+}
+
+func (r *GormSongRepository) Find(title string) (*model.Song, error) {
+	var song model.Song
+	err := r.db.Where("LOWER(title) = LOWER(?)", title).First(&song).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &song, nil
+}
+
+func (r *GormSongRepository) FindPath(path string) (*model.Song, error) {
+	var song model.Song
+	err := r.db.Where("path = ?", path).First(&song).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &song, nil
+}
+
+func (r *GormSongRepository) FindSlug(slug string) (*model.Song, error) {
+	var song model.Song
+	err := r.db.Where("slug = ?", slug).First(&song).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &song, nil
+}
+
+func (r *GormSongRepository) FindHash(hash string) ([]model.Song, error) {
+	songs := []model.Song{}
+	err := r.db.Where("hash = ?", hash).Order("id").Find(&songs).Error
+	return songs, err
+}
+
+func (r *GormSongRepository) List() ([]model.Song, error) {
+	var songs []model.Song
+	err := r.db.Order("id").Find(&songs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return songs, nil
+}
+
+func (r *GormSongRepository) Query(query SongQuery) ([]model.Song, int64, error) {
+	c, err := query.validate()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	err = r.filter(query).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	column := sortColumns[query.sort()]
+	direction, beyond := "ASC", ">"
+	if query.Desc {
+		direction, beyond = "DESC", "<"
+	}
+
+	db := r.filter(query)
+	switch {
+	case c != nil && c.Value == nil:
+		db = db.Where("id "+beyond+" ?", c.ID)
+	case c != nil:
+		db = db.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, beyond, column, beyond), c.Value, c.Value, c.ID)
+	default:
+		db = db.Offset(query.Offset)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+
+	order := "id " + direction
+	if column != "id" {
+		order = column + " " + direction + ", " + order
+	}
+
+	songs := []model.Song{}
+	err = db.Order(order).Find(&songs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return songs, total, nil
+}
+
+// filter selects the songs passing the filters of the query.
+func (r *GormSongRepository) filter(query SongQuery) *gorm.DB {
+	db := r.db.Model(&model.Song{})
+	if query.Artist != "" {
+		db = db.Where("artist_id IN (?)", r.db.Model(&model.Artist{}).Select("id").Where("name_key = ?", nameKey(query.Artist)))
+	}
+	if query.Album != "" {
+		db = db.Where("album_id IN (?)", r.db.Model(&model.Album{}).Select("id").Where("title_key = ?", nameKey(query.Album)))
+	}
+	if query.Genre != "" {
+		db = db.Where("LOWER(genre) = LOWER(?)", query.Genre)
+	}
+	if len(query.FileTypes) > 0 {
+		fileTypes := make([]string, len(query.FileTypes))
+		for i, fileType := range query.FileTypes {
+			fileTypes[i] = strings.ToLower(fileType)
+		}
+		db = db.Where("LOWER(file_type) IN ?", fileTypes)
+	}
+	if query.MinDuration > 0 {
+		db = db.Where("duration >= ?", query.MinDuration)
+	}
+	if query.MaxDuration > 0 {
+		db = db.Where("duration <= ?", query.MaxDuration)
+	}
+	return db
+}
+
+func (r *GormSongRepository) Create(song *model.Song) error {
+	_, err := r.Find(song.Title)
+	if err == nil {
+		return ErrDuplicate
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	return translateError(r.db.Transaction(func(tx *gorm.DB) error {
+		featured, err := link(tx, song)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(song).Error
+		if err != nil {
+			return err
+		}
+
+		return setFeatured(tx, song.ID, featured)
+	}))
+}
+
+func (r *GormSongRepository) Update(song *model.Song) error {
+	_, err := r.Get(song.ID)
+	if err != nil {
+		return err
+	}
+
+	existing, err := r.Find(song.Title)
+	if err == nil && existing.ID != song.ID {
+		return ErrDuplicate
+	}
+
+	return translateError(r.db.Transaction(func(tx *gorm.DB) error {
+		stale, err := linksOf(tx, song.ID)
+		if err != nil {
+			return err
+		}
+
+		featured, err := link(tx, song)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Save(song).Error
+		if err != nil {
+			return err
+		}
+
+		err = setFeatured(tx, song.ID, featured)
+		if err != nil {
+			return err
+		}
+
+		return prune(tx, stale)
+	}))
+}
+
+func (r *GormSongRepository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		stale, err := linksOf(tx, id)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Delete(&model.Song{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		err = setFeatured(tx, id, nil)
+		if err != nil {
+			return err
+		}
+
+		return prune(tx, stale)
+	})
+}
+
+func (r *GormSongRepository) Search(term string) ([]model.Song, error) {
+	term = normalizeSearchTerm(term)
+
+	var songs []model.Song
+	err := r.db.Where("LOWER(REPLACE(title, ' ', '')) LIKE ? ESCAPE '!'", "%"+likeEscaper.Replace(term)+"%").Order("id").Find(&songs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return songs, nil
+}
+
+func translateError(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrDuplicate
+	default:
+		return err
+	}
+}
+
+func (r *GormSongRepository) Artists() ([]model.Artist, error) {
+	artists := []model.Artist{}
+	err := r.db.Order("name_key").Find(&artists).Error
+	return artists, err
+}
+
+func (r *GormSongRepository) GetArtist(id uint) (*model.Artist, error) {
+	var artist model.Artist
+	err := r.db.First(&artist, id).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &artist, nil
+}
+
+func (r *GormSongRepository) Albums() ([]model.Album, error) {
+	albums := []model.Album{}
+	err := r.db.Order("title_key, id").Find(&albums).Error
+	return albums, err
+}
+
+func (r *GormSongRepository) GetAlbum(id uint) (*model.Album, error) {
+	var album model.Album
+	err := r.db.First(&album, id).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &album, nil
+}
+
+func (r *GormSongRepository) ArtistAlbums(artistID uint) ([]model.Album, error) {
+	albums := []model.Album{}
+	err := r.db.Where("artist_id = ?", artistID).Order("year, title_key").Find(&albums).Error
+	return albums, err
+}
+
+func (r *GormSongRepository) AlbumTracks(albumID uint) ([]model.Song, error) {
+	songs := []model.Song{}
+	err := r.db.Where("album_id = ?", albumID).Order("disc, track, title").Find(&songs).Error
+	return songs, err
+}
+
+func (r *GormSongRepository) FeaturedArtists(songID uint) ([]model.Artist, error) {
+	artists := []model.Artist{}
+	err := r.db.Where("id IN (?)", r.db.Model(&model.FeaturedArtist{}).Select("artist_id").Where("song_id = ?", songID)).
+		Order("name_key").Find(&artists).Error
+	return artists, err
+}
+
+// link points the song to the artist and album named by its tags, creating
+// them if they don't exist yet. It returns the ids of the featured artists.
+func link(tx *gorm.DB, song *model.Song) ([]uint, error) {
+	c := songCredits(*song)
+
+	var err error
+	if song.Slug == "" {
+		song.Slug, err = uniqueSlug(song.Title, func(slug string) (bool, error) {
+			var count int64
+			err := tx.Model(&model.Song{}).Where("slug = ? AND id <> ?", slug, song.ID).Count(&count).Error
+			return count > 0, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	song.ArtistID, err = ensureArtist(tx, c.artist)
+	if err != nil {
+		return nil, err
+	}
+
+	song.AlbumID = 0
+	if nameKey(c.album) != "" {
+		albumArtistID, err := ensureArtist(tx, c.albumArtist)
+		if err != nil {
+			return nil, err
+		}
+
+		album := model.Album{Title: cleanName(c.album), TitleKey: nameKey(c.album), ArtistID: albumArtistID, Year: song.Year}
+		err = tx.Where("title_key = ? AND artist_id = ?", album.TitleKey, album.ArtistID).FirstOrCreate(&album).Error
+		if err != nil {
+			return nil, err
+		}
+		song.AlbumID = album.ID
+
+		if album.CoverHash == "" && song.CoverHash != "" {
+			err = tx.Model(&album).Update("cover_hash", song.CoverHash).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var featured []uint
+	for _, name := range c.featured {
+		id, err := ensureArtist(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		if id != 0 && id != song.ArtistID && !slices.Contains(featured, id) {
+			featured = append(featured, id)
+		}
+	}
+
+	return featured, nil
+}
+
+// ensureArtist returns the id of the artist with the name, creating the artist
+// if it doesn't exist yet. Empty names belong to no artist and give 0.
+func ensureArtist(tx *gorm.DB, name string) (uint, error) {
+	key := nameKey(name)
+	if key == "" {
+		return 0, nil
+	}
+
+	artist := model.Artist{Name: cleanName(name), NameKey: key}
+	err := tx.Where("name_key = ?", key).FirstOrCreate(&artist).Error
+	return artist.ID, err
+}
+
+// setFeatured replaces the artists featured on the song.
+func setFeatured(tx *gorm.DB, songID uint, artistIDs []uint) error {
+	err := tx.Where("song_id = ?", songID).Delete(&model.FeaturedArtist{}).Error
+	if err != nil || len(artistIDs) == 0 {
+		return err
+	}
+
+	featured := make([]model.FeaturedArtist, len(artistIDs))
+	for i, id := range artistIDs {
+		featured[i] = model.FeaturedArtist{SongID: songID, ArtistID: id}
+	}
+	return tx.Create(&featured).Error
+}
+
+// links are the album and artists a song is linked to.
+type links struct {
+	album   uint
+	artists []uint
+}
+
+// linksOf returns the album and artists the song with the id is linked to,
+// including the artist of its album, none if there is no such song.
+func linksOf(tx *gorm.DB, id uint) (links, error) {
+	var song model.Song
+	err := tx.Select("id", "artist_id", "album_id").Limit(1).Find(&song, id).Error
+	if err != nil || song.ID == 0 {
+		return links{}, err
+	}
+
+	var artists []uint
+	err = tx.Model(&model.FeaturedArtist{}).Where("song_id = ?", id).Pluck("artist_id", &artists).Error
+	if err != nil {
+		return links{}, err
+	}
+	artists = append(artists, song.ArtistID)
+
+	if song.AlbumID != 0 {
+		var album model.Album
+		err = tx.Select("artist_id").Limit(1).Find(&album, song.AlbumID).Error
+		if err != nil {
+			return links{}, err
+		}
+		artists = append(artists, album.ArtistID)
+	}
+
+	return links{album: song.AlbumID, artists: artists}, nil
+}
+
+// prune removes the album and artists a song was linked to if no song links to
+// them anymore. Only those are looked at, so saving a song stays quick however
+// large the library is. NOT EXISTS is used as songs saved before there were
+// albums and artists may have NULL links, which NOT IN never matches.
+func prune(tx *gorm.DB, stale links) error {
+	if stale.album != 0 {
+		err := tx.Where("id = ? AND NOT EXISTS (?)", stale.album,
+			tx.Model(&model.Song{}).Select("1").Where("songs.album_id = albums.id"),
+		).Delete(&model.Album{}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(stale.artists) == 0 {
+		return nil
+	}
+	return tx.Where("id IN ? AND NOT EXISTS (?) AND NOT EXISTS (?) AND NOT EXISTS (?)", stale.artists,
+		tx.Model(&model.Song{}).Select("1").Where("songs.artist_id = artists.id"),
+		tx.Model(&model.Album{}).Select("1").Where("albums.artist_id = artists.id"),
+		tx.Model(&model.FeaturedArtist{}).Select("1").Where("featured_artists.artist_id = artists.id"),
+	).Delete(&model.Artist{}).Error
+}
+
+// GormUserRepository stores users in one of the databases supported by Connect.
+type GormUserRepository struct {
+	db *gorm.DB
+}
+
+func NewGormUserRepository(db *gorm.DB) *GormUserRepository {
+	return &GormUserRepository{db: db}
+}
+
+func (r *GormUserRepository) GetUser(id uint) (*model.User, error) {
+	var user model.User
+	err := r.db.First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *GormUserRepository) FindUser(username string) (*model.User, error) {
+	var user model.User
+	err := r.db.Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *GormUserRepository) ListUsers() ([]model.User, error) {
+	users := []model.User{}
+	err := r.db.Order("id").Find(&users).Error
+	return users, err
+}
+
+func (r *GormUserRepository) CountUsers() (int64, error) {
+	var count int64
+	err := r.db.Model(&model.User{}).Count(&count).Error
+	return count, err
+}
+
+func (r *GormUserRepository) CreateUser(user *model.User) error {
+	_, err := r.FindUser(user.Username)
+	if err == nil {
+		return ErrUserExists
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return err
+	}
+
+	err = r.db.Create(user).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrUserExists
+	}
+	return err
+}
+
+func (r *GormUserRepository) UpdateUser(user *model.User) error {
+	_, err := r.GetUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = r.db.Save(user).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrUserExists
+	}
+	return err
+}
+
+func (r *GormUserRepository) DeleteUser(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&model.User{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrUserNotFound
+		}
+
+		return tx.Where("user_id = ?", id).Delete(&model.APIKey{}).Error
+	})
+}
+
+func (r *GormUserRepository) APIKeys(userID uint) ([]model.APIKey, error) {
+	keys := []model.APIKey{}
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *GormUserRepository) FindAPIKey(hash string) (*model.APIKey, error) {
+	var key model.APIKey
+	err := r.db.Where("hash = ?", hash).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (r *GormUserRepository) CreateAPIKey(key *model.APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *GormUserRepository) DeleteAPIKey(userID uint, id uint) error {
+	result := r.db.Where("user_id = ?", userID).Delete(&model.APIKey{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+
+	model "infiniti.com/model"
+)
+
+// LibraryRepository browses the artists and albums songs are linked to. Songs
+// are linked by their tags whenever a SongRepository stores them, and artists
+// and albums without songs are removed along with their last song.
+type LibraryRepository interface {
+	// Artists returns every artist, ordered by name.
+	Artists() ([]model.Artist, error)
+	GetArtist(id uint) (*model.Artist, error)
+	// Albums returns every album, ordered by title.
+	Albums() ([]model.Album, error)
+	GetAlbum(id uint) (*model.Album, error)
+	// ArtistAlbums returns the albums released by the artist, ordered by year and title.
+	ArtistAlbums(artistID uint) ([]model.Album, error)
+	// AlbumTracks returns the songs on the album, ordered by disc and track number.
+	AlbumTracks(albumID uint) ([]model.Song, error)
+	// FeaturedArtists returns the artists featured on the song besides its main artist, ordered by name.
+	FeaturedArtists(songID uint) ([]model.Artist, error)
+}
+
+// featuring finds the featured artists in an artist tag or title, like
+// "Artist feat. Other" or "Title (ft. Other & Another)".
+var featuring = regexp.MustCompile(`(?i)\s*[(\[]?\s*\b(?:feat\.|ft\.|featuring)\s+([^)\]]+)[)\]]?`)
+
+// credits names the artists and album a song is linked to.
+type credits struct {
+	artist      string
+	featured    []string
+	album       string
+	albumArtist string
+}
+
+// songCredits takes the credits of a song from its tags. The main artist is
+// the artist tag without anyone it features, who are credited separately.
+func songCredits(song model.Song) credits {
+	var c credits
+
+	c.artist = song.Artist
+	for _, text := range []string{song.Artist, song.Title} {
+		for _, match := range featuring.FindAllStringSubmatch(text, -1) {
+			for _, name := range strings.FieldsFunc(match[1], func(r rune) bool { return r == ',' || r == '&' }) {
+				c.featured = append(c.featured, name)
+			}
+		}
+	}
+	c.artist = featuring.ReplaceAllString(c.artist, "")
+
+	c.album = song.Album
+	c.albumArtist = song.AlbumArtist
+	if nameKey(c.albumArtist) == "" {
+		c.albumArtist = c.artist
+	}
+
+	return c
+}
+
+// Credits returns the names of the main artist, album and album artist of a
+// song as the artist and album it is linked to have them, so without anyone
+// it features. The album artist is the main artist if it has none.
+func Credits(song model.Song) (artist string, album string, albumArtist string) {
+	c := songCredits(song)
+	return cleanName(c.artist), cleanName(c.album), cleanName(c.albumArtist)
+}
+
+// nameKey makes names comparable, ignoring case and spacing.
+func nameKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// cleanName trims and collapses the spacing of a name.
+func cleanName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
@@ -0,0 +1,573 @@
+package database
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	model "infiniti.com/model"
+)
+
+// MemorySongRepository keeps songs in memory, which makes it useful for tests
+// and for running without a database. It is safe for concurrent use.
+type MemorySongRepository struct {
+	songs  map[uint]model.Song
+	nextID uint
+
+	artists      map[uint]model.Artist
+	nextArtistID uint
+	albums       map[uint]model.Album
+	nextAlbumID  uint
+	// featured holds the ids of the artists featured on each song
+	featured map[uint][]uint
+
+	mu sync.RWMutex
+}
+
+func NewMemorySongRepository() *MemorySongRepository {
+	return &MemorySongRepository{
+		songs:        make(map[uint]model.Song),
+		nextID:       1,
+		artists:      make(map[uint]model.Artist),
+		nextArtistID: 1,
+		albums:       make(map[uint]model.Album),
+		nextAlbumID:  1,
+		featured:     make(map[uint][]uint),
+	}
+}
+
+func (r *MemorySongRepository) Get(id uint) (*model.Song, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	song, ok := r.songs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &song, nil
+}
+
+func (r *MemorySongRepository) Find(title string) (*model.Song, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	song, ok := r.find(title)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &song, nil
+}
+
+func (r *MemorySongRepository) find(title string) (model.Song, bool) {
+	for _, song := range r.songs {
+		if strings.EqualFold(song.Title, title) {
+			return song, true
+		}
+	}
+	return model.Song{}, false
+}
+
+func (r *MemorySongRepository) FindPath(path string) (*model.Song, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	songs := r.sorted(func(song model.Song) bool { return song.Path == path })
+	if len(songs) == 0 {
+		return nil, ErrNotFound
+	}
+	return &songs[0], nil
+}
+
+func (r *MemorySongRepository) FindSlug(slug string) (*model.Song, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	songs := r.sorted(func(song model.Song) bool { return song.Slug == slug })
+	if len(songs) == 0 {
+		return nil, ErrNotFound
+	}
+	return &songs[0], nil
+}
+
+func (r *MemorySongRepository) FindHash(hash string) ([]model.Song, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	return r.sorted(func(song model.Song) bool { return song.Hash == hash }), nil
+}
+
+func (r *MemorySongRepository) List() ([]model.Song, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	return r.sorted(func(model.Song) bool { return true }), nil
+}
+
+func (r *MemorySongRepository) Query(query SongQuery) ([]model.Song, int64, error) {
+	c, err := query.validate()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	songs := r.sorted(func(song model.Song) bool { return r.matches(song, query) })
+	slices.SortStableFunc(songs, func(a, b model.Song) int { return compareSongs(a, b, query) })
+	return page(songs, query, c), int64(len(songs)), nil
+}
+
+func (r *MemorySongRepository) Create(song *model.Song) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	if _, ok := r.find(song.Title); ok {
+		return ErrDuplicate
+	}
+
+	song.ID = r.nextID
+	r.nextID++
+	r.link(song)
+	r.songs[song.ID] = *song
+	return nil
+}
+
+func (r *MemorySongRepository) Update(song *model.Song) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	if _, ok := r.songs[song.ID]; !ok {
+		return ErrNotFound
+	}
+	if existing, ok := r.find(song.Title); ok && existing.ID != song.ID {
+		return ErrDuplicate
+	}
+
+	r.link(song)
+	r.songs[song.ID] = *song
+	r.prune()
+	return nil
+}
+
+func (r *MemorySongRepository) Delete(id uint) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	if _, ok := r.songs[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(r.songs, id)
+	delete(r.featured, id)
+	r.prune()
+	return nil
+}
+
+func (r *MemorySongRepository) Search(term string) ([]model.Song, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	term = normalizeSearchTerm(term)
+	return r.sorted(func(song model.Song) bool {
+		return strings.Contains(normalizeSearchTerm(song.Title), term)
+	}), nil
+}
+
+func (r *MemorySongRepository) Artists() ([]model.Artist, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	artists := []model.Artist{}
+	for _, artist := range r.artists {
+		artists = append(artists, artist)
+	}
+
+	sort.Slice(artists, func(i, j int) bool { return artists[i].NameKey < artists[j].NameKey })
+	return artists, nil
+}
+
+func (r *MemorySongRepository) GetArtist(id uint) (*model.Artist, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	artist, ok := r.artists[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &artist, nil
+}
+
+func (r *MemorySongRepository) Albums() ([]model.Album, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	return r.sortedAlbums(func(model.Album) bool { return true }, func(a, b model.Album) bool {
+		return a.TitleKey < b.TitleKey || (a.TitleKey == b.TitleKey && a.ID < b.ID)
+	}), nil
+}
+
+func (r *MemorySongRepository) GetAlbum(id uint) (*model.Album, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	album, ok := r.albums[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &album, nil
+}
+
+func (r *MemorySongRepository) ArtistAlbums(artistID uint) ([]model.Album, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	return r.sortedAlbums(func(album model.Album) bool { return album.ArtistID == artistID }, func(a, b model.Album) bool {
+		return a.Year < b.Year || (a.Year == b.Year && a.TitleKey < b.TitleKey)
+	}), nil
+}
+
+func (r *MemorySongRepository) AlbumTracks(albumID uint) ([]model.Song, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	songs := r.sorted(func(song model.Song) bool { return song.AlbumID == albumID })
+	sort.SliceStable(songs, func(i, j int) bool {
+		a, b := songs[i], songs[j]
+		if a.Disc != b.Disc {
+			return a.Disc < b.Disc
+		}
+		if a.Track != b.Track {
+			return a.Track < b.Track
+		}
+		return a.Title < b.Title
+	})
+	return songs, nil
+}
+
+func (r *MemorySongRepository) FeaturedArtists(songID uint) ([]model.Artist, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	artists := []model.Artist{}
+	for _, id := range r.featured[songID] {
+		artists = append(artists, r.artists[id])
+	}
+
+	sort.Slice(artists, func(i, j int) bool { return artists[i].NameKey < artists[j].NameKey })
+	return artists, nil
+}
+
+// link points the song to the artist and album named by its tags, creating
+// them if they don't exist yet, and records the artists it features.
+func (r *MemorySongRepository) link(song *model.Song) {
+	c := songCredits(*song)
+
+	if song.Slug == "" {
+		song.Slug, _ = uniqueSlug(song.Title, func(slug string) (bool, error) {
+			for _, other := range r.songs {
+				if other.Slug == slug && other.ID != song.ID {
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+	}
+
+	song.ArtistID = r.ensureArtist(c.artist)
+
+	song.AlbumID = 0
+	if key := nameKey(c.album); key != "" {
+		albumArtistID := r.ensureArtist(c.albumArtist)
+		for _, album := range r.albums {
+			if album.TitleKey == key && album.ArtistID == albumArtistID {
+				song.AlbumID = album.ID
+			}
+		}
+
+		if song.AlbumID == 0 {
+			album := model.Album{ID: r.nextAlbumID, Title: cleanName(c.album), TitleKey: key, ArtistID: albumArtistID, Year: song.Year}
+			r.nextAlbumID++
+			r.albums[album.ID] = album
+			song.AlbumID = album.ID
+		}
+
+		if album := r.albums[song.AlbumID]; album.CoverHash == "" && song.CoverHash != "" {
+			album.CoverHash = song.CoverHash
+			r.albums[album.ID] = album
+		}
+	}
+
+	var featured []uint
+	for _, name := range c.featured {
+		id := r.ensureArtist(name)
+		if id != 0 && id != song.ArtistID && !slices.Contains(featured, id) {
+			featured = append(featured, id)
+		}
+	}
+
+	r.featured[song.ID] = featured
+}
+
+// ensureArtist returns the id of the artist with the name, creating the artist
+// if it doesn't exist yet. Empty names belong to no artist and give 0.
+func (r *MemorySongRepository) ensureArtist(name string) uint {
+	key := nameKey(name)
+	if key == "" {
+		return 0
+	}
+
+	for _, artist := range r.artists {
+		if artist.NameKey == key {
+			return artist.ID
+		}
+	}
+
+	artist := model.Artist{ID: r.nextArtistID, Name: cleanName(name), NameKey: key}
+	r.nextArtistID++
+	r.artists[artist.ID] = artist
+	return artist.ID
+}
+
+// prune removes the albums and artists that no song links to anymore.
+func (r *MemorySongRepository) prune() {
+	used := make(map[uint]bool)
+	for _, song := range r.songs {
+		used[song.AlbumID] = true
+	}
+	for id := range r.albums {
+		if !used[id] {
+			delete(r.albums, id)
+		}
+	}
+
+	clear(used)
+	for _, song := range r.songs {
+		used[song.ArtistID] = true
+	}
+	for _, album := range r.albums {
+		used[album.ArtistID] = true
+	}
+	for _, ids := range r.featured {
+		for _, id := range ids {
+			used[id] = true
+		}
+	}
+	for id := range r.artists {
+		if !used[id] {
+			delete(r.artists, id)
+		}
+	}
+}
+
+// sortedAlbums returns the albums matching the filter, ordered by less.
+func (r *MemorySongRepository) sortedAlbums(filter func(model.Album) bool, less func(a, b model.Album) bool) []model.Album {
+	albums := []model.Album{}
+	for _, album := range r.albums {
+		if filter(album) {
+			albums = append(albums, album)
+		}
+	}
+
+	sort.Slice(albums, func(i, j int) bool { return less(albums[i], albums[j]) })
+	return albums
+}
+
+// matches reports whether the song passes the filters of the query.
+func (r *MemorySongRepository) matches(song model.Song, query SongQuery) bool {
+	switch {
+	case query.Artist != "" && r.artists[song.ArtistID].NameKey != nameKey(query.Artist):
+		return false
+	case query.Album != "" && r.albums[song.AlbumID].TitleKey != nameKey(query.Album):
+		return false
+	case query.Genre != "" && !strings.EqualFold(song.Genre, query.Genre):
+		return false
+	case len(query.FileTypes) > 0 && !slices.ContainsFunc(query.FileTypes, func(fileType string) bool { return strings.EqualFold(fileType, song.FileType) }):
+		return false
+	case song.Duration < query.MinDuration || (query.MaxDuration > 0 && song.Duration > query.MaxDuration):
+		return false
+	}
+	return true
+}
+
+// sorted returns the songs matching the filter, ordered by their id.
+func (r *MemorySongRepository) sorted(filter func(model.Song) bool) []model.Song {
+	songs := []model.Song{}
+	for _, song := range r.songs {
+		if filter(song) {
+			songs = append(songs, song)
+		}
+	}
+
+	sort.Slice(songs, func(i, j int) bool { return songs[i].ID < songs[j].ID })
+	return songs
+}
+
+// MemoryUserRepository keeps users in memory, which makes it useful for tests.
+// It is safe for concurrent use.
+type MemoryUserRepository struct {
+	users     map[uint]model.User
+	nextID    uint
+	keys      map[uint]model.APIKey
+	nextKeyID uint
+
+	mu sync.RWMutex
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{
+		users:     make(map[uint]model.User),
+		nextID:    1,
+		keys:      make(map[uint]model.APIKey),
+		nextKeyID: 1,
+	}
+}
+
+func (r *MemoryUserRepository) GetUser(id uint) (*model.User, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) FindUser(username string) (*model.User, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	for _, user := range r.users {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (r *MemoryUserRepository) ListUsers() ([]model.User, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	users := make([]model.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (r *MemoryUserRepository) CountUsers() (int64, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	return int64(len(r.users)), nil
+}
+
+func (r *MemoryUserRepository) CreateUser(user *model.User) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	for _, other := range r.users {
+		if other.Username == user.Username {
+			return ErrUserExists
+		}
+	}
+
+	user.ID = r.nextID
+	r.nextID++
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	// like the default of the column
+	if user.Role == "" {
+		user.Role = "listener"
+	}
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *MemoryUserRepository) UpdateUser(user *model.User) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	if _, ok := r.users[user.ID]; !ok {
+		return ErrUserNotFound
+	}
+	for _, other := range r.users {
+		if other.Username == user.Username && other.ID != user.ID {
+			return ErrUserExists
+		}
+	}
+
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *MemoryUserRepository) DeleteUser(id uint) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	if _, ok := r.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	delete(r.users, id)
+	for keyID, key := range r.keys {
+		if key.UserID == id {
+			delete(r.keys, keyID)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryUserRepository) APIKeys(userID uint) ([]model.APIKey, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	keys := []model.APIKey{}
+	for _, key := range r.keys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (r *MemoryUserRepository) FindAPIKey(hash string) (*model.APIKey, error) {
+	defer r.mu.RUnlock()
+	r.mu.RLock()
+
+	for _, key := range r.keys {
+		if key.Hash == hash {
+			return &key, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+func (r *MemoryUserRepository) CreateAPIKey(key *model.APIKey) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	key.ID = r.nextKeyID
+	r.nextKeyID++
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	r.keys[key.ID] = *key
+	return nil
+}
+
+func (r *MemoryUserRepository) DeleteAPIKey(userID uint, id uint) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	if key, ok := r.keys[id]; !ok || key.UserID != userID {
+		return ErrKeyNotFound
+	}
+	delete(r.keys, id)
+	return nil
+}
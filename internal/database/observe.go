@@ -0,0 +1,86 @@
+package database
+
+import (
+	"sync"
+
+	model "infiniti.com/model"
+)
+
+// Listener is told about the songs saved or deleted through an ObservedRepository.
+type Listener interface {
+	// SongSaved is called with a song after it was created or updated.
+	SongSaved(song model.Song)
+	// SongDeleted is called with a song after it was deleted.
+	SongDeleted(song model.Song)
+}
+
+// ObservedRepository tells its listeners about every change made through the
+// repository it wraps, so what is kept beside the database, like the search
+// index, stays in sync with it whether the change came from a scan, the
+// watcher, an upload or the API.
+type ObservedRepository struct {
+	SongRepository
+
+	listeners []Listener
+	// mu keeps changes and their notifications in the same order
+	mu sync.Mutex
+}
+
+func Observe(songs SongRepository) *ObservedRepository {
+	return &ObservedRepository{SongRepository: songs}
+}
+
+// Listen adds a listener, which is told about the changes made from now on.
+func (r *ObservedRepository) Listen(listener Listener) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	r.listeners = append(r.listeners, listener)
+}
+
+func (r *ObservedRepository) Create(song *model.Song) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	err := r.SongRepository.Create(song)
+	if err != nil {
+		return err
+	}
+	for _, listener := range r.listeners {
+		listener.SongSaved(*song)
+	}
+	return nil
+}
+
+func (r *ObservedRepository) Update(song *model.Song) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	err := r.SongRepository.Update(song)
+	if err != nil {
+		return err
+	}
+	for _, listener := range r.listeners {
+		listener.SongSaved(*song)
+	}
+	return nil
+}
+
+func (r *ObservedRepository) Delete(id uint) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+
+	song := model.Song{ID: id}
+	if existing, err := r.SongRepository.Get(id); err == nil {
+		song = *existing
+	}
+
+	err := r.SongRepository.Delete(id)
+	if err != nil {
+		return err
+	}
+	for _, listener := range r.listeners {
+		listener.SongDeleted(song)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	model "infiniti.com/model"
+)
+
+// ErrInvalidQuery is returned for song queries with an unknown sort field or a broken cursor.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// sortColumns holds the column, or expression, of every field songs can be
+// sorted by. Songs are added with increasing ids, so "added" sorts by id.
+var sortColumns = map[string]string{
+	"id":       "id",
+	"added":    "id",
+	"title":    "LOWER(title)",
+	"artist":   "LOWER(artist)",
+	"album":    "LOWER(album)",
+	"duration": "duration",
+}
+
+// SongQuery selects, orders and pages the songs returned by SongRepository.Query.
+type SongQuery struct {
+	// Artist and Album match the name of the artist and the title of the album songs are linked to, ignoring case.
+	Artist string
+	Album  string
+	// Genre matches the genre of songs, ignoring case.
+	Genre string
+	// FileTypes holds the file types to match, any of them if empty.
+	FileTypes []string
+	// MinDuration and MaxDuration bound the duration of songs in seconds, a MaxDuration of 0 leaves it unbounded.
+	MinDuration float64
+	MaxDuration float64
+
+	// Sort is the field songs are sorted by: id, added, title, artist, album or duration, id if empty.
+	// Songs that are equal in it are sorted by id, so the order is stable.
+	Sort string
+	Desc bool
+
+	// Cursor continues after the song it was made from by NewCursor, taking the place of Offset.
+	Cursor string
+	Offset int
+	// Limit is the most songs returned, all of them if 0.
+	Limit int
+}
+
+// cursor is the decoded form of SongQuery.Cursor: the sort value and id of a song in an order.
+type cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value any    `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// NewCursor returns the cursor continuing the query after the song.
+func NewCursor(song model.Song, query SongQuery) string {
+	sort := query.sort()
+	content, _ := json.Marshal(cursor{Sort: sort, Desc: query.Desc, Value: sortValue(song, sort), ID: song.ID})
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// sort returns the sort field of the query.
+func (query SongQuery) sort() string {
+	if query.Sort == "" {
+		return "id"
+	}
+	return query.Sort
+}
+
+// validate checks the sort field and decodes the cursor of the query, which is nil if it has none.
+func (query SongQuery) validate() (*cursor, error) {
+	if _, ok := sortColumns[query.sort()]; !ok {
+		return nil, fmt.Errorf("%w: cannot sort by '%s'", ErrInvalidQuery, query.Sort)
+	}
+	if query.Cursor == "" {
+		return nil, nil
+	}
+
+	content, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	var c cursor
+	err = json.Unmarshal(content, &c)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if c.Sort != query.sort() || c.Desc != query.Desc {
+		return nil, fmt.Errorf("%w: cursor belongs to another order", ErrInvalidQuery)
+	}
+
+	// numbers come back from JSON as float64 and text as string, like sortValue makes them
+	switch c.Value.(type) {
+	case nil:
+		if sortValue(model.Song{}, c.Sort) != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+		}
+	case string, float64:
+		if _, ok := c.Value.(string); ok != isText(c.Sort) {
+			return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+		}
+	default:
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return &c, nil
+}
+
+// sortValue returns what the song is sorted by for the sort field, nil if that is its id.
+func sortValue(song model.Song, sort string) any {
+	switch sort {
+	case "title":
+		return strings.ToLower(song.Title)
+	case "artist":
+		return strings.ToLower(song.Artist)
+	case "album":
+		return strings.ToLower(song.Album)
+	case "duration":
+		return song.Duration
+	}
+	return nil
+}
+
+func isText(sort string) bool {
+	return sort == "title" || sort == "artist" || sort == "album"
+}
+
+// compareSongs orders two songs like the query, by its sort field and then by id.
+func compareSongs(a model.Song, b model.Song, query SongQuery) int {
+	order := 0
+	switch va := sortValue(a, query.sort()).(type) {
+	case string:
+		order = strings.Compare(va, sortValue(b, query.sort()).(string))
+	case float64:
+		order = cmp.Compare(va, sortValue(b, query.sort()).(float64))
+	}
+	if order == 0 {
+		order = cmp.Compare(a.ID, b.ID)
+	}
+
+	if query.Desc {
+		return -order
+	}
+	return order
+}
+
+// after reports whether the song comes after the cursor in its order.
+func (c *cursor) after(song model.Song) bool {
+	order := 0
+	switch v := c.Value.(type) {
+	case string:
+		order = strings.Compare(sortValue(song, c.Sort).(string), v)
+	case float64:
+		order = cmp.Compare(sortValue(song, c.Sort).(float64), v)
+	}
+	if order == 0 {
+		order = cmp.Compare(song.ID, c.ID)
+	}
+
+	if c.Desc {
+		return order < 0
+	}
+	return order > 0
+}
+
+// page returns the songs of the query from the sorted songs after the cursor, or else its offset.
+func page(songs []model.Song, query SongQuery, c *cursor) []model.Song {
+	if c != nil {
+		i := slices.IndexFunc(songs, c.after)
+		if i < 0 {
+			i = len(songs)
+		}
+		songs = songs[i:]
+	} else {
+		songs = songs[min(max(query.Offset, 0), len(songs)):]
+	}
+
+	if query.Limit > 0 && len(songs) > query.Limit {
+		songs = songs[:query.Limit]
+	}
+	return songs
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	model "infiniti.com/model"
+)
+
+var (
+	// ErrNotFound is returned when no song matches a lookup.
+	ErrNotFound = errors.New("song not found")
+	// ErrDuplicate is returned when a song with the same title already exists.
+	ErrDuplicate = errors.New("song already exists")
+)
+
+// SongRepository stores the songs of the music library.
+type SongRepository interface {
+	Get(id uint) (*model.Song, error)
+	// Find returns the song with the given title, ignoring case.
+	Find(title string) (*model.Song, error)
+	// FindPath returns the song stored under the path in the storage backend.
+	FindPath(path string) (*model.Song, error)
+	// FindSlug returns the song with the slug.
+	FindSlug(slug string) (*model.Song, error)
+	// FindHash returns the songs whose file has the hash, ordered by their id.
+	FindHash(hash string) ([]model.Song, error)
+	List() ([]model.Song, error)
+	// Query returns a page of the songs matching the query, in its order, and the count of all songs matching it.
+	Query(query SongQuery) ([]model.Song, int64, error)
+	Create(song *model.Song) error
+	Update(song *model.Song) error
+	Delete(id uint) error
+	// Search returns the songs whose title contains the search term, ignoring case and spaces.
+	Search(term string) ([]model.Song, error)
+}
+
+// normalizeSearchTerm makes a title or search term comparable in the way Search expects.
+func normalizeSearchTerm(term string) string {
+	return strings.ToLower(strings.ReplaceAll(term, " ", ""))
+}
+
+// MAXSLUGLEN is the longest a slug may be in bytes, numbering included.
+const MAXSLUGLEN = 96
+
+// slugify turns a title into a slug: lowercase letters and digits, with a dash
+// for everything in between, as in "hungarian-dance-no-5".
+func slugify(title string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	// room is left for the numbering
+	s := slug.String()
+	for len(s) > MAXSLUGLEN-8 {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	s = strings.TrimRight(s, "-")
+
+	if s == "" {
+		return "song"
+	}
+	return s
+}
+
+// uniqueSlug returns the slug of the title, numbered as in "song-2" if taken reports it is in use.
+func uniqueSlug(title string, taken func(slug string) (bool, error)) (string, error) {
+	base := slugify(title)
+	for i := 1; ; i++ {
+		slug := base
+		if i > 1 {
+			slug = fmt.Sprintf("%s-%d", base, i)
+		}
+
+		used, err := taken(slug)
+		if err != nil || !used {
+			return slug, err
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"infiniti.com/internal/metadata"
+	"infiniti.com/internal/storage"
+	model "infiniti.com/model"
+)
+
+// Change tells what ingesting a file did to its song.
+type Change int
+
+const (
+	Unchanged Change = iota
+	Added
+	Updated
+)
+
+// ScanSummary reports what a scan of the storage backend changed in the library.
+type ScanSummary struct {
+	Added   []string
+	Updated []string
+	// Missing holds the songs whose file is gone. They are kept and marked as
+	// missing, as a storage backend that is briefly unavailable may list no
+	// files at all.
+	Missing []string
+	// Failed holds why each file that couldn't be ingested failed.
+	Failed    map[string]string
+	Unchanged int
+}
+
+func (s ScanSummary) String() string {
+	return fmt.Sprintf("%d added, %d updated, %d missing, %d failed, %d unchanged",
+		len(s.Added), len(s.Updated), len(s.Missing), len(s.Failed), s.Unchanged)
+}
+
+// Scan brings the library up to date with the files in every directory of the
+// storage backend. Files whose size and modification time still match their
+// song aren't read again, so rescanning a large library is quick. Songs whose
+// file is gone are marked as missing, not removed, until their file is back. A
+// file that can't be ingested is reported in the summary and doesn't stop the
+// scan.
+func Scan(songs SongRepository, store storage.Backend) (ScanSummary, error) {
+	summary := ScanSummary{Failed: make(map[string]string)}
+
+	files, err := store.List("")
+	if err != nil {
+		return summary, err
+	}
+
+	list, err := songs.List()
+	if err != nil {
+		return summary, err
+	}
+
+	known := make(map[string]model.Song, len(list))
+	for _, song := range list {
+		known[song.Path] = song
+	}
+
+	covers := make(map[string]string)
+	listed := make(map[string]bool, len(files))
+	for _, info := range files {
+		if !IsSongFile(info.Name) {
+			continue
+		}
+		listed[info.Name] = true
+
+		var existing *model.Song
+		if song, ok := known[info.Name]; ok {
+			existing = &song
+		}
+
+		change, err := ingest(songs, store, info, existing, covers)
+		switch {
+		case err != nil:
+			summary.Failed[info.Name] = err.Error()
+		case change == Added:
+			summary.Added = append(summary.Added, info.Name)
+		case change == Updated:
+			summary.Updated = append(summary.Updated, info.Name)
+		default:
+			summary.Unchanged++
+		}
+	}
+
+	// listed again, as the songs of moved files have their new path by now
+	list, err = songs.List()
+	if err != nil {
+		return summary, err
+	}
+
+	for _, song := range list {
+		if listed[song.Path] {
+			continue
+		}
+
+		if !song.Missing {
+			err = MarkMissing(songs, &song)
+			if err != nil {
+				summary.Failed[song.Path] = err.Error()
+				continue
+			}
+		}
+		summary.Missing = append(summary.Missing, song.Path)
+	}
+
+	return summary, nil
+}
+
+// Ingest adds the song stored under name to the library, or updates its song
+// if the file changed since it was last read.
+func Ingest(songs SongRepository, store storage.Backend, name string) (Change, error) {
+	info, err := store.Stat(name)
+	if err != nil {
+		return Unchanged, err
+	}
+
+	existing, err := songs.FindPath(name)
+	if errors.Is(err, ErrNotFound) {
+		existing, err = nil, nil
+	}
+	if err != nil {
+		return Unchanged, err
+	}
+
+	return ingest(songs, store, info, existing, make(map[string]string))
+}
+
+// MarkMissing marks the song as missing its file, which is cleared again once
+// the file is ingested.
+func MarkMissing(songs SongRepository, song *model.Song) error {
+	song.Missing = true
+	return songs.Update(song)
+}
+
+// IsSongFile reports whether the file stored under name may be a song. Covers
+// are stored next to the songs but aren't songs themselves.
+func IsSongFile(name string) bool {
+	return !strings.HasPrefix(name, COVERDIR+"/") && !strings.HasPrefix(mime.TypeByExtension(path.Ext(name)), "image/")
+}
+
+// ingest reads the file and stores what it finds in its song, which is nil if
+// the file is new. The cover file found in each directory is kept in covers.
+func ingest(songs SongRepository, store storage.Backend, info storage.FileInfo, existing *model.Song, covers map[string]string) (Change, error) {
+	if existing != nil && existing.FileSize == info.Size && sameModTime(existing.ModTime, info.ModTime) && existing.Hash != "" && !unlinked(existing) && !existing.Missing {
+		if existing.CoverHash != "" {
+			return Unchanged, nil
+		}
+
+		// a cover file may have been added to its directory since
+		coverHash, err := coverOf(store, info.Name, nil, covers)
+		if err != nil || coverHash == "" {
+			return Unchanged, err
+		}
+		existing.CoverHash = coverHash
+		return Updated, songs.Update(existing)
+	}
+
+	file, err := store.Open(info.Name)
+	if err != nil {
+		return Unchanged, err
+	}
+	defer file.Close()
+
+	title, fileType := songName(info.Name)
+	m, err := metadata.Read(file, fileType)
+	if err != nil {
+		return Unchanged, err
+	}
+
+	coverHash, err := coverOf(store, info.Name, m.Cover, covers)
+	if err != nil {
+		log.Printf("Skipping the cover of '%s': %v\n", info.Name, err)
+	}
+
+	song, change := existing, Updated
+	named := song == nil
+	if named {
+		// a file that was moved or renamed keeps its song
+		song, err = moved(songs, store, m.Hash)
+		if err != nil {
+			return Unchanged, err
+		}
+		if song != nil {
+			song.Title, song.FileType, song.Path = title, fileType, info.Name
+		} else {
+			song, change = &model.Song{Title: title, FileType: fileType, Path: info.Name}, Added
+		}
+	} else if song.Hash == m.Hash && (coverHash == "" || coverHash == song.CoverHash) && !unlinked(song) && !song.Missing {
+		// only touched, so there is nothing new but its modification time
+		change = Unchanged
+	}
+
+	applyMetadata(song, m)
+	song.ModTime = info.ModTime
+	song.Missing = false
+	if coverHash != "" {
+		song.CoverHash = coverHash
+	}
+
+	save := songs.Update
+	if change == Added {
+		save = songs.Create
+	}
+
+	err = save(song)
+	if errors.Is(err, ErrDuplicate) && named && path.Dir(info.Name) != "." {
+		// files in different directories may share a name, like "01 Intro.mp3"
+		song.Title = fmt.Sprintf("%s (%s)", title, path.Dir(info.Name))
+		err = save(song)
+	}
+	if err != nil {
+		return Unchanged, err
+	}
+
+	return change, nil
+}
+
+// sameModTime reports whether two modification times are the same to the
+// second. Databases keep fractions of a second to different precisions, mysql
+// to milliseconds and postgres to microseconds, and may round them.
+func sameModTime(a, b time.Time) bool {
+	return a.Sub(b).Abs() < time.Second
+}
+
+// songName derives the title and file type of a song from the name of its file.
+func songName(name string) (title string, fileType string) {
+	// save position of extension
+	base := path.Base(name)
+	extensionsPosition := len(strings.Split(base, ".")) - 1
+
+	// Make sure a . is allowed in the title as long as the extension isn't taken into account.
+	title = strings.Join(strings.Split(base, ".")[:extensionsPosition], ".")
+	fileType = strings.Split(base, ".")[extensionsPosition]
+	return title, fileType
+}
+
+// coverOf stores the cover of the song stored under name and returns its hash,
+// or an empty hash if the song has none.
+func coverOf(store storage.Backend, name string, embedded []byte, covers map[string]string) (string, error) {
+	dir := path.Dir(name)
+	if len(embedded) == 0 {
+		if hash, ok := covers[dir]; ok {
+			return hash, nil
+		}
+	}
+
+	cover, err := findCover(store, name, embedded)
+	if err != nil {
+		return "", err
+	}
+
+	hash := ""
+	if cover != nil {
+		hash, err = storeCover(store, cover)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(embedded) == 0 {
+		covers[dir] = hash
+	}
+	return hash, nil
+}
+
+// moved returns the song of a file with the hash that is gone, nil if there is none.
+func moved(songs SongRepository, store storage.Backend, hash string) (*model.Song, error) {
+	candidates, err := songs.FindHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, song := range candidates {
+		_, err := store.Stat(song.Path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return &song, nil
+		}
+	}
+	return nil, nil
+}
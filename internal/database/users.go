@@ -0,0 +1,39 @@
+package database
+
+import (
+	"errors"
+
+	model "infiniti.com/model"
+)
+
+var (
+	// ErrUserNotFound is returned when no user matches a lookup.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when a user with the same username already exists.
+	ErrUserExists = errors.New("user already exists")
+	// ErrKeyNotFound is returned when no API key matches a lookup.
+	ErrKeyNotFound = errors.New("api key not found")
+)
+
+// UserRepository stores the accounts and their API keys.
+type UserRepository interface {
+	GetUser(id uint) (*model.User, error)
+	// FindUser returns the user with the username, which is lowercase.
+	FindUser(username string) (*model.User, error)
+	// ListUsers returns every user, ordered by their id.
+	ListUsers() ([]model.User, error)
+	// CountUsers returns how many users there are.
+	CountUsers() (int64, error)
+	CreateUser(user *model.User) error
+	UpdateUser(user *model.User) error
+	// DeleteUser deletes the user along with their API keys.
+	DeleteUser(id uint) error
+
+	// APIKeys returns the API keys of the user, ordered by their id.
+	APIKeys(userID uint) ([]model.APIKey, error)
+	// FindAPIKey returns the API key with the hash.
+	FindAPIKey(hash string) (*model.APIKey, error)
+	CreateAPIKey(key *model.APIKey) error
+	// DeleteAPIKey deletes the API key with the id if it belongs to the user.
+	DeleteAPIKey(userID uint, id uint) error
+}
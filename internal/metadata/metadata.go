@@ -0,0 +1,150 @@
+// Package metadata extracts what is known about an audio file: the tags set
+// by whoever ripped it, and the technical details found by scanning its frames.
+package metadata
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dhowden/tag"
+	"github.com/tcolgate/mp3"
+)
+
+// ErrNotAudio is returned for files that have neither tags nor audio frames.
+var ErrNotAudio = errors.New("no tags or audio frames found")
+
+// Metadata describes an audio file. Fields that can't be found are left zero.
+type Metadata struct {
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Genre       string
+	Composer    string
+	Year        int
+	Track       int
+	TrackTotal  int
+	Disc        int
+	DiscTotal   int
+	// Lyrics are the unsynchronised lyrics in the tags.
+	Lyrics string
+
+	Duration time.Duration
+	// Bitrate is the average bitrate in kbps.
+	Bitrate    int
+	SampleRate int
+	Channels   int
+
+	FileSize int64
+	// Hash is the hex encoded SHA-256 of the whole file.
+	Hash string
+
+	// Cover is the picture embedded in the tags, nil if there is none.
+	Cover []byte
+}
+
+// Read extracts the metadata of the file with the extension fileType. Tags are
+// read from any format dhowden/tag supports, while the technical details are only
+// found for MP3 files. Missing tags are fine as long as frames are found instead.
+func Read(file io.ReadSeeker, fileType string) (Metadata, error) {
+	var metadata Metadata
+
+	tags, err := tag.ReadFrom(file)
+	tagged := err == nil
+	if tagged {
+		metadata.Title = tags.Title()
+		metadata.Artist = tags.Artist()
+		metadata.Album = tags.Album()
+		metadata.AlbumArtist = tags.AlbumArtist()
+		metadata.Genre = tags.Genre()
+		metadata.Composer = tags.Composer()
+		metadata.Year = tags.Year()
+		metadata.Track, metadata.TrackTotal = tags.Track()
+		metadata.Disc, metadata.DiscTotal = tags.Disc()
+		metadata.Lyrics = strings.TrimSpace(tags.Lyrics())
+		if picture := tags.Picture(); picture != nil && len(picture.Data) > 0 {
+			metadata.Cover = picture.Data
+		}
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return metadata, err
+	}
+
+	// the whole file is hashed and measured while its frames are scanned
+	hash := sha256.New()
+	content := &countingReader{r: io.TeeReader(file, hash)}
+
+	if strings.EqualFold(fileType, "mp3") {
+		err = scanFrames(content, &metadata)
+		if err != nil {
+			return metadata, err
+		}
+	}
+
+	_, err = io.Copy(io.Discard, content)
+	if err != nil {
+		return metadata, err
+	}
+
+	if !tagged && metadata.Duration == 0 {
+		return metadata, ErrNotAudio
+	}
+
+	metadata.FileSize = content.n
+	metadata.Hash = hex.EncodeToString(hash.Sum(nil))
+	return metadata, nil
+}
+
+// scanFrames adds up the play time of the MPEG audio frames in r and takes the
+// sample rate and channels from the first one. Content without frames is left alone.
+func scanFrames(r io.Reader, metadata *Metadata) error {
+	decoder := mp3.NewDecoder(bufio.NewReader(r))
+	var frame mp3.Frame
+	skipped := 0
+
+	var bits int64
+	for {
+		err := decoder.Decode(&frame, &skipped)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if metadata.Duration == 0 {
+			metadata.SampleRate = int(frame.Header().SampleRate())
+			metadata.Channels = 2
+			if frame.Header().ChannelMode() == mp3.SingleChannel {
+				metadata.Channels = 1
+			}
+		}
+
+		metadata.Duration += frame.Duration()
+		bits += int64(frame.Size()) * 8
+	}
+
+	if metadata.Duration > 0 {
+		metadata.Bitrate = int(float64(bits) / metadata.Duration.Seconds() / 1000)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,107 @@
+package metadata
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const TEST_SONG = "../../resources/test_songs/Recording.mp3"
+
+// id3v23 builds an ID3v2.3 tag of ISO-8859-1 text frames.
+func id3v23(frames map[string]string) []byte {
+	var body []byte
+	for id, text := range frames {
+		body = append(body, id...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(text)+1))
+		body = append(body, 0, 0, 0)
+		body = append(body, text...)
+	}
+
+	size := len(body)
+	header := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	return append(header, body...)
+}
+
+func TestRead(t *testing.T) {
+	song, err := os.ReadFile(TEST_SONG)
+	if err != nil {
+		t.Fatalf("Failed to read test song: %v", err)
+	}
+
+	content := append(id3v23(map[string]string{
+		"TIT2": "Hungarian Dance No. 5",
+		"TPE1": "Johannes Brahms",
+		"TALB": "Hungarian Dances",
+		"TPE2": "Berliner Philharmoniker",
+		"TCON": "Classical",
+		"TCOM": "Johannes Brahms",
+		"TYER": "1869",
+		"TRCK": "5/21",
+		"TPOS": "1/2",
+		// an attached front cover: MIME type, picture type, empty description and the data
+		"APIC": "image/png\x00\x03\x00cover",
+	}), song...)
+
+	metadata, err := Read(bytes.NewReader(content), "mp3")
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+
+	expected := Metadata{
+		Title:       "Hungarian Dance No. 5",
+		Artist:      "Johannes Brahms",
+		Album:       "Hungarian Dances",
+		AlbumArtist: "Berliner Philharmoniker",
+		Genre:       "Classical",
+		Composer:    "Johannes Brahms",
+		Year:        1869,
+		Track:       5,
+		TrackTotal:  21,
+		Disc:        1,
+		DiscTotal:   2,
+		Cover:       []byte("cover"),
+	}
+	tags := metadata
+	tags.Duration, tags.Bitrate, tags.SampleRate, tags.Channels, tags.FileSize, tags.Hash = 0, 0, 0, 0, 0, ""
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected tags\n%+v\ngot\n%+v", expected, tags)
+	}
+
+	if metadata.Duration <= 0 || metadata.Bitrate <= 0 || metadata.SampleRate <= 0 || metadata.Channels <= 0 {
+		t.Errorf("Expected the frames to be scanned; got %+v", metadata)
+	}
+
+	sum := sha256.Sum256(content)
+	if metadata.FileSize != int64(len(content)) || metadata.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the size and hash of the whole file; got %d, %s", metadata.FileSize, metadata.Hash)
+	}
+}
+
+func TestReadWithoutTags(t *testing.T) {
+	song, err := os.ReadFile(TEST_SONG)
+	if err != nil {
+		t.Fatalf("Failed to read test song: %v", err)
+	}
+
+	metadata, err := Read(bytes.NewReader(song), "mp3")
+	if err != nil || metadata.Duration <= 0 || metadata.Title != "" {
+		t.Errorf("Expected only the technical details; got %+v, %v", metadata, err)
+	}
+
+	// frames are only scanned for mp3 files
+	metadata, err = Read(bytes.NewReader(song), "flac")
+	if err != nil || metadata.Duration != 0 {
+		t.Errorf("Expected no technical details for another file type; got %+v, %v", metadata, err)
+	}
+
+	_, err = Read(bytes.NewReader([]byte("not an audio file")), "mp3")
+	if !errors.Is(err, ErrNotAudio) {
+		t.Errorf("Expected ErrNotAudio; got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+// Package search keeps a full-text index of the songs in memory, so they can be
+// found by the words of their title, artist, album, genre and lyrics, ranked by
+// how well they match, whatever database the library is stored in.
+package search
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"infiniti.com/internal/database"
+	model "infiniti.com/model"
+)
+
+// The indexed fields of a song.
+const (
+	TITLE = iota
+	ARTIST
+	ALBUM
+	GENRE
+	LYRICS
+	NFIELDS
+)
+
+// fieldNames names the fields in queries like "artist:queen" and in highlights.
+var fieldNames = [NFIELDS]string{"title", "artist", "album", "genre", "lyrics"}
+
+// fieldWeights tells how much a match in each field counts towards the rank of a song.
+var fieldWeights = [NFIELDS]float64{3, 2, 1.5, 1, 0.5}
+
+// token is a word of a field, lowercased, and where it is in the text of the field.
+type token struct {
+	term  string
+	start int
+	end   int
+}
+
+// document is an indexed song.
+type document struct {
+	song   model.Song
+	texts  [NFIELDS]string
+	tokens [NFIELDS][]token
+}
+
+// Index is a full-text index of the songs. It is kept in sync with the library
+// by listening to the changes made through a database.ObservedRepository.
+type Index struct {
+	docs map[uint]*document
+	// postings holds the songs every term is in
+	postings map[string]map[uint]bool
+	// terms holds the terms of the postings in order, so they can be found by prefix
+	terms []string
+	mu    sync.RWMutex
+}
+
+func NewIndex() *Index {
+	return &Index{docs: make(map[uint]*document), postings: make(map[string]map[uint]bool)}
+}
+
+// Load indexes every song of the repository, replacing what was indexed before.
+func (ix *Index) Load(songs database.SongRepository) error {
+	list, err := songs.List()
+	if err != nil {
+		return err
+	}
+
+	defer ix.mu.Unlock()
+	ix.mu.Lock()
+
+	ix.docs = make(map[uint]*document, len(list))
+	ix.postings = make(map[string]map[uint]bool)
+	for _, song := range list {
+		ix.add(song)
+	}
+
+	// sorted once rather than kept sorted song by song
+	ix.terms = make([]string, 0, len(ix.postings))
+	for term := range ix.postings {
+		ix.terms = append(ix.terms, term)
+	}
+	slices.Sort(ix.terms)
+	return nil
+}
+
+// Len returns the number of indexed songs.
+func (ix *Index) Len() int {
+	defer ix.mu.RUnlock()
+	ix.mu.RLock()
+
+	return len(ix.docs)
+}
+
+// SongSaved indexes the song again, as it was created or updated.
+func (ix *Index) SongSaved(song model.Song) {
+	defer ix.mu.Unlock()
+	ix.mu.Lock()
+
+	ix.remove(song.ID)
+	for _, term := range ix.add(song) {
+		i, _ := slices.BinarySearch(ix.terms, term)
+		ix.terms = slices.Insert(ix.terms, i, term)
+	}
+}
+
+// SongDeleted removes the song from the index.
+func (ix *Index) SongDeleted(song model.Song) {
+	defer ix.mu.Unlock()
+	ix.mu.Lock()
+
+	ix.remove(song.ID)
+}
+
+// add indexes the song, returning the terms that weren't in the index before.
+func (ix *Index) add(song model.Song) []string {
+	doc := &document{song: song}
+	doc.texts = [NFIELDS]string{song.Title, song.Artist, song.Album, song.Genre, song.Lyrics}
+
+	var added []string
+	for field, text := range doc.texts {
+		doc.tokens[field] = tokenize(text)
+		for _, t := range doc.tokens[field] {
+			if ix.postings[t.term] == nil {
+				ix.postings[t.term] = make(map[uint]bool)
+				added = append(added, t.term)
+			}
+			ix.postings[t.term][song.ID] = true
+		}
+	}
+	ix.docs[song.ID] = doc
+	return added
+}
+
+// remove takes the song out of the index, along with the terms no other song has.
+func (ix *Index) remove(id uint) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+
+	for _, tokens := range doc.tokens {
+		for _, t := range tokens {
+			songs, ok := ix.postings[t.term]
+			if !ok {
+				continue
+			}
+			delete(songs, id)
+			if len(songs) == 0 {
+				delete(ix.postings, t.term)
+				if i, found := slices.BinarySearch(ix.terms, t.term); found {
+					ix.terms = slices.Delete(ix.terms, i, i+1)
+				}
+			}
+		}
+	}
+	delete(ix.docs, id)
+}
+
+// tokenize splits the text into its words: runs of letters and digits,
+// lowercased. Apostrophes within a word are dropped, so "Don't" is "dont".
+func tokenize(text string) []token {
+	var tokens []token
+	var term strings.Builder
+	start := -1
+
+	for i, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+			term.WriteRune(unicode.ToLower(r))
+			continue
+		case start >= 0 && (r == '\'' || r == '’'):
+			next, _ := utf8.DecodeRuneInString(text[i+utf8.RuneLen(r):])
+			if unicode.IsLetter(next) {
+				continue
+			}
+		}
+
+		if start >= 0 {
+			tokens = append(tokens, token{term: term.String(), start: start, end: i})
+			term.Reset()
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: term.String(), start: start, end: len(text)})
+	}
+	return tokens
+}
@@ -0,0 +1,357 @@
+package search
+
+import (
+	"cmp"
+	"html"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	model "infiniti.com/model"
+)
+
+// PREFIXSCORE is how well a word matches the terms it is the start of, relative to the term itself.
+const PREFIXSCORE = 0.7
+
+// TYPOSCORES is how well a word matches the terms it is one or two typos away from.
+var TYPOSCORES = [3]float64{1, 0.5, 0.3}
+
+// SNIPPETCONTEXT is how much of the lyrics is shown around a match in their highlight, in bytes.
+const SNIPPETCONTEXT = 40
+
+// Result is a song found by a search.
+type Result struct {
+	Song  model.Song `json:"song"`
+	Score float64    `json:"score"`
+	// Highlights holds the text of every field that matched, HTML escaped, with
+	// the matches in <mark> tags. The lyrics are cut down to the first match.
+	Highlights map[string]string `json:"highlights"`
+}
+
+// clause is a part of a query every song found must match: a word, or the words of a phrase in a row.
+type clause struct {
+	words []string
+	// phrase is set for words that must match as they are
+	phrase bool
+	// field is the only field it may match, -1 for any of them
+	field int
+}
+
+// hit is where a clause matched a song.
+type hit struct {
+	field int
+	start int
+	end   int
+}
+
+// Search returns the songs matching every part of the query, best first, and
+// the count of all songs that match. It returns at most limit songs, all of
+// them if limit is 0, skipping offset songs.
+//
+// The words of a query match words of the title, artist, album, genre and
+// lyrics of a song, as well as the words they start and those a typo or two
+// away for longer words. Words in quotes match as a phrase, and a field name
+// in front of a word or phrase, as in artist:queen or title:"we will", limits
+// it to that field. Matches in the title count most, those in the lyrics least.
+func (ix *Index) Search(query string, offset int, limit int) ([]Result, int) {
+	clauses := parse(query)
+	if len(clauses) == 0 {
+		return []Result{}, 0
+	}
+
+	defer ix.mu.RUnlock()
+	ix.mu.RLock()
+
+	// the terms every clause matches, and the songs that have them
+	matches := make([]map[string]float64, len(clauses))
+	candidates := make([]map[uint]bool, len(clauses))
+	for i, c := range clauses {
+		matches[i], candidates[i] = ix.candidates(c)
+	}
+	slices.SortFunc(candidates, func(a, b map[uint]bool) int { return cmp.Compare(len(a), len(b)) })
+
+	results := []Result{}
+	for id := range candidates[0] {
+		if !slices.ContainsFunc(candidates[1:], func(songs map[uint]bool) bool { return !songs[id] }) {
+			if result, ok := ix.match(ix.docs[id], clauses, matches); ok {
+				results = append(results, result)
+			}
+		}
+	}
+
+	slices.SortFunc(results, func(a, b Result) int {
+		if order := cmp.Compare(b.Score, a.Score); order != 0 {
+			return order
+		}
+		if order := strings.Compare(strings.ToLower(a.Song.Title), strings.ToLower(b.Song.Title)); order != 0 {
+			return order
+		}
+		return cmp.Compare(a.Song.ID, b.Song.ID)
+	})
+
+	total := len(results)
+	results = results[min(max(offset, 0), total):]
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, total
+}
+
+// candidates returns the terms the clause matches, with how well, and the songs that may match it.
+func (ix *Index) candidates(c clause) (map[string]float64, map[uint]bool) {
+	terms := make(map[string]float64)
+	songs := make(map[uint]bool)
+
+	if c.phrase {
+		// the words of a phrase are matched as they are, and the songs must have all of them
+		for i, word := range c.words {
+			terms[word] = 1
+			next := make(map[uint]bool)
+			for id := range ix.postings[word] {
+				if i == 0 || songs[id] {
+					next[id] = true
+				}
+			}
+			songs = next
+		}
+		return terms, songs
+	}
+
+	terms = ix.expand(c.words[0])
+	for term := range terms {
+		for id := range ix.postings[term] {
+			songs[id] = true
+		}
+	}
+	return terms, songs
+}
+
+// expand returns the terms of the index the word matches, with how well: 1 for
+// the word itself and less for the words it is the start of or a typo of.
+func (ix *Index) expand(word string) map[string]float64 {
+	terms := make(map[string]float64)
+	if ix.postings[word] != nil {
+		terms[word] = 1
+	}
+
+	runes := []rune(word)
+	if len(runes) >= 2 {
+		for i := sort.SearchStrings(ix.terms, word); i < len(ix.terms) && strings.HasPrefix(ix.terms[i], word); i++ {
+			if ix.terms[i] != word {
+				terms[ix.terms[i]] = PREFIXSCORE
+			}
+		}
+	}
+
+	typos := maxTypos(len(runes))
+	if typos == 0 {
+		return terms
+	}
+	for _, term := range ix.terms {
+		// terms that are much longer or shorter can't be close
+		if len(term) < len(word)-typos*2 || len(term) > len(word)+typos*2 || term == word {
+			continue
+		}
+		d := distance(runes, []rune(term), typos)
+		if d <= typos && TYPOSCORES[d] > terms[term] {
+			terms[term] = TYPOSCORES[d]
+		}
+	}
+	return terms
+}
+
+// match scores the song against every clause, with the matching terms of each.
+// It fails if a clause doesn't match, as in a phrase whose words aren't in a row.
+func (ix *Index) match(doc *document, clauses []clause, matches []map[string]float64) (Result, bool) {
+	result := Result{Song: doc.song}
+	var hits []hit
+
+	for i, c := range clauses {
+		best, count := 0.0, 0
+		for field := 0; field < NFIELDS; field++ {
+			if c.field >= 0 && c.field != field {
+				continue
+			}
+
+			tokens := doc.tokens[field]
+			n := len(c.words)
+			for start := 0; start+n <= len(tokens); start++ {
+				score := fieldWeights[field]
+				if c.phrase {
+					if !slices.EqualFunc(tokens[start:start+n], c.words, func(t token, word string) bool { return t.term == word }) {
+						continue
+					}
+					score *= float64(n)
+				} else {
+					quality, ok := matches[i][tokens[start].term]
+					if !ok {
+						continue
+					}
+					score *= quality
+				}
+				// the whole field matching counts for more, like the name of an artist
+				if n == len(tokens) {
+					score *= 1.5
+				}
+
+				best = max(best, score)
+				count++
+				hits = append(hits, hit{field: field, start: tokens[start].start, end: tokens[start+n-1].end})
+			}
+		}
+		if count == 0 {
+			return result, false
+		}
+
+		// matching more often counts a little, but not enough to outrank a better field
+		result.Score += best * (1 + 0.05*float64(min(count-1, 10)))
+	}
+
+	result.Score = math.Round(result.Score*1000) / 1000
+	result.Highlights = highlights(doc, hits)
+	return result, true
+}
+
+// parse splits the query into its clauses.
+func parse(query string) []clause {
+	var clauses []clause
+	rest := query
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			return clauses
+		}
+
+		field := -1
+		if name, after, ok := strings.Cut(rest, ":"); ok && !strings.ContainsFunc(name, unicode.IsSpace) {
+			if i := slices.Index(fieldNames[:], strings.ToLower(name)); i >= 0 {
+				field, rest = i, after
+			}
+		}
+
+		var text string
+		quoted := strings.HasPrefix(rest, `"`)
+		if quoted {
+			text, rest, _ = strings.Cut(rest[1:], `"`)
+		} else if i := strings.IndexFunc(rest, unicode.IsSpace); i >= 0 {
+			text, rest = rest[:i], rest[i:]
+		} else {
+			text, rest = rest, ""
+		}
+
+		var words []string
+		for _, t := range tokenize(text) {
+			words = append(words, t.term)
+		}
+		// words in quotes match as they are, and text like "AC/DC" is a phrase as well
+		if len(words) > 0 {
+			clauses = append(clauses, clause{words: words, phrase: quoted || len(words) > 1, field: field})
+		}
+	}
+}
+
+// maxTypos returns how many typos a word of length letters may have: none for short words, more for long ones.
+func maxTypos(length int) int {
+	switch {
+	case length < 4:
+		return 0
+	case length < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// distance returns the edit distance between a and b, with a swap of two
+// letters next to each other counting as one edit, or limit+1 once it is
+// certain to be more than limit.
+func distance(a []rune, b []rune, limit int) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	next := make([]int, len(b)+1)
+	for j := range current {
+		current[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		previous, current, next = current, next, previous
+		current[0] = i
+		lowest := i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = min(current[j], next[j-2]+1)
+			}
+			lowest = min(lowest, current[j])
+		}
+		if lowest > limit {
+			return limit + 1
+		}
+	}
+	return min(current[len(b)], limit+1)
+}
+
+// highlights returns the text of every field with a hit, the matches marked.
+func highlights(doc *document, hits []hit) map[string]string {
+	byField := make(map[int][]hit)
+	for _, h := range hits {
+		byField[h.field] = append(byField[h.field], h)
+	}
+
+	marked := make(map[string]string, len(byField))
+	for field, hits := range byField {
+		slices.SortFunc(hits, func(a, b hit) int { return cmp.Compare(a.start, b.start) })
+		text, from, to := doc.texts[field], 0, len(doc.texts[field])
+		if field == LYRICS {
+			from, to = snippet(text, hits[0])
+		}
+
+		var b strings.Builder
+		if from > 0 {
+			b.WriteString("…")
+		}
+		at := from
+		for _, h := range hits {
+			if h.start < at || h.end > to {
+				continue
+			}
+			b.WriteString(html.EscapeString(text[at:h.start]))
+			b.WriteString("<mark>" + html.EscapeString(text[h.start:h.end]) + "</mark>")
+			at = h.end
+		}
+		b.WriteString(html.EscapeString(text[at:to]))
+		if to < len(text) {
+			b.WriteString("…")
+		}
+
+		// the lines of lyrics read as one
+		marked[fieldNames[field]] = strings.Join(strings.Fields(b.String()), " ")
+	}
+	return marked
+}
+
+// snippet returns where the part of the text around the hit starts and ends, at whole words.
+func snippet(text string, h hit) (int, int) {
+	from, to := max(h.start-SNIPPETCONTEXT, 0), min(h.end+SNIPPETCONTEXT*2, len(text))
+	if from > 0 {
+		if i := strings.IndexFunc(text[from:h.start], unicode.IsSpace); i >= 0 {
+			from += i + 1
+		} else {
+			from = h.start
+		}
+	}
+	if to < len(text) {
+		if i := strings.LastIndexFunc(text[h.end:to], unicode.IsSpace); i >= 0 {
+			to = h.end + i
+		} else {
+			to = h.end
+		}
+	}
+	return from, to
+}
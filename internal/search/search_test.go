@@ -0,0 +1,170 @@
+package search
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"infiniti.com/internal/database"
+	model "infiniti.com/model"
+)
+
+// createIndex returns an index kept in sync with a repository holding a few songs.
+func createIndex(t *testing.T) (*Index, *database.ObservedRepository) {
+	songs := database.Observe(database.NewMemorySongRepository())
+	index := NewIndex()
+	songs.Listen(index)
+
+	library := []model.Song{
+		{Title: "Bohemian Rhapsody", Artist: "Queen", Album: "A Night at the Opera", Genre: "Rock", Lyrics: "Is this the real life?\nIs this just fantasy?"},
+		{Title: "We Will Rock You", Artist: "Queen", Album: "News of the World", Genre: "Rock"},
+		{Title: "Dancing Queen", Artist: "ABBA", Album: "Arrival", Genre: "Pop"},
+		{Title: "Don't Stop Me Now", Artist: "Queen", Album: "Jazz", Genre: "Rock", Lyrics: "Tonight I'm gonna have myself a real good time"},
+		{Title: "Real Love", Artist: "The Beatles", Genre: "Rock & Roll"},
+	}
+	for i, song := range library {
+		song.FileType = "mp3"
+		song.Path = fmt.Sprintf("%d.mp3", i)
+		err := songs.Create(&song)
+		if err != nil {
+			t.Fatalf("Failed to create %q: %v", song.Title, err)
+		}
+	}
+	return index, songs
+}
+
+func titles(results []Result) []string {
+	titles := []string{}
+	for _, result := range results {
+		titles = append(titles, result.Song.Title)
+	}
+	return titles
+}
+
+func TestSearch(t *testing.T) {
+	index, _ := createIndex(t)
+
+	tests := map[string][]string{
+		// being all of the artist counts as much as being a word of the title
+		"queen":          {"Bohemian Rhapsody", "Dancing Queen", "Don't Stop Me Now", "We Will Rock You"},
+		"artist:queen":   {"Bohemian Rhapsody", "Don't Stop Me Now", "We Will Rock You"},
+		"title:queen":    {"Dancing Queen"},
+		"boh":            {"Bohemian Rhapsody"},
+		"rhapsodie":      {"Bohemian Rhapsody"},
+		"bohemain":       {"Bohemian Rhapsody"},
+		"dont stop":      {"Don't Stop Me Now"},
+		`"rock you"`:     {"We Will Rock You"},
+		`"you rock"`:     {},
+		"album:jazz":     {"Don't Stop Me Now"},
+		"fantasy":        {"Bohemian Rhapsody"},
+		"real":           {"Real Love", "Bohemian Rhapsody", "Don't Stop Me Now"},
+		"queen real":     {"Bohemian Rhapsody", "Don't Stop Me Now"},
+		"rock&roll":      {"Real Love"},
+		"genre:pop abba": {"Dancing Queen"},
+		"nothing":        {},
+		"":               {},
+		"x":              {},
+	}
+
+	for query, expected := range tests {
+		results, total := index.Search(query, 0, 0)
+		if found := titles(results); !slices.Equal(found, expected) {
+			t.Errorf("Expected %v for %q; got %v", expected, query, found)
+		}
+		if total != len(expected) {
+			t.Errorf("Expected a total of %d for %q; got %d", len(expected), query, total)
+		}
+	}
+
+	results, total := index.Search("artist:queen", 1, 1)
+	if found := titles(results); total != 3 || !slices.Equal(found, []string{"Don't Stop Me Now"}) {
+		t.Errorf("Expected the second of 3 songs; got %v of %d", found, total)
+	}
+}
+
+func TestSearchHighlights(t *testing.T) {
+	index, _ := createIndex(t)
+
+	results, _ := index.Search(`queen "real life"`, 0, 0)
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 song; got %v", titles(results))
+	}
+
+	expected := map[string]string{
+		"artist": "<mark>Queen</mark>",
+		"lyrics": "Is this the <mark>real life</mark>? Is this just fantasy?",
+	}
+	for field, text := range expected {
+		if results[0].Highlights[field] != text {
+			t.Errorf("Expected the %s highlight %q; got %q", field, text, results[0].Highlights[field])
+		}
+	}
+	if _, ok := results[0].Highlights["title"]; ok {
+		t.Errorf("Expected no title highlight; got %v", results[0].Highlights)
+	}
+
+	results, _ = index.Search("rock&roll", 0, 0)
+	if len(results) != 1 || results[0].Highlights["genre"] != "<mark>Rock &amp; Roll</mark>" {
+		t.Errorf("Expected the escaped genre to be highlighted; got %+v", results)
+	}
+}
+
+func TestSearchSync(t *testing.T) {
+	index, songs := createIndex(t)
+
+	song, _ := songs.Find("Real Love")
+	song.Title = "Free as a Bird"
+	err := songs.Update(song)
+	if err != nil {
+		t.Fatalf("Failed to update song: %v", err)
+	}
+	if results, _ := index.Search("bird", 0, 0); len(results) != 1 || results[0].Song.ID != song.ID {
+		t.Errorf("Expected the updated song to be found by its new title; got %v", titles(results))
+	}
+	if results, _ := index.Search("love", 0, 0); len(results) != 0 {
+		t.Errorf("Expected the old title to be gone; got %v", titles(results))
+	}
+
+	err = songs.Delete(song.ID)
+	if err != nil {
+		t.Fatalf("Failed to delete song: %v", err)
+	}
+	if results, _ := index.Search("beatles", 0, 0); len(results) != 0 {
+		t.Errorf("Expected the deleted song to be gone; got %v", titles(results))
+	}
+	// terms only the deleted song had can't be matched by prefix any more
+	if slices.Contains(index.terms, "bird") {
+		t.Errorf("Expected the terms of the deleted song to be gone")
+	}
+
+	reloaded := NewIndex()
+	err = reloaded.Load(songs)
+	if err != nil {
+		t.Fatalf("Failed to load the index: %v", err)
+	}
+	if reloaded.Len() != 4 || !slices.Equal(reloaded.terms, index.terms) {
+		t.Errorf("Expected loading to index the same as syncing; got %d songs", reloaded.Len())
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"queen", "queen", 0},
+		{"queen", "quen", 1},
+		{"queen", "qeuen", 1},
+		{"queen", "quuen", 1},
+		{"queen", "kween", 2},
+		{"queen", "bohemian", 3},
+		{"", "ab", 2},
+		{"ça", "ca", 1},
+	}
+
+	for _, test := range tests {
+		if d := distance([]rune(test.a), []rune(test.b), 2); d != test.expected {
+			t.Errorf("Expected a distance of %d from %q to %q; got %d", test.expected, test.a, test.b, d)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"infiniti.com/config"
+)
+
+// File is an opened file in a backend. Besides sequential reads it supports
+// range reads through Seek and ReadAt.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+type FileInfo struct {
+	// Name is the slash separated path of the file relative to the backend's root.
+	Name    string
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+// Backend stores the files of the music library. Names are always slash
+// separated and relative to the root of the backend. Missing files are
+// reported with an error wrapping fs.ErrNotExist.
+type Backend interface {
+	Open(name string) (File, error)
+	Stat(name string) (FileInfo, error)
+	// Put stores the contents of r under name, replacing any existing file.
+	Put(name string, r io.Reader) error
+	Delete(name string) error
+	// List returns every file below prefix, including those in nested directories.
+	List(prefix string) ([]FileInfo, error)
+}
+
+// New creates the backend selected in the configuration.
+func New(cfg *config.Config) (Backend, error) {
+	switch cfg.Storage.Driver {
+	case "local":
+		return NewLocal(cfg.SongsDir)
+	case "s3":
+		return NewS3(cfg.Storage.S3, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver '%s'", cfg.Storage.Driver)
+	}
+}
+
+// cleanName validates a file name and returns it in its canonical form.
+func cleanName(name string) (string, error) {
+	cleaned := path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))[1:]
+	if cleaned == "" || cleaned != strings.TrimPrefix(name, "./") {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,292 @@
+// Package suggest suggests songs, artists and albums while their name is being
+// typed. Every name is kept in memory under each of its words onwards, in
+// order, so the names starting like what was typed are found by a binary
+// search instead of a scan of the library.
+package suggest
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"infiniti.com/internal/database"
+	model "infiniti.com/model"
+)
+
+// The types of suggestions, in the order they are ranked in when they match equally well.
+const (
+	ARTIST = "artist"
+	ALBUM  = "album"
+	SONG   = "song"
+)
+
+var typeOrder = map[string]int{ARTIST: 0, ALBUM: 1, SONG: 2}
+
+// Suggestion is a song, artist or album whose name starts like the query, or
+// has a word that does.
+type Suggestion struct {
+	Type string `json:"type"`
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	// Artist is the artist of a song or album.
+	Artist string `json:"artist,omitempty"`
+	// Slug is the slug of a song.
+	Slug string `json:"slug,omitempty"`
+	// Songs is how many songs an artist or album has.
+	Songs int `json:"songs,omitempty"`
+}
+
+type ref struct {
+	kind string
+	id   uint
+}
+
+// entry is a suggestion along with the count of songs that brought it in.
+type entry struct {
+	Suggestion
+	count int
+}
+
+// key is a name from one of its words onwards, normalized like the queries.
+type key struct {
+	text  string
+	entry *entry
+	// whole is set for the key that is the whole name
+	whole bool
+}
+
+// Suggester keeps the names of the songs, artists and albums to suggest. It
+// is kept in sync with the library by listening to the changes made through a
+// database.ObservedRepository.
+type Suggester struct {
+	entries map[ref]*entry
+	// songs holds the songs as they were added, so they can be taken out again
+	songs map[uint]model.Song
+	// keys is ordered by text
+	keys []key
+	mu   sync.RWMutex
+}
+
+func New() *Suggester {
+	return &Suggester{entries: make(map[ref]*entry), songs: make(map[uint]model.Song)}
+}
+
+// Load adds every song of the repository, along with its artist and album,
+// replacing what was added before.
+func (s *Suggester) Load(songs database.SongRepository) error {
+	list, err := songs.List()
+	if err != nil {
+		return err
+	}
+
+	defer s.mu.Unlock()
+	s.mu.Lock()
+
+	s.load(list)
+	return nil
+}
+
+// SongSaved adds the song again, as it was created or updated.
+func (s *Suggester) SongSaved(song model.Song) {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+
+	s.remove(song.ID)
+	s.add(song, true)
+}
+
+// SongDeleted takes the song out, along with its artist and album if no other song has them.
+func (s *Suggester) SongDeleted(song model.Song) {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+
+	s.remove(song.ID)
+}
+
+// Suggest returns at most limit songs, artists and albums whose name starts
+// like the query, or has a word that does, best first. Whole names starting
+// like it come first, then names with more songs.
+func (s *Suggester) Suggest(query string, limit int) []Suggestion {
+	prefix := normalize(query)
+	if prefix == "" || limit <= 0 {
+		return []Suggestion{}
+	}
+
+	defer s.mu.RUnlock()
+	s.mu.RLock()
+
+	// the best keys so far, kept in order, with one key for every entry
+	best := make([]key, 0, limit+1)
+	i, _ := slices.BinarySearchFunc(s.keys, prefix, compareKey)
+	for ; i < len(s.keys) && strings.HasPrefix(s.keys[i].text, prefix); i++ {
+		k := s.keys[i]
+		if len(best) == limit && better(best[limit-1], k, prefix) {
+			continue
+		}
+
+		if j := slices.IndexFunc(best, func(b key) bool { return b.entry == k.entry }); j >= 0 {
+			if better(best[j], k, prefix) {
+				continue
+			}
+			best = slices.Delete(best, j, j+1)
+		}
+		at, _ := slices.BinarySearchFunc(best, k, func(b key, k key) int {
+			if better(b, k, prefix) {
+				return -1
+			}
+			return 1
+		})
+		best = slices.Insert(best, at, k)
+		if len(best) > limit {
+			best = best[:limit]
+		}
+	}
+
+	suggestions := make([]Suggestion, len(best))
+	for i, k := range best {
+		suggestions[i] = k.entry.Suggestion
+	}
+	return suggestions
+}
+
+// load adds the songs, sorting the keys once at the end.
+func (s *Suggester) load(songs []model.Song) {
+	s.entries = make(map[ref]*entry)
+	s.songs = make(map[uint]model.Song, len(songs))
+	s.keys = nil
+	for _, song := range songs {
+		s.add(song, false)
+	}
+	slices.SortFunc(s.keys, func(a, b key) int { return strings.Compare(a.text, b.text) })
+}
+
+// add adds the song with its artist and album, keeping the keys sorted if sorted is set.
+func (s *Suggester) add(song model.Song, sorted bool) {
+	s.songs[song.ID] = song
+
+	artist, album, albumArtist := database.Credits(song)
+	s.use(Suggestion{Type: SONG, ID: song.ID, Name: song.Title, Artist: artist, Slug: song.Slug}, sorted)
+	if song.ArtistID != 0 {
+		s.use(Suggestion{Type: ARTIST, ID: song.ArtistID, Name: artist}, sorted)
+	}
+	if song.AlbumID != 0 {
+		s.use(Suggestion{Type: ALBUM, ID: song.AlbumID, Name: album, Artist: albumArtist}, sorted)
+	}
+}
+
+// remove takes out the song, and its artist and album if no other song has them.
+func (s *Suggester) remove(id uint) {
+	song, ok := s.songs[id]
+	if !ok {
+		return
+	}
+	delete(s.songs, id)
+
+	s.release(ref{SONG, song.ID})
+	s.release(ref{ARTIST, song.ArtistID})
+	s.release(ref{ALBUM, song.AlbumID})
+}
+
+// use counts another song for the suggestion, adding it if it is new.
+func (s *Suggester) use(suggestion Suggestion, sorted bool) {
+	r := ref{suggestion.Type, suggestion.ID}
+	e, ok := s.entries[r]
+	if !ok {
+		e = &entry{Suggestion: suggestion}
+		s.entries[r] = e
+
+		for _, k := range keys(e) {
+			if !sorted {
+				s.keys = append(s.keys, k)
+				continue
+			}
+			i, _ := slices.BinarySearchFunc(s.keys, k.text, compareKey)
+			s.keys = slices.Insert(s.keys, i, k)
+		}
+	}
+
+	e.count++
+	if e.Type != SONG {
+		e.Songs = e.count
+	}
+}
+
+// release counts one song less for the suggestion, taking it out once none are left.
+func (s *Suggester) release(r ref) {
+	e, ok := s.entries[r]
+	if !ok {
+		return
+	}
+
+	e.count--
+	if e.Type != SONG {
+		e.Songs = e.count
+	}
+	if e.count > 0 {
+		return
+	}
+
+	delete(s.entries, r)
+	for _, k := range keys(e) {
+		i, _ := slices.BinarySearchFunc(s.keys, k.text, compareKey)
+		for ; i < len(s.keys) && s.keys[i].text == k.text; i++ {
+			if s.keys[i].entry == e {
+				s.keys = slices.Delete(s.keys, i, i+1)
+				break
+			}
+		}
+	}
+}
+
+// keys returns the keys of the entry: its name from each of its words onwards.
+func keys(e *entry) []key {
+	words := strings.Fields(normalize(e.Name))
+	keys := make([]key, len(words))
+	for i := range words {
+		keys[i] = key{text: strings.Join(words[i:], " "), entry: e, whole: i == 0}
+	}
+	return keys
+}
+
+func compareKey(k key, text string) int {
+	return strings.Compare(k.text, text)
+}
+
+// better reports whether the key a makes a better suggestion for the prefix than b:
+// a whole name before a later word, a complete word before a started one, more
+// songs before fewer, artists before albums before songs, and short names first.
+func better(a key, b key, prefix string) bool {
+	if a.whole != b.whole {
+		return a.whole
+	}
+	aComplete, bComplete := complete(a.text, prefix), complete(b.text, prefix)
+	if aComplete != bComplete {
+		return aComplete
+	}
+
+	order := cmp.Or(
+		cmp.Compare(b.entry.count, a.entry.count),
+		cmp.Compare(typeOrder[a.entry.Type], typeOrder[b.entry.Type]),
+		cmp.Compare(len(a.entry.Name), len(b.entry.Name)),
+		strings.Compare(a.entry.Name, b.entry.Name),
+		cmp.Compare(a.entry.ID, b.entry.ID),
+	)
+	return order < 0
+}
+
+// complete reports whether the prefix ends at the end of a word of the text.
+func complete(text string, prefix string) bool {
+	return len(text) == len(prefix) || text[len(prefix)] == ' '
+}
+
+// normalize lowercases the words of the text and puts a single space between
+// them, dropping everything else, so "Don't  Stop!" is "dont stop".
+func normalize(text string) string {
+	text = strings.NewReplacer("'", "", "’", "").Replace(text)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,165 @@
+package suggest
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"infiniti.com/internal/database"
+	model "infiniti.com/model"
+)
+
+// createSuggester returns a suggester kept in sync with a repository holding a few songs.
+func createSuggester(t *testing.T) (*Suggester, *database.ObservedRepository) {
+	songs := database.Observe(database.NewMemorySongRepository())
+	s := New()
+	songs.Listen(s)
+
+	library := []model.Song{
+		{Title: "Bohemian Rhapsody", Artist: "Queen", Album: "A Night at the Opera"},
+		{Title: "Love of My Life", Artist: "Queen", Album: "A Night at the Opera"},
+		{Title: "Queen of the Night", Artist: "Mozart"},
+		{Title: "Dancing Queen", Artist: "ABBA feat. Someone", Album: "Arrival"},
+		{Title: "Don't Stop Me Now", Artist: "Queen", Album: "Jazz"},
+	}
+	for i, song := range library {
+		song.FileType = "mp3"
+		song.Path = fmt.Sprintf("%d.mp3", i)
+		err := songs.Create(&song)
+		if err != nil {
+			t.Fatalf("Failed to create %q: %v", song.Title, err)
+		}
+	}
+	return s, songs
+}
+
+func names(suggestions []Suggestion) []string {
+	names := []string{}
+	for _, suggestion := range suggestions {
+		names = append(names, suggestion.Type+":"+suggestion.Name)
+	}
+	return names
+}
+
+func TestSuggest(t *testing.T) {
+	s, _ := createSuggester(t)
+
+	tests := map[string][]string{
+		"que":     {"artist:Queen", "song:Queen of the Night", "song:Dancing Queen"},
+		"QUEEN ":  {"artist:Queen", "song:Queen of the Night", "song:Dancing Queen"},
+		"night":   {"album:A Night at the Opera", "song:Queen of the Night"},
+		"a":       {"album:A Night at the Opera", "artist:ABBA", "album:Arrival"},
+		"dont st": {"song:Don't Stop Me Now"},
+		"don't":   {"song:Don't Stop Me Now"},
+		"queen o": {"song:Queen of the Night"},
+		"opera":   {"album:A Night at the Opera"},
+		"x":       {},
+		"!":       {},
+	}
+
+	for query, expected := range tests {
+		if found := names(s.Suggest(query, 3)); !slices.Equal(found, expected) {
+			t.Errorf("Expected %v for %q; got %v", expected, query, found)
+		}
+	}
+
+	queen := s.Suggest("queen", 1)[0]
+	if queen.Songs != 3 {
+		t.Errorf("Expected Queen to have 3 songs; got %d", queen.Songs)
+	}
+	song := s.Suggest("bohemian", 1)[0]
+	if song.Artist != "Queen" || song.Slug != "bohemian-rhapsody" || song.Songs != 0 {
+		t.Errorf("Expected the song with its artist and slug; got %+v", song)
+	}
+}
+
+func TestSuggestSync(t *testing.T) {
+	s, songs := createSuggester(t)
+
+	song, _ := songs.Find("Dancing Queen")
+	song.Title, song.Artist = "Waterloo", "ABBA"
+	err := songs.Update(song)
+	if err != nil {
+		t.Fatalf("Failed to update song: %v", err)
+	}
+	if found := names(s.Suggest("wat", 5)); !slices.Equal(found, []string{"song:Waterloo"}) {
+		t.Errorf("Expected the new title; got %v", found)
+	}
+	if found := names(s.Suggest("dancing", 5)); len(found) != 0 {
+		t.Errorf("Expected the old title to be gone; got %v", found)
+	}
+
+	err = songs.Delete(song.ID)
+	if err != nil {
+		t.Fatalf("Failed to delete song: %v", err)
+	}
+	// the artist and album go with their last song
+	if found := names(s.Suggest("a", 5)); !slices.Equal(found, []string{"album:A Night at the Opera"}) {
+		t.Errorf("Expected ABBA and Arrival to be gone; got %v", found)
+	}
+
+	loaded := New()
+	err = loaded.Load(songs)
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if len(loaded.keys) != len(s.keys) || len(loaded.entries) != len(s.entries) {
+		t.Errorf("Expected loading to add the same as syncing; got %d keys instead of %d", len(loaded.keys), len(s.keys))
+	}
+	for i := range loaded.keys {
+		if loaded.keys[i].text != s.keys[i].text {
+			t.Fatalf("Expected the same keys in the same order; got %q for %q", loaded.keys[i].text, s.keys[i].text)
+		}
+	}
+}
+
+// largeLibrary returns 100,000 songs by 5,000 artists on 10,000 albums.
+func largeLibrary() []model.Song {
+	words := []string{"love", "night", "queen", "dance", "rock", "blue", "moon", "heart", "fire", "road", "dream", "summer"}
+	songs := make([]model.Song, 100_000)
+	for i := range songs {
+		songs[i] = model.Song{
+			ID:       uint(i + 1),
+			Title:    fmt.Sprintf("%s %s %d", words[i%len(words)], words[i/len(words)%len(words)], i),
+			Artist:   fmt.Sprintf("%s artist %d", words[i%7], i%5000),
+			ArtistID: uint(i%5000 + 1),
+			Album:    fmt.Sprintf("%s album %d", words[i%5], i%10000),
+			AlbumID:  uint(i%10000 + 1),
+		}
+	}
+	return songs
+}
+
+func TestSuggestLatency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the large library in short mode")
+	}
+
+	s := New()
+	s.load(largeLibrary())
+
+	for _, query := range []string{"l", "lo", "love n", "queen artist 12", "artist", "zzz"} {
+		start := time.Now()
+		s.Suggest(query, 10)
+		// generous, so slow machines and the race detector pass too, BenchmarkSuggest measures the budget of 20ms
+		if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+			t.Errorf("Expected suggestions for %q within 200ms; took %v", query, elapsed)
+		}
+	}
+
+	s.SongSaved(model.Song{ID: 100_001, Title: "Brand New", ArtistID: 1, Artist: "love artist 0"})
+	if found := names(s.Suggest("brand", 10)); !slices.Equal(found, []string{"song:Brand New"}) {
+		t.Errorf("Expected the new song; got %v", found)
+	}
+}
+
+func BenchmarkSuggest(b *testing.B) {
+	s := New()
+	s.load(largeLibrary())
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Suggest("l", 10)
+	}
+}
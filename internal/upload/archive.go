@@ -0,0 +1,273 @@
+package upload
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"infiniti.com/internal/database"
+	model "infiniti.com/model"
+)
+
+// The statuses of the files of an upload.
+const (
+	CREATED   = "created"
+	DUPLICATE = "duplicate"
+	REJECTED  = "rejected"
+)
+
+// ErrNotCover is returned for cover files in an archive that aren't images.
+var ErrNotCover = errors.New("cover file is not an image")
+
+// Result tells what became of one of the uploaded files, or of a file in an
+// uploaded archive, named after the archive as in "Album.zip/01 Song.mp3".
+type Result struct {
+	File   string      `json:"file"`
+	Status string      `json:"status"`
+	Reason string      `json:"reason,omitempty"`
+	Song   *model.Song `json:"song,omitempty"`
+	// Err is why the file wasn't created
+	Err error `json:"-"`
+}
+
+func newResult(file string, song *model.Song, err error) Result {
+	result := Result{File: file, Status: CREATED, Song: song, Err: err}
+	switch {
+	case errors.Is(err, ErrDuplicate):
+		result.Status = DUPLICATE
+	case err != nil:
+		result.Status = REJECTED
+		result.Reason = err.Error()
+		result.Song = nil
+	}
+	return result
+}
+
+// Unpack stores the uploaded file named filename like Store does, unless it is
+// a ZIP or TAR archive, optionally gzipped. The files in an archive are stored
+// in an album folder named after it instead, along with its cover image. It
+// returns the result of every file and whether the upload was an archive.
+func (u *Uploader) Unpack(filename string, content io.Reader) ([]Result, bool) {
+	buffered := bufio.NewReaderSize(content, SNIFFLEN)
+	// a short file is sniffed all the same, reading it fails again below if it failed here
+	head, _ := buffered.Peek(SNIFFLEN)
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return u.unzip(filename, buffered), true
+	case isTar(head):
+		return u.untar(filename, tar.NewReader(buffered)), true
+	case bytes.HasPrefix(head, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return []Result{newResult(filename, nil, err)}, true
+		}
+		defer gz.Close()
+		return u.untar(filename, tar.NewReader(gz)), true
+	}
+
+	song, err := u.Store("", filename, buffered)
+	return []Result{newResult(filename, song, err)}, false
+}
+
+// unzip stores the files of the ZIP archive. The archive is written to a
+// temporary file first, as its directory is at its end.
+func (u *Uploader) unzip(filename string, content io.Reader) []Result {
+	spool, err := os.CreateTemp("", "infiniti-upload-*.zip")
+	if err != nil {
+		return []Result{newResult(filename, nil, err)}
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, content)
+	if err != nil {
+		return []Result{newResult(filename, nil, err)}
+	}
+
+	archive, err := zip.NewReader(spool, size)
+	if err != nil {
+		return []Result{newResult(filename, nil, err)}
+	}
+
+	// covers go first, so that the songs are ingested with them
+	files := slices.Clone(archive.File)
+	slices.SortStableFunc(files, func(a *zip.File, b *zip.File) int {
+		return compareCovers(a.Name, b.Name)
+	})
+
+	album := newAlbum(u, filename)
+	for _, file := range files {
+		if file.FileInfo().IsDir() || hidden(file.Name) {
+			continue
+		}
+
+		entry, err := file.Open()
+		if err != nil {
+			album.add(file.Name, nil, err)
+			continue
+		}
+		album.store(file.Name, entry)
+		entry.Close()
+	}
+
+	return album.finish()
+}
+
+// untar stores the files of the TAR archive as they come.
+func (u *Uploader) untar(filename string, archive *tar.Reader) []Result {
+	album := newAlbum(u, filename)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			album.add("", nil, err)
+			break
+		}
+		if header.Typeflag != tar.TypeReg || hidden(header.Name) {
+			continue
+		}
+
+		album.store(header.Name, archive)
+	}
+
+	return album.finish()
+}
+
+// album stores the files of an archive in its folder.
+type album struct {
+	u       *Uploader
+	archive string
+	dir     string
+	results []Result
+	// covered is set once a cover has been stored
+	covered bool
+}
+
+func newAlbum(u *Uploader, archive string) *album {
+	dir := SafeName(archive)
+	// the name of a gzipped archive has two extensions
+	dir = strings.TrimSuffix(dir, ".tar")
+	return &album{u: u, archive: archive, dir: dir}
+}
+
+// store stores a file of the archive.
+func (a *album) store(name string, content io.Reader) {
+	if database.IsCoverFile(path.Base(name)) {
+		err := a.u.storeCover(path.Join(a.dir, path.Base(name)), content)
+		a.covered = a.covered || err == nil
+		a.add(name, nil, err)
+		return
+	}
+
+	song, err := a.u.Store(a.dir, name, content)
+	a.add(name, song, err)
+}
+
+func (a *album) add(name string, song *model.Song, err error) {
+	a.results = append(a.results, newResult(path.Join(a.archive, name), song, err))
+}
+
+// finish returns the results, after giving the songs stored before the cover of the album a cover as well.
+func (a *album) finish() []Result {
+	if len(a.results) == 0 {
+		a.add("", nil, errors.New("archive holds no files"))
+	}
+	if !a.covered {
+		return a.results
+	}
+
+	for i, result := range a.results {
+		if result.Status != CREATED || result.Song == nil || result.Song.CoverHash != "" {
+			continue
+		}
+
+		_, err := database.Ingest(a.u.songs, a.u.store, result.Song.Path)
+		if err == nil {
+			a.results[i].Song, err = a.u.songs.FindPath(result.Song.Path)
+		}
+		if err != nil {
+			a.results[i] = newResult(result.File, nil, err)
+		}
+	}
+	return a.results
+}
+
+// storeCover stores the cover image under name, unless there is a file by that name already.
+func (u *Uploader) storeCover(name string, content io.Reader) error {
+	head, err := readHead(content)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(http.DetectContentType(head), "image/") {
+		return ErrNotCover
+	}
+
+	stored, err := u.coverName(name)
+	if err != nil || stored {
+		return err
+	}
+	defer u.names.release(name, "")
+
+	return u.store.Put(name, io.MultiReader(bytes.NewReader(head), &limitReader{r: content, n: u.maxFileSize - int64(len(head))}))
+}
+
+// coverName reserves name for a cover, reporting whether a cover is stored or being stored under it already.
+func (u *Uploader) coverName(name string) (bool, error) {
+	defer u.names.mu.Unlock()
+	u.names.mu.Lock()
+
+	if u.names.paths[name] {
+		return true, nil
+	}
+	_, err := u.store.Stat(name)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	u.names.paths[name] = true
+	return false, nil
+}
+
+// isTar reports whether the content starts with a TAR header, which has its magic at offset 257.
+func isTar(head []byte) bool {
+	return len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar"))
+}
+
+// hidden reports whether the file in an archive is hidden, or metadata like that of macOS.
+func hidden(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// compareCovers orders cover files before the other files.
+func compareCovers(a string, b string) int {
+	aCover, bCover := database.IsCoverFile(path.Base(a)), database.IsCoverFile(path.Base(b))
+	switch {
+	case aCover == bCover:
+		return 0
+	case aCover:
+		return -1
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,299 @@
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EXPIRETICK is how often the expired uploads are removed while running.
+const EXPIRETICK = 10 * time.Minute
+
+var (
+	// ErrUploadNotFound is returned for resumable uploads that don't exist or have expired.
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrOffset is returned for parts that don't continue where the resumable upload is.
+	ErrOffset = errors.New("offset does not match the upload")
+	// ErrBusy is returned for parts sent while another part of the resumable upload is being written.
+	ErrBusy = errors.New("upload is being written already")
+)
+
+// Partial is a resumable upload, sent in parts that are appended to a file on
+// disk until it is complete. It is then stored like an uploaded file.
+type Partial struct {
+	ID       string            `json:"id"`
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata"`
+	Expires  time.Time         `json:"expires"`
+	// Owner is the user the songs are stored for, 0 for none.
+	Owner uint `json:"owner,omitempty"`
+	// Results tells what became of the files once the upload is complete
+	Results []Result `json:"results,omitempty"`
+}
+
+// Done reports whether every byte of the upload has arrived.
+func (p *Partial) Done() bool {
+	return p.Offset == p.Length
+}
+
+// Filename returns the name of the uploaded file, taken from its metadata.
+func (p *Partial) Filename() string {
+	if filename := p.Metadata["filename"]; filename != "" {
+		return filename
+	}
+	if name := p.Metadata["name"]; name != "" {
+		return name
+	}
+	return "upload"
+}
+
+// Resumable keeps the resumable uploads in a directory, each as a file with
+// the content received so far and a JSON file describing it. The uploads are
+// removed when they expire, a while after their last part arrived, so they
+// survive restarts but not being abandoned.
+type Resumable struct {
+	uploader *Uploader
+	dir      string
+	maxSize  int64
+	expiry   time.Duration
+
+	// busy holds the uploads a part is being written to
+	busy map[string]bool
+	mu   sync.Mutex
+}
+
+func NewResumable(uploader *Uploader, dir string, maxSize int64, expiry time.Duration) *Resumable {
+	return &Resumable{
+		uploader: uploader.Limit(maxSize),
+		dir:      dir,
+		maxSize:  maxSize,
+		expiry:   expiry,
+		busy:     make(map[string]bool),
+	}
+}
+
+// MaxSize returns the largest upload in bytes.
+func (r *Resumable) MaxSize() int64 {
+	return r.maxSize
+}
+
+// Run removes the expired uploads, those left over from before a restart
+// first, and then every EXPIRETICK until stop is closed.
+func (r *Resumable) Run(stop <-chan struct{}) {
+	r.expire(time.Now())
+
+	ticker := time.NewTicker(EXPIRETICK)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			r.expire(now)
+		}
+	}
+}
+
+// Create starts an upload of length bytes for the user with the id owner, 0 for
+// none, removing the expired ones first.
+func (r *Resumable) Create(length int64, metadata map[string]string, owner uint) (*Partial, error) {
+	if length > r.maxSize {
+		return nil, ErrTooLarge
+	}
+
+	err := os.MkdirAll(r.dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	r.expire(time.Now())
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Partial{ID: hex.EncodeToString(id), Length: length, Metadata: metadata, Expires: time.Now().Add(r.expiry), Owner: owner}
+	err = os.WriteFile(r.dataPath(p.ID), nil, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Done() {
+		return p, r.finish(p)
+	}
+	return p, r.save(p)
+}
+
+// Get returns the upload with the id.
+func (r *Resumable) Get(id string) (*Partial, error) {
+	if !validID(id) {
+		return nil, ErrUploadNotFound
+	}
+
+	content, err := os.ReadFile(r.infoPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var p Partial
+	err = json.Unmarshal(content, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(p.Expires) {
+		r.remove(id)
+		return nil, ErrUploadNotFound
+	}
+
+	if !p.Done() {
+		// the data file has every byte that was written, even if saving the offset failed
+		info, err := os.Stat(r.dataPath(id))
+		if err != nil {
+			return nil, err
+		}
+		p.Offset = info.Size()
+	}
+	return &p, nil
+}
+
+// Write appends the content to the upload, which must be at offset, and
+// stores the upload once it is complete. The content that was received is
+// kept when reading it fails, so the client can resume from there.
+func (r *Resumable) Write(id string, offset int64, content io.Reader) (*Partial, error) {
+	r.mu.Lock()
+	if r.busy[id] {
+		r.mu.Unlock()
+		return nil, ErrBusy
+	}
+	r.busy[id] = true
+	r.mu.Unlock()
+
+	defer func() {
+		defer r.mu.Unlock()
+		r.mu.Lock()
+		delete(r.busy, id)
+	}()
+
+	p, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Done() || p.Offset != offset {
+		return p, ErrOffset
+	}
+
+	file, err := os.OpenFile(r.dataPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(file, io.LimitReader(content, p.Length-p.Offset))
+	closeErr := file.Close()
+
+	p.Offset += n
+	p.Expires = time.Now().Add(r.expiry)
+	err = errors.Join(err, closeErr)
+	if err != nil {
+		r.save(p)
+		return p, err
+	}
+
+	if p.Done() {
+		return p, r.finish(p)
+	}
+	return p, r.save(p)
+}
+
+// Delete removes the upload.
+func (r *Resumable) Delete(id string) error {
+	_, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+	r.remove(id)
+	return nil
+}
+
+// finish stores the complete upload, keeping the results until it expires.
+func (r *Resumable) finish(p *Partial) error {
+	file, err := os.Open(r.dataPath(p.ID))
+	if err != nil {
+		return err
+	}
+	p.Results, _ = r.uploader.Owner(p.Owner).Unpack(p.Filename(), file)
+	file.Close()
+
+	os.Remove(r.dataPath(p.ID))
+	return r.save(p)
+}
+
+func (r *Resumable) save(p *Partial) error {
+	content, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	// written aside and renamed into place, so a crash never leaves half of it behind
+	temp := r.infoPath(p.ID) + ".tmp"
+	err = os.WriteFile(temp, content, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp, r.infoPath(p.ID))
+}
+
+// expire removes the uploads that expired by now.
+func (r *Resumable) expire(now time.Time) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+
+		content, err := os.ReadFile(r.infoPath(id))
+		if err != nil {
+			continue
+		}
+		var p Partial
+		if json.Unmarshal(content, &p) != nil || now.After(p.Expires) {
+			r.remove(id)
+		}
+	}
+}
+
+func (r *Resumable) remove(id string) {
+	os.Remove(r.dataPath(id))
+	os.Remove(r.infoPath(id))
+}
+
+func (r *Resumable) dataPath(id string) string {
+	return filepath.Join(r.dir, id+".part")
+}
+
+func (r *Resumable) infoPath(id string) string {
+	return filepath.Join(r.dir, id+".json")
+}
+
+// validID reports whether the id is one Create could have made, so it can't point outside of the directory.
+func validID(id string) bool {
+	decoded, err := hex.DecodeString(id)
+	return err == nil && len(decoded) == 16 && strings.ToLower(id) == id
+}
@@ -0,0 +1,37 @@
+package upload
+
+import "bytes"
+
+// SNIFFLEN is the amount of bytes at the start of a file Sniff looks at.
+const SNIFFLEN = 512
+
+// Sniff returns the file type of the audio format the content starts with,
+// like "mp3" or "flac", or false if it isn't an audio format songs may have.
+func Sniff(head []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte("ID3")):
+		// ID3v2 tags are put in front of MPEG audio
+		return "mp3", true
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		// an MPEG frame sync, layer 00 is used by AAC in ADTS
+		if head[1]&0x06 == 0 {
+			return "aac", true
+		}
+		return "mp3", true
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "flac", true
+	case bytes.HasPrefix(head, []byte("OggS")):
+		if bytes.Contains(head, []byte("OpusHead")) {
+			return "opus", true
+		}
+		return "ogg", true
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WAVE")):
+		return "wav", true
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("FORM")) && bytes.Equal(head[8:12], []byte("AIFF")):
+		return "aiff", true
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")) && !bytes.HasPrefix(head[8:], []byte("qt")):
+		return "m4a", true
+	}
+
+	return "", false
+}
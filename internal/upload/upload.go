@@ -0,0 +1,264 @@
+// Package upload adds the songs sent to the server to the library, making
+// sure only audio files end up in the storage backend, under a safe name.
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"infiniti.com/internal/database"
+	"infiniti.com/internal/storage"
+	model "infiniti.com/model"
+)
+
+// MAXNAMELEN is the longest a stored file name may be in bytes, extension included.
+const MAXNAMELEN = 200
+
+var (
+	// ErrNotAudio is returned for uploads whose content isn't a supported audio format.
+	ErrNotAudio = errors.New("file is not a supported audio format")
+	// ErrUnreadable is returned for audio files whose song couldn't be read.
+	ErrUnreadable = errors.New("could not read the song")
+	// ErrDuplicate is returned, along with the song, for files the library holds already.
+	ErrDuplicate = errors.New("song is in the library already")
+	// ErrTooLarge is returned for files larger than the maximum file size.
+	ErrTooLarge = errors.New("file is too large")
+)
+
+// Uploader stores uploaded songs and ingests them into the library.
+type Uploader struct {
+	songs database.SongRepository
+	store storage.Backend
+	// maxFileSize is the largest file in bytes that is stored
+	maxFileSize int64
+	// owner is the user the songs are stored for, 0 for none
+	owner uint
+
+	// names holds the names being stored, shared with the uploaders made by Limit and Owner
+	names *names
+	// mu makes checking for a duplicate and ingesting the file one step, shared like names
+	mu *sync.Mutex
+}
+
+// names are the file names and titles of the files being stored, so that
+// files can be stored side by side without taking each other's name.
+type names struct {
+	mu     sync.Mutex
+	paths  map[string]bool
+	titles map[string]bool
+}
+
+func New(songs database.SongRepository, store storage.Backend, maxFileSize int64) *Uploader {
+	reserved := &names{paths: make(map[string]bool), titles: make(map[string]bool)}
+	return &Uploader{songs: songs, store: store, maxFileSize: maxFileSize, names: reserved, mu: &sync.Mutex{}}
+}
+
+// Limit returns an uploader like u that stores files up to maxFileSize bytes.
+func (u *Uploader) Limit(maxFileSize int64) *Uploader {
+	limited := *u
+	limited.maxFileSize = maxFileSize
+	return &limited
+}
+
+// Owner returns an uploader like u that records the user with the id as the owner of the songs it stores.
+func (u *Uploader) Owner(id uint) *Uploader {
+	owned := *u
+	owned.owner = id
+	return &owned
+}
+
+// Store saves the content of the uploaded file named filename in the directory
+// dir, the root of the storage backend if empty, and returns the song created
+// for it. The type of file is taken from its content, not from its name, which
+// is cleaned up and numbered if the name or the title of its song is taken
+// already. Nothing is kept if the song can't be read or if the library has a
+// song with the same content, which is returned with ErrDuplicate.
+func (u *Uploader) Store(dir string, filename string, content io.Reader) (*model.Song, error) {
+	head, err := readHead(content)
+	if err != nil {
+		return nil, err
+	}
+
+	fileType, ok := Sniff(head)
+	if !ok {
+		return nil, ErrNotAudio
+	}
+
+	name, title, err := u.unusedName(dir, SafeName(filename), fileType)
+	if err != nil {
+		return nil, err
+	}
+	defer u.names.release(name, title)
+
+	// the backend writes to a temporary file and renames it into place, so a failed upload leaves nothing behind
+	hash := sha256.New()
+	limited := &limitReader{r: content, n: u.maxFileSize - int64(len(head))}
+	err = u.store.Put(name, io.TeeReader(io.MultiReader(bytes.NewReader(head), limited), hash))
+	if err != nil {
+		return nil, err
+	}
+
+	defer u.mu.Unlock()
+	u.mu.Lock()
+
+	duplicate, err := u.duplicate(hex.EncodeToString(hash.Sum(nil)))
+	if err != nil || duplicate != nil {
+		u.store.Delete(name)
+		if err != nil {
+			return nil, err
+		}
+		return duplicate, ErrDuplicate
+	}
+
+	_, err = database.Ingest(u.songs, u.store, name)
+	if err != nil {
+		u.store.Delete(name)
+		return nil, fmt.Errorf("%w: %v", ErrUnreadable, err)
+	}
+
+	song, err := u.songs.FindPath(name)
+	if err != nil || u.owner == 0 {
+		return song, err
+	}
+	song.OwnerID = u.owner
+	return song, u.songs.Update(song)
+}
+
+// duplicate returns the song with the hash whose file is still there, or nil if there is none.
+func (u *Uploader) duplicate(hash string) (*model.Song, error) {
+	songs, err := u.songs.FindHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, song := range songs {
+		_, err := u.store.Stat(song.Path)
+		if err == nil {
+			return &song, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// unusedName returns base in dir with the extension of the file type, numbered
+// if a file or song by that name exists already, as in "Song (2).mp3", along
+// with the title of its song. Both are reserved until they are released.
+func (u *Uploader) unusedName(dir string, base string, fileType string) (string, string, error) {
+	defer u.names.mu.Unlock()
+	u.names.mu.Lock()
+
+	for i := 1; ; i++ {
+		title := base
+		if i > 1 {
+			title = fmt.Sprintf("%s (%d)", base, i)
+		}
+		name := path.Join(dir, title+"."+fileType)
+		if u.names.paths[name] || u.names.titles[title] {
+			continue
+		}
+
+		_, err := u.store.Stat(name)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", "", err
+		}
+
+		_, err = u.songs.Find(title)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, database.ErrNotFound) {
+			return "", "", err
+		}
+
+		u.names.paths[name], u.names.titles[title] = true, true
+		return name, title, nil
+	}
+}
+
+// release makes the name and title available again, once the file is stored or failed to be.
+func (n *names) release(name string, title string) {
+	defer n.mu.Unlock()
+	n.mu.Lock()
+	delete(n.paths, name)
+	delete(n.titles, title)
+}
+
+// readHead reads the first SNIFFLEN bytes of the content, or all of it if it is shorter.
+func readHead(content io.Reader) ([]byte, error) {
+	head := make([]byte, SNIFFLEN)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// limitReader reads from r until more than n bytes have been read, after which it fails with ErrTooLarge.
+type limitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// SafeName turns the name of an uploaded file into a name without its
+// extension that is safe to store: without directories, hidden file dots,
+// control characters or characters file systems don't allow, and not too long.
+func SafeName(filename string) string {
+	// clients may send a whole path, with either kind of separator
+	base := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	base = strings.TrimSuffix(base, path.Ext(base))
+
+	base = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"/\|?*`, r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, base)
+	base = strings.Join(strings.Fields(base), " ")
+	base = strings.Trim(base, ". ")
+
+	// room is left for the numbering and the extension
+	limit := MAXNAMELEN - 16
+	for len(base) > limit {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+	base = strings.TrimRight(base, ". ")
+
+	if base == "" {
+		return "upload"
+	}
+	return base
+}
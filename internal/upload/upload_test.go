@@ -0,0 +1,149 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"infiniti.com/internal/database"
+	"infiniti.com/internal/storage"
+)
+
+const TEST_SONG = "../../resources/test_songs/Recording.mp3"
+
+func TestSniff(t *testing.T) {
+	song, err := os.ReadFile(TEST_SONG)
+	if err != nil {
+		t.Fatalf("Failed to read test song: %v", err)
+	}
+
+	tests := map[string]string{
+		string(song[:SNIFFLEN]): "mp3",
+		"ID3\x04\x00":           "mp3",
+		"\xFF\xF1\x50\x80":      "aac",
+		"fLaC\x00\x00\x00\x22":  "flac",
+		"OggS\x00\x02" + strings.Repeat("\x00", 22) + "OpusHead": "opus",
+		"OggS\x00\x02":                         "ogg",
+		"RIFF\x24\x00\x00\x00WAVEfmt ":         "wav",
+		"\x00\x00\x00\x20ftypM4A \x00\x00\x00": "m4a",
+		"\x00\x00\x00\x14ftypqt  \x00\x00\x00": "",
+		"<html></html>":                        "",
+		"":                                     "",
+	}
+
+	for head, expected := range tests {
+		fileType, ok := Sniff([]byte(head))
+		if fileType != expected || ok != (expected != "") {
+			t.Errorf("%q: expected %q; got %q, %v", head, expected, fileType, ok)
+		}
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	tests := map[string]string{
+		"Song.mp3":               "Song",
+		"../../etc/passwd":       "passwd",
+		`..\..\Windows\evil.mp3`: "evil",
+		".hidden.mp3":            "hidden",
+		"  spaced \t out  .mp3":  "spaced out",
+		"what?<is>|this*.mp3":    "whatisthis",
+		"bell\a\x00.mp3":         "bell",
+		"..":                     "upload",
+		"":                       "upload",
+		strings.Repeat("é", 200): strings.Repeat("é", (MAXNAMELEN-16)/2),
+	}
+
+	for filename, expected := range tests {
+		if name := SafeName(filename); name != expected {
+			t.Errorf("%q: expected %q; got %q", filename, expected, name)
+		}
+	}
+}
+
+func TestStoreSideBySide(t *testing.T) {
+	store, _ := storage.NewLocal(t.TempDir())
+	uploader := New(database.NewMemorySongRepository(), store, 1<<20)
+	content, _ := os.ReadFile(TEST_SONG)
+
+	// the first upload is still streaming while the second one is stored
+	reader, writer := io.Pipe()
+	slow := make(chan string)
+	go func() {
+		song, err := uploader.Store("", "Song.mp3", reader)
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		slow <- song.Path
+	}()
+	// the byte after the head is read once the file is being stored
+	writer.Write(content[:SNIFFLEN+1])
+
+	done := make(chan string)
+	go func() {
+		song, err := uploader.Store("", "Song.mp3", bytes.NewReader(append(content, "other"...)))
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		done <- song.Path
+	}()
+	select {
+	case name := <-done:
+		if name != "Song (2).mp3" {
+			t.Errorf("Expected the second upload to be numbered; got %q", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the second upload not to wait for the first")
+	}
+
+	writer.Write(content[SNIFFLEN+1:])
+	writer.Close()
+	if name := <-slow; name != "Song.mp3" {
+		t.Errorf("Expected the first upload to keep its name; got %q", name)
+	}
+}
+
+func TestResumableExpiry(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := storage.NewLocal(t.TempDir())
+	uploader := New(database.NewMemorySongRepository(), store, 1024)
+
+	expired := NewResumable(uploader, dir, 1024, -time.Second)
+	p, err := expired.Create(10, nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to create upload: %v", err)
+	}
+	if _, err := expired.Get(p.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected an expired upload not to be found; got %v", err)
+	}
+
+	abandoned, _ := expired.Create(10, nil, 0)
+	resumable := NewResumable(uploader, dir, 1024, time.Hour)
+	kept, _ := resumable.Create(10, nil, 0)
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Expected only the files of the new upload to be left; got %v", entries)
+	}
+	if _, err := resumable.Write(abandoned.ID, 0, strings.NewReader("0123456789")); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected an abandoned upload to be gone; got %v", err)
+	}
+	if p, err := resumable.Write(kept.ID, 0, strings.NewReader("01234")); err != nil || p.Offset != 5 {
+		t.Errorf("Expected the part to be written; got %+v, %v", p, err)
+	}
+	if _, err := resumable.Create(1025, nil, 0); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected a too large upload to be refused; got %v", err)
+	}
+
+	// uploads left over from before a restart are removed on start, without waiting for the next upload
+	expired.Create(10, nil, 0)
+	stop := make(chan struct{})
+	close(stop)
+	resumable.Run(stop)
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Expected the expired upload to be removed on start; got %v", entries)
+	}
+}
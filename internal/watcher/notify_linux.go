@@ -0,0 +1,176 @@
+//go:build linux
+
+package watcher
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// WATCHMASK selects the inotify events of a watched directory. Modifications
+// are included so that files being written keep being debounced.
+const WATCHMASK = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE
+
+// inotify reports the changes in a directory tree through inotify. Every
+// directory in the tree is watched on its own, as inotify isn't recursive.
+type inotify struct {
+	// fd is kept apart from file, as calling file.Fd would make reads blocking again
+	fd   int
+	file *os.File
+	root string
+	// dirs holds the directory, relative to root, of every watch descriptor
+	dirs map[int]string
+
+	events chan event
+	done   chan struct{}
+	mu     sync.Mutex
+}
+
+func watchDir(root string) (notifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	// a non-blocking descriptor is read through the runtime's poller, so Close interrupts a pending read
+	in := &inotify{
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		root:   root,
+		dirs:   make(map[int]string),
+		events: make(chan event),
+		done:   make(chan struct{}),
+	}
+
+	err = in.watchTree("")
+	if err != nil {
+		in.file.Close()
+		return nil, err
+	}
+
+	go in.run()
+	return in, nil
+}
+
+func (in *inotify) Events() <-chan event {
+	return in.events
+}
+
+func (in *inotify) Close() error {
+	close(in.done)
+	return in.file.Close()
+}
+
+// watchTree watches the directory and every directory below it, skipping hidden ones.
+func (in *inotify) watchTree(dir string) error {
+	return filepath.WalkDir(filepath.Join(in.root, filepath.FromSlash(dir)), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != in.root && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(in.root, path)
+		if err != nil {
+			return err
+		}
+
+		wd, err := unix.InotifyAddWatch(in.fd, path, WATCHMASK)
+		if err != nil {
+			return err
+		}
+
+		defer in.mu.Unlock()
+		in.mu.Lock()
+		in.dirs[wd] = filepath.ToSlash(rel)
+		return nil
+	})
+}
+
+// unwatchTree stops watching the directory and every directory below it.
+func (in *inotify) unwatchTree(dir string) {
+	defer in.mu.Unlock()
+	in.mu.Lock()
+
+	for wd, watched := range in.dirs {
+		if watched == dir || strings.HasPrefix(watched, dir+"/") {
+			unix.InotifyRmWatch(in.fd, uint32(wd))
+			delete(in.dirs, wd)
+		}
+	}
+}
+
+func (in *inotify) run() {
+	buffer := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := in.file.Read(buffer)
+		if err != nil {
+			select {
+			case <-in.done:
+			default:
+				log.Println("Stopped watching the songs:", err)
+			}
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			name := strings.TrimRight(string(buffer[offset+unix.SizeofInotifyEvent:offset+unix.SizeofInotifyEvent+int(raw.Len)]), "\x00")
+			offset += unix.SizeofInotifyEvent + int(raw.Len)
+
+			if !in.handle(int(raw.Wd), raw.Mask, name) {
+				return
+			}
+		}
+	}
+}
+
+// handle passes an inotify event on, returning false once the notifier is closed.
+func (in *inotify) handle(wd int, mask uint32, name string) bool {
+	in.mu.Lock()
+	dir, ok := in.dirs[wd]
+	if mask&unix.IN_IGNORED != 0 {
+		delete(in.dirs, wd)
+	}
+	in.mu.Unlock()
+
+	if !ok || name == "" {
+		return true
+	}
+
+	ev := event{name: name, dir: mask&unix.IN_ISDIR != 0}
+	if dir != "." {
+		ev.name = dir + "/" + name
+	}
+
+	if ev.dir {
+		switch {
+		case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+			// files may have been created in the directory before it was watched
+			err := in.watchTree(ev.name)
+			if err != nil {
+				log.Println(err)
+			}
+		case mask&unix.IN_MOVED_FROM != 0:
+			in.unwatchTree(ev.name)
+		}
+	}
+
+	select {
+	case in.events <- ev:
+		return true
+	case <-in.done:
+		return false
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watcher
+
+import "errors"
+
+// watchDir isn't supported without inotify, so the storage backend is polled instead.
+func watchDir(root string) (notifier, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,100 @@
+package watcher
+
+import (
+	"log"
+	"time"
+
+	"infiniti.com/internal/storage"
+)
+
+// poller finds changes by listing the storage backend every interval and
+// comparing it to the listing before.
+type poller struct {
+	store    storage.Backend
+	interval time.Duration
+	files    map[string]storage.FileInfo
+
+	events chan event
+	stop   chan struct{}
+}
+
+// newPoller takes the current files as they are and reports changes from then on.
+func newPoller(store storage.Backend, interval time.Duration) (*poller, error) {
+	p := &poller{
+		store:    store,
+		interval: interval,
+		events:   make(chan event),
+		stop:     make(chan struct{}),
+	}
+
+	var err error
+	p.files, err = p.list()
+	if err != nil {
+		return nil, err
+	}
+
+	go p.run()
+	return p, nil
+}
+
+func (p *poller) Events() <-chan event {
+	return p.events
+}
+
+func (p *poller) Close() error {
+	close(p.stop)
+	return nil
+}
+
+func (p *poller) run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		files, err := p.list()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		var changed []string
+		for name, info := range files {
+			if before, ok := p.files[name]; !ok || before.Size != info.Size || !before.ModTime.Equal(info.ModTime) || before.ETag != info.ETag {
+				changed = append(changed, name)
+			}
+		}
+		for name := range p.files {
+			if _, ok := files[name]; !ok {
+				changed = append(changed, name)
+			}
+		}
+		p.files = files
+
+		for _, name := range changed {
+			select {
+			case p.events <- event{name: name}:
+			case <-p.stop:
+				return
+			}
+		}
+	}
+}
+
+func (p *poller) list() (map[string]storage.FileInfo, error) {
+	list, err := p.store.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]storage.FileInfo, len(list))
+	for _, info := range list {
+		files[info.Name] = info
+	}
+	return files, nil
+}
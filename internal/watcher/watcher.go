@@ -0,0 +1,189 @@
+// Package watcher keeps the library in sync with the storage backend while
+// the server runs, so songs copied into it show up without a restart.
+package watcher
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"infiniti.com/config"
+	"infiniti.com/internal/database"
+	"infiniti.com/internal/storage"
+)
+
+// TICK is the shortest time between two checks for files that have been left alone long enough.
+const TICK = 50 * time.Millisecond
+
+// event names a file that changed in the storage backend, or a directory when
+// dir is set, in which case any file below it may have changed.
+type event struct {
+	name string
+	dir  bool
+}
+
+// notifier reports changes to the files in the storage backend.
+type notifier interface {
+	Events() <-chan event
+	Close() error
+}
+
+// Watcher ingests the files added to or changed in the storage backend and
+// removes the songs whose file is gone, through the same path as a scan.
+type Watcher struct {
+	songs database.SongRepository
+	store storage.Backend
+	cfg   config.Watch
+}
+
+func New(songs database.SongRepository, store storage.Backend, cfg config.Watch) *Watcher {
+	return &Watcher{songs: songs, store: store, cfg: cfg}
+}
+
+// Run watches the storage backend until stop is closed. Filesystem events are
+// used for the local backend on Linux, other setups poll. Files are only
+// ingested once they have been left alone for the debounce time, so files that
+// are still being copied aren't read halfway.
+func (w *Watcher) Run(stop <-chan struct{}) error {
+	n, err := w.notifier()
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+
+	w.run(n, stop)
+	return nil
+}
+
+// run syncs the files reported by the notifier until stop is closed.
+func (w *Watcher) run(n notifier, stop <-chan struct{}) {
+	debounce := time.Duration(w.cfg.Debounce) * time.Millisecond
+	ticker := time.NewTicker(max(debounce/2, TICK))
+	defer ticker.Stop()
+
+	// pending holds when each changed file may be synced
+	pending := make(map[string]time.Time)
+	for {
+		select {
+		case <-stop:
+			return
+
+		case ev := <-n.Events():
+			names := []string{ev.name}
+			if ev.dir {
+				names = w.below(ev.name)
+			}
+			for _, name := range names {
+				if !ignored(name) {
+					pending[name] = time.Now().Add(debounce)
+				}
+			}
+
+		case now := <-ticker.C:
+			var due []string
+			for name, at := range pending {
+				if !now.Before(at) {
+					due = append(due, name)
+					delete(pending, name)
+				}
+			}
+			w.sync(due)
+		}
+	}
+}
+
+func (w *Watcher) notifier() (notifier, error) {
+	if local, ok := w.store.(*storage.Local); ok && !w.cfg.Poll {
+		n, err := watchDir(local.Root())
+		if err == nil {
+			return n, nil
+		}
+		log.Println("Polling the songs, as their directory can't be watched:", err)
+	}
+
+	return newPoller(w.store, time.Duration(w.cfg.Interval)*time.Second)
+}
+
+// sync brings the songs of the files up to date. Files that still exist go
+// first, so a renamed file is found to be moved before its old name is marked
+// as missing.
+func (w *Watcher) sync(names []string) {
+	var missing []string
+	for _, name := range names {
+		_, err := w.store.Stat(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, name)
+			continue
+		}
+
+		change, err := database.Ingest(w.songs, w.store, name)
+		switch {
+		case err != nil:
+			log.Printf("Skipping '%s': %v\n", name, err)
+		case change == database.Added:
+			log.Printf("Added '%s'\n", name)
+		case change == database.Updated:
+			log.Printf("Updated '%s'\n", name)
+		}
+	}
+
+	for _, name := range missing {
+		song, err := w.songs.FindPath(name)
+		if errors.Is(err, database.ErrNotFound) {
+			// never ingested, or moved along by now
+			continue
+		}
+		if err == nil && song.Missing {
+			continue
+		}
+		if err == nil {
+			// marked like the scanner does, so a file that is replaced keeps its song, owner and links
+			err = database.MarkMissing(w.songs, song)
+		}
+		if err != nil {
+			log.Printf("Could not mark '%s' as missing: %v\n", name, err)
+			continue
+		}
+		log.Printf("Missing '%s'\n", name)
+	}
+}
+
+// below returns the files in the directory and the songs that were in it.
+func (w *Watcher) below(dir string) []string {
+	prefix := dir + "/"
+
+	var names []string
+	files, err := w.store.List(prefix)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+
+	songs, err := w.songs.List()
+	if err != nil {
+		log.Println(err)
+	}
+	for _, song := range songs {
+		if strings.HasPrefix(song.Path, prefix) {
+			names = append(names, song.Path)
+		}
+	}
+
+	return names
+}
+
+// ignored reports whether the file is no song or hidden, like the temporary
+// files rsync and the storage backend write before renaming them into place.
+func ignored(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return !database.IsSongFile(path.Base(name))
+}
@@ -0,0 +1,116 @@
+package watcher
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"infiniti.com/config"
+	"infiniti.com/internal/database"
+	"infiniti.com/internal/storage"
+)
+
+const TEST_SONG = "../../resources/test_songs/Recording.mp3"
+
+// eventually fails the test unless the condition holds within a few seconds.
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for start := time.Now(); !condition(); time.Sleep(20 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Expected %s", what)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	content, err := os.ReadFile(TEST_SONG)
+	if err != nil {
+		t.Fatalf("Failed to read test song: %v", err)
+	}
+
+	modes := map[string]bool{"events": false, "poll": true}
+	for name, poll := range modes {
+		t.Run(name, func(t *testing.T) {
+			store, err := storage.NewLocal(t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to open storage: %v", err)
+			}
+			store.Put("gone.mp3", bytes.NewReader(content))
+
+			songs := database.NewMemorySongRepository()
+			database.Seed(songs, store)
+
+			w := New(songs, store, config.Watch{Enabled: true, Poll: poll, Interval: 1, Debounce: 100})
+			n, err := w.notifier()
+			if err != nil {
+				t.Fatalf("Failed to watch the songs: %v", err)
+			}
+			if _, polling := n.(*poller); polling != poll {
+				t.Fatalf("Expected polling to be %v", poll)
+			}
+
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				w.run(n, stop)
+				close(done)
+			}()
+			t.Cleanup(func() {
+				close(stop)
+				<-done
+				n.Close()
+			})
+
+			store.Put("Artist/Album/new.mp3", bytes.NewReader(content))
+			eventually(t, "a song dropped into a new directory to be added", func() bool {
+				_, err := songs.FindPath("Artist/Album/new.mp3")
+				return err == nil
+			})
+
+			song, _ := songs.FindPath("Artist/Album/new.mp3")
+			os.Rename(filepath.Join(store.Root(), "Artist/Album/new.mp3"), filepath.Join(store.Root(), "Artist/Album/renamed.mp3"))
+			eventually(t, "a renamed song to keep its song", func() bool {
+				renamed, err := songs.FindPath("Artist/Album/renamed.mp3")
+				return err == nil && renamed.ID == song.ID
+			})
+
+			gone, _ := songs.FindPath("gone.mp3")
+			store.Delete("gone.mp3")
+			eventually(t, "a deleted song to be marked as missing", func() bool {
+				song, err := songs.FindPath("gone.mp3")
+				return err == nil && song.Missing
+			})
+
+			// a file that is replaced, like rsync does, keeps its song
+			store.Put("gone.mp3", bytes.NewReader(append(content, "new"...)))
+			eventually(t, "a song that is back not to be missing", func() bool {
+				song, err := songs.FindPath("gone.mp3")
+				return err == nil && !song.Missing && song.ID == gone.ID
+			})
+
+			list, _ := songs.List()
+			if len(list) != 2 {
+				t.Errorf("Expected 2 songs to be left; got %+v", list)
+			}
+		})
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	tests := map[string]bool{
+		"song.mp3":                false,
+		"Artist/Album/song.mp3":   false,
+		"Artist/.song.mp3.Xa1b2c": true,
+		".infiniti-123.tmp":       true,
+		".covers/0123abcd":        true,
+		"Artist/Album/cover.jpg":  true,
+	}
+
+	for name, expected := range tests {
+		if ignored(name) != expected {
+			t.Errorf("%s: expected ignored to be %v", name, expected)
+		}
+	}
+}
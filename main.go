@@ -1,40 +1,99 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"infiniti.com/config"
 	"infiniti.com/routes"
 
 	song_controller "infiniti.com/controller"
 	_ "infiniti.com/docs"
+	"infiniti.com/internal/audiopipeline"
+	"infiniti.com/internal/auth"
 	"infiniti.com/internal/database"
+	"infiniti.com/internal/search"
+	"infiniti.com/internal/storage"
+	"infiniti.com/internal/suggest"
+	"infiniti.com/internal/watcher"
 )
 
-func init() {
-	dbHost := config.DB_HOST
-	dbPass := config.DB_PASS
-	dbName := config.DB_NAME
+// @title Infiniti API
+// @version 1.0
+// @description This is a simple API for a music streaming service.
+// @host 127.0.0.1:9000
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description A session token from /api/v1/auth/login or an API key, as "Bearer <token>"
+func main() {
+	configPath := flag.String("config", os.Getenv(config.ENV_PREFIX+"CONFIG"), "path to a .yaml, .toml or .env config file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal("Error loading configuration, err: ", err)
+	}
 
-	fmt.Println("Connecting to database...", dbHost, dbPass, dbName)
+	audiopipeline.BufferSize = cfg.BufferSize
 
-	db, err := database.Connect(dbHost, dbPass, dbName)
+	fmt.Println("Connecting to database...", cfg.Database.Host, cfg.Database.Name)
+
+	db, err := database.Connect(cfg.Database)
 	if err != nil {
 		log.Fatal("Error connecting to database, err: ", err)
 	}
 
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatal("Error opening song storage, err: ", err)
+	}
+
 	database.Migrate(db)
-	database.Seed(db, "./resources/songs")
-	song_controller.Init(db)
-}
+	library := database.NewGormSongRepository(db)
+	database.Seed(library, store)
 
-// @title Infiniti API
-// @version 1.0
-// @description This is a simple API for a music streaming service.
-// @host 127.0.0.1:9000
-func main() {
-	router := routes.SetupRouter()
+	// changes made from now on reach the search index and suggestions through the observed repository
+	songs := database.Observe(library)
+	index := search.NewIndex()
+	songs.Listen(index)
+	err = index.Load(songs)
+	if err != nil {
+		log.Fatal("Error building the search index, err: ", err)
+	}
+	suggester := suggest.New()
+	songs.Listen(suggester)
+	err = suggester.Load(songs)
+	if err != nil {
+		log.Fatal("Error loading suggestions, err: ", err)
+	}
+
+	if cfg.Watch.Enabled {
+		go func() {
+			err := watcher.New(songs, store, cfg.Watch).Run(nil)
+			if err != nil {
+				log.Println("Error watching songs, err: ", err)
+			}
+		}()
+	}
+
+	authenticator, err := auth.New(database.NewGormUserRepository(db), cfg.Auth)
+	if err != nil {
+		log.Fatal("Error setting up authentication, err: ", err)
+	}
+
+	songController := song_controller.NewSongController(songs, store, cfg)
+	go songController.ExpireUploads(nil)
+
+	router := routes.SetupRouter(cfg,
+		songController,
+		song_controller.NewStationController(songs, store, cfg),
+		song_controller.NewLibraryController(library, store),
+		song_controller.NewSearchController(index, suggester, songs),
+		song_controller.NewAuthController(authenticator),
+	)
 
-	router.Run("0.0.0.0:" + config.PORT)
+	router.Run(cfg.ListenAddr)
 }
@@ -0,0 +1,13 @@
+package model
+
+// Album groups the songs released together by an artist, told apart by its
+// title ignoring case and spacing.
+type Album struct {
+	ID       uint `gorm:"primaryKey"`
+	Title    string
+	TitleKey string `gorm:"size:191;uniqueIndex:idx_albums_artist_title" json:"-"`
+	ArtistID uint   `gorm:"uniqueIndex:idx_albums_artist_title"`
+	Year     int
+	// CoverHash is the cover of the first song on the album that has one.
+	CoverHash string
+}
@@ -0,0 +1,15 @@
+package model
+
+// Artist performs songs and releases albums. Artists are told apart by their
+// name ignoring case and spacing, so tags spelling it differently share one.
+type Artist struct {
+	ID      uint `gorm:"primaryKey"`
+	Name    string
+	NameKey string `gorm:"size:191;uniqueIndex" json:"-"`
+}
+
+// FeaturedArtist links a song to an artist featured on it besides its main artist.
+type FeaturedArtist struct {
+	SongID   uint `gorm:"primaryKey"`
+	ArtistID uint `gorm:"primaryKey;index"`
+}
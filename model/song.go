@@ -1,9 +1,48 @@
 package model
 
+import "time"
+
 type Song struct {
 	ID       uint   `gorm:"primaryKey"`
 	Title    string `gorm:"unique"`
 	FileType string
 	Artist   string
 	Path     string
+	// Slug names the song in URLs, made from its title when it is saved without one.
+	Slug string `gorm:"size:191;index"`
+
+	// ArtistID and AlbumID link the song to its main artist and album, 0 if it has none.
+	ArtistID uint `gorm:"index"`
+	AlbumID  uint `gorm:"index"`
+
+	Album       string
+	AlbumArtist string
+	Genre       string
+	Composer    string
+	Year        int
+	Track       int
+	TrackTotal  int
+	Disc        int
+	DiscTotal   int
+	Lyrics      string
+
+	// Duration is the play time in seconds.
+	Duration float64
+	// Bitrate is the average bitrate in kbps.
+	Bitrate    int
+	SampleRate int
+	Channels   int
+
+	FileSize int64
+	// ModTime is when the song's file was last modified, as of when it was last read.
+	ModTime time.Time
+	// Hash is the hex encoded SHA-256 of the song's file.
+	Hash string `gorm:"index"`
+	// CoverHash names the song's cover image in the storage backend, empty if it has none.
+	CoverHash string
+	// OwnerID is the user who uploaded the song, 0 for songs found in the storage backend.
+	OwnerID uint `gorm:"index"`
+	// Missing is set while the song's file is gone from the storage backend.
+	// The song is kept, with its owner and links, in case the file comes back.
+	Missing bool
 }
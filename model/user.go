@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// User is an account, which is needed to change the library. Usernames are
+// lowercase, so they are told apart ignoring case.
+type User struct {
+	ID       uint   `gorm:"primaryKey"`
+	Username string `gorm:"size:191;uniqueIndex"`
+	// Role names the permissions of the user: admin, uploader or listener.
+	Role string `gorm:"size:16;default:listener"`
+	// PasswordHash is the bcrypt hash of the password.
+	PasswordHash string `json:"-"`
+	CreatedAt    time.Time
+}
+
+// APIKey lets scripts act as a user without logging in. Only the hash of the
+// key is stored, the key itself is shown once when it is created.
+type APIKey struct {
+	ID     uint `gorm:"primaryKey"`
+	UserID uint `gorm:"index"`
+	Name   string
+	// Prefix is the start of the key, to tell keys apart.
+	Prefix string
+	// Hash is the hex encoded SHA-256 of the key.
+	Hash      string `gorm:"size:64;uniqueIndex" json:"-"`
+	CreatedAt time.Time
+}
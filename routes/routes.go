@@ -4,43 +4,159 @@ import (
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"infiniti.com/config"
 	song_handler "infiniti.com/controller"
+	"infiniti.com/internal/auth"
 )
 
-func SetupRouter() *gin.Engine {
-	router := gin.Default()
+func SetupRouter(cfg *config.Config, songs *song_handler.SongController, stations *song_handler.StationController, library *song_handler.LibraryController, search *song_handler.SearchController, accounts *song_handler.AuthController) *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Logger(), gin.CustomRecovery(song_handler.Recover))
+	router.NoRoute(song_handler.RouteNotFound)
 	router.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	router.MaxMultipartMemory = 8 << 20
+	router.MaxMultipartMemory = cfg.Upload.MaxMultipartMemory
 
-	router.GET("/", homeScreen)
-	router.GET("/songs", getSongs)
-	router.GET("/songs/:param", getSpecifiedSong)
-	router.GET("/search/:param", searchSong)
-	router.GET("/play/:param", playSong)
-	router.POST("/upload", uploadSong)
-	router.GET("/remove/:param", removeSong)
+	router.GET("/", homeScreen(songs))
+
+	// every route but the home screen and the docs requires a permission, of the user or of anonymous requests
+	read := accounts.Require(auth.SONGSREAD)
+	write := accounts.Require(auth.SONGSWRITE)
+	remove := accounts.Require(auth.SONGSDELETE)
+	control := accounts.Require(auth.STATIONSCONTROL)
+
+	v1 := router.Group("/api/v1")
+	authRoutes(v1, accounts)
+	userRoutes(v1, accounts)
+	v1.GET("/songs", read, getSongs(songs))
+	v1.POST("/songs", write, createSong(songs))
+	v1.GET("/songs/slug/:slug", read, getSongBySlug(songs))
+	v1.GET("/songs/:id", read, getSpecifiedSong(songs))
+	v1.PATCH("/songs/:id", write, updateSong(songs))
+	v1.DELETE("/songs/:id", remove, deleteSong(songs))
+	v1.GET("/songs/:id/stream", read, streamSong(songs))
+	v1.HEAD("/songs/:id/stream", read, streamSong(songs))
+	v1.GET("/songs/:id/hls/:file", read, songHLS(songs))
+	v1.GET("/songs/:id/cover", read, songCover(songs))
+	v1.GET("/songs/:id/play", read, playSong(songs))
+	v1.GET("/search", read, searchSongs(search))
+	v1.GET("/suggest", read, getSuggestions(search))
+	uploadRoutes(v1, songs, write)
+	stationRoutes(v1, stations, read, control)
+	libraryRoutes(v1, library, read)
+
+	// the routes from before /api/v1, which look songs up by title as well
+	legacy := router.Group("", deprecated)
+	legacy.GET("/songs", read, getSongs(songs))
+	legacy.GET("/songs/:param", read, getSpecifiedSong(songs))
+	legacy.GET("/songs/:param/stream", read, streamSong(songs))
+	legacy.HEAD("/songs/:param/stream", read, streamSong(songs))
+	legacy.GET("/songs/:param/hls/:file", read, songHLS(songs))
+	legacy.GET("/songs/:param/cover", read, songCover(songs))
+	legacy.GET("/search/:param", read, searchSong(search))
+	legacy.GET("/play/:param", read, playSong(songs))
+	legacy.POST("/upload", write, uploadSong(songs))
+	legacy.GET("/remove/:param", remove, removeSong(songs))
+	uploadRoutes(legacy, songs, write)
+	stationRoutes(legacy, stations, read, control)
+	libraryRoutes(legacy, library, read)
 
 	return router
 }
 
+func authRoutes(router *gin.RouterGroup, accounts *song_handler.AuthController) {
+	router.POST("/auth/register", register(accounts))
+	router.POST("/auth/login", login(accounts))
+	router.GET("/auth/me", accounts.Authenticate, me(accounts))
+	router.GET("/auth/keys", accounts.Authenticate, getAPIKeys(accounts))
+	router.POST("/auth/keys", accounts.Authenticate, createAPIKey(accounts))
+	router.DELETE("/auth/keys/:id", accounts.Authenticate, deleteAPIKey(accounts))
+}
+
+func userRoutes(router *gin.RouterGroup, accounts *song_handler.AuthController) {
+	admin := accounts.Require(auth.USERSADMIN)
+	router.GET("/roles", admin, getRoles(accounts))
+	router.GET("/users", admin, getUsers(accounts))
+	router.POST("/users", admin, createUser(accounts))
+	router.GET("/users/:id", admin, getUser(accounts))
+	router.PATCH("/users/:id", admin, updateUser(accounts))
+	router.DELETE("/users/:id", admin, deleteUser(accounts))
+}
+
+func uploadRoutes(router *gin.RouterGroup, songs *song_handler.SongController, write gin.HandlerFunc) {
+	uploads := router.Group("/uploads", songs.Tus)
+	uploads.OPTIONS("", resumableOptions(songs))
+	uploads.POST("", write, createResumable(songs))
+	uploads.HEAD("/:id", write, resumableOffset(songs))
+	uploads.PATCH("/:id", write, patchResumable(songs))
+	uploads.DELETE("/:id", write, deleteResumable(songs))
+	uploads.GET("/:id", write, getResumable(songs))
+}
+
+func stationRoutes(router *gin.RouterGroup, stations *song_handler.StationController, read gin.HandlerFunc, control gin.HandlerFunc) {
+	router.GET("/stations", read, getStations(stations))
+	router.POST("/stations", control, createStation(stations))
+	router.GET("/stations/:name", read, getStation(stations))
+	router.DELETE("/stations/:name", control, deleteStation(stations))
+	router.GET("/stations/:name/listen", read, listenStation(stations))
+	router.GET("/stations/:name/hls/:file", read, stationHLS(stations))
+	router.POST("/stations/:name/skip", control, skipTrack(stations))
+	router.POST("/stations/:name/pause", control, pauseStation(stations))
+	router.POST("/stations/:name/resume", control, resumeStation(stations))
+	router.GET("/stations/:name/queue", read, getQueue(stations))
+	router.POST("/stations/:name/queue", control, enqueueSong(stations))
+	router.DELETE("/stations/:name/queue/:entry", control, removeQueueEntry(stations))
+	router.PATCH("/stations/:name/queue/:entry", control, moveQueueEntry(stations))
+}
+
+func libraryRoutes(router *gin.RouterGroup, library *song_handler.LibraryController, read gin.HandlerFunc) {
+	router.GET("/artists", read, getArtists(library))
+	router.GET("/artists/:id/albums", read, getArtistAlbums(library))
+	router.GET("/albums", read, getAlbums(library))
+	router.GET("/albums/:id/tracks", read, getAlbumTracks(library))
+	router.GET("/albums/:id/cover", read, albumCover(library))
+}
+
+// deprecated marks the responses of the routes from before /api/v1, pointing clients to their successor.
+func deprecated(c *gin.Context) {
+	c.Header("Deprecation", "true")
+	c.Header("Link", `</api/v1>; rel="successor-version"`)
+	c.Next()
+}
+
 // @tags Home v1
 // @summary Home screen
 // @description Welcome message and available endpoints
 // @produce  plain
 // @success 200 {string} string
 // @router / [get]
-func homeScreen(c *gin.Context) {
-	song_handler.HomeScreen(c)
+func homeScreen(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.HomeScreen
 }
 
 // @Tags Get
 // @Summary Get all songs
-// @Description Get all songs in the database
+// @Description Get a page of the songs in the database, filtered, sorted and with the fields asked for
 // @Produce  json
+// @Param artist query string false "Artist name"
+// @Param album query string false "Album title"
+// @Param genre query string false "Genre"
+// @Param file_type query string false "Comma separated file types"
+// @Param min_duration query number false "Minimum duration in seconds"
+// @Param max_duration query number false "Maximum duration in seconds"
+// @Param sort query string false "title, artist, album, duration or added, descending with a leading -"
+// @Param limit query int false "Songs in a page, 50 by default and 500 at most"
+// @Param offset query int false "Songs to skip"
+// @Param cursor query string false "Cursor from a next link, empty to start paging by cursor"
+// @Param fields query string false "Comma separated fields of the songs"
+// @Success 200 {array} model.Song
+// @Header 200 {integer} X-Total-Count "Songs that match"
+// @Header 200 {string} Link "first, prev, next and last pages"
+// @Failure 400 {object} controller.APIError
 // @Router /songs [get]
-func getSongs(c *gin.Context) {
-	song_handler.GetSongs(c)
+// @Router /api/v1/songs [get]
+func getSongs(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.GetSongs
 }
 
 // @Tags Get
@@ -48,19 +164,94 @@ func getSongs(c *gin.Context) {
 // @Description Get a song by its ID
 // @Produce  json
 // @Param param path int true "Song ID"
+// @Param id path int true "Song ID"
 // @Router /songs/{param} [get]
-func getSpecifiedSong(c *gin.Context) {
-	song_handler.GetSpecifiedSong(c)
+// @Router /api/v1/songs/{id} [get]
+func getSpecifiedSong(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.GetSpecifiedSong
+}
+
+// @Tags Play
+// @Summary Stream a song on demand
+// @Description Serve a song's file with support for seeking through HTTP range requests
+// @Produce  audio/mpeg
+// @Param param path int true "Song ID"
+// @Param id path int true "Song ID"
+// @Param Range header string false "Byte range to serve, e.g. bytes=0-1023"
+// @Success 200 {file} binary
+// @Success 206 {file} binary
+// @Router /songs/{param}/stream [get]
+// @Router /api/v1/songs/{id}/stream [get]
+func streamSong(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.StreamSong
+}
+
+// @Tags Play
+// @Summary Stream a song over HLS
+// @Description Get a song's VOD playlist (index.m3u8) or one of its segments (<sequence>.mp3)
+// @Produce  application/vnd.apple.mpegurl
+// @Produce  audio/mpeg
+// @Param param path int true "Song ID"
+// @Param id path int true "Song ID"
+// @Param file path string true "index.m3u8 or a segment"
+// @Failure 415 {object} controller.APIError
+// @Router /songs/{param}/hls/{file} [get]
+// @Router /api/v1/songs/{id}/hls/{file} [get]
+func songHLS(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.SongHLS
+}
+
+// @Tags Get
+// @Summary Get the cover of a song
+// @Description Get a song's cover image, optionally scaled down to fit a square of the given size
+// @Produce  image/jpeg
+// @Produce  image/png
+// @Param param path int true "Song ID"
+// @Param id path int true "Song ID"
+// @Param size query int false "Size in pixels, between 16 and 2048"
+// @Router /songs/{param}/cover [get]
+// @Router /api/v1/songs/{id}/cover [get]
+func songCover(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.SongCover
+}
+
+// @Tags Search
+// @Summary Search for songs
+// @Description Find songs by the words of their title, artist, album, genre and lyrics, best first. Words match the words they start and those a typo away too, words in quotes match as a phrase and a field name in front, as in artist:queen, limits a word or phrase to that field.
+// @Produce  json
+// @Param q query string true "Search query"
+// @Param limit query int false "Songs in a page, 50 by default and 500 at most"
+// @Param offset query int false "Songs to skip"
+// @Success 200 {array} search.Result
+// @Header 200 {integer} X-Total-Count "Songs that match"
+// @Failure 400 {object} controller.APIError
+// @Router /api/v1/search [get]
+func searchSongs(search *song_handler.SearchController) gin.HandlerFunc {
+	return search.Search
+}
+
+// @Tags Search
+// @Summary Suggest songs, artists and albums
+// @Description Suggest the songs, artists and albums whose name starts like what is being typed, or has a word that does
+// @Produce  json
+// @Param q query string true "Start of a name"
+// @Param limit query int false "Suggestions, 10 by default and 50 at most"
+// @Success 200 {array} suggest.Suggestion
+// @Failure 400 {object} controller.APIError
+// @Router /api/v1/suggest [get]
+func getSuggestions(search *song_handler.SearchController) gin.HandlerFunc {
+	return search.Suggest
 }
 
 // @Tags Search
 // @Summary Search for a song
-// @Description Search for a song by its title
+// @Description Search for songs by the words of their title, artist, album, genre and lyrics
 // @Produce  json
-// @Param param path string true "Song title"
+// @Param param path string true "Search term"
+// @Deprecated
 // @Router /search/{param} [get]
-func searchSong(c *gin.Context) {
-	song_handler.SearchSong(c)
+func searchSong(search *song_handler.SearchController) gin.HandlerFunc {
+	return search.SearchSongs
 }
 
 // @Tags Play
@@ -68,26 +259,622 @@ func searchSong(c *gin.Context) {
 // @Description Stream and play a song
 // @Produce  json
 // @Param param path int true "Song ID"
+// @Param id path int true "Song ID"
+// @Failure 415 {object} controller.APIError
 // @Router /play/{param} [get]
-func playSong(c *gin.Context) {
-	song_handler.PlaySong(c)
+// @Router /api/v1/songs/{id}/play [get]
+func playSong(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.PlaySong
 }
 
 // @Tags Upload
-// @Summary Upload a song
-// @Description Upload a song to the database
+// @Summary Upload songs
+// @Description Upload one or more audio files, or ZIP or TAR archives unpacked into an album folder, which are added to the library under a safe name.
+// @Description A single audio file is answered with its song, anything else with the result of every file.
+// @Accept  multipart/form-data
 // @Produce  json
+// @Param file formData file true "Audio file or archive, may be repeated"
+// @Success 200 {array} upload.Result
+// @Success 201 {object} model.Song
+// @Failure 400 {object} controller.APIError
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 409 {object} controller.APIError
+// @Failure 413 {object} controller.APIError
+// @Failure 415 {object} controller.APIError
+// @Failure 422 {object} controller.APIError
+// @Security BearerAuth
+// @Deprecated
 // @Router /upload [post]
-func uploadSong(c *gin.Context) {
-	song_handler.UploadSong(c)
+func uploadSong(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.UploadSong
+}
+
+// @Tags Upload
+// @Summary Add songs
+// @Description Upload one or more audio files, or ZIP or TAR archives unpacked into an album folder, which are added to the library under a safe name.
+// @Description A single audio file is answered with its song, anything else with the result of every file.
+// @Accept  multipart/form-data
+// @Produce  json
+// @Param file formData file true "Audio file or archive, may be repeated"
+// @Success 200 {array} upload.Result
+// @Success 201 {object} model.Song
+// @Failure 400 {object} controller.APIError
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 409 {object} controller.APIError
+// @Failure 413 {object} controller.APIError
+// @Failure 415 {object} controller.APIError
+// @Failure 422 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/songs [post]
+func createSong(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.UploadSong
+}
+
+// @Tags Get
+// @Summary Get a song by its slug
+// @Produce  json
+// @Param slug path string true "Song slug"
+// @Success 200 {object} model.Song
+// @Failure 404 {object} controller.APIError
+// @Router /api/v1/songs/slug/{slug} [get]
+func getSongBySlug(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.GetSongBySlug
+}
+
+// @Tags Update
+// @Summary Update a song
+// @Description Change the title and tags of a song, leaving out fields keeps them
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Song ID"
+// @Param song body controller.SongPatch true "Fields to change"
+// @Success 200 {object} model.Song
+// @Failure 400 {object} controller.APIError
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 404 {object} controller.APIError
+// @Failure 409 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/songs/{id} [patch]
+func updateSong(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.UpdateSong
 }
 
 // @Tags Remove
 // @Summary Remove a song
-// @Description Remove a song from the database
+// @Description Remove a song and its file. Uploaders may only remove the songs they uploaded, admins any song.
+// @Param id path int true "Song ID"
+// @Success 204
+// @Failure 400 {object} controller.APIError
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 404 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/songs/{id} [delete]
+func deleteSong(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.DeleteSong
+}
+
+// @Tags Upload
+// @Summary Resumable upload options
+// @Description Tell the tus version, extensions and maximum size of resumable uploads
+// @Success 204
+// @Header 204 {string} Tus-Version "Supported tus versions"
+// @Header 204 {string} Tus-Extension "Supported tus extensions"
+// @Header 204 {integer} Tus-Max-Size "Largest upload in bytes"
+// @Router /uploads [options]
+// @Router /api/v1/uploads [options]
+func resumableOptions(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.ResumableOptions
+}
+
+// @Tags Upload
+// @Summary Create a resumable upload
+// @Description Start a tus 1.0 upload of a song or archive, sent in parts with PATCH requests to its location
+// @Param Tus-Resumable header string true "tus version, 1.0.0"
+// @Param Upload-Length header integer true "Size of the upload in bytes"
+// @Param Upload-Metadata header string false "Comma separated keys and base64 encoded values, like filename"
+// @Success 201
+// @Header 201 {string} Location "URL of the upload"
+// @Header 201 {string} Upload-Expires "When the upload is removed if no part arrives"
+// @Failure 400 {object} controller.APIError
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 412 {object} controller.APIError
+// @Failure 413 {object} controller.APIError
+// @Security BearerAuth
+// @Router /uploads [post]
+// @Router /api/v1/uploads [post]
+func createResumable(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.CreateResumable
+}
+
+// @Tags Upload
+// @Summary Resumable upload progress
+// @Description Tell how many bytes of a resumable upload have arrived
+// @Param Tus-Resumable header string true "tus version, 1.0.0"
+// @Param id path string true "Upload ID"
+// @Success 200
+// @Header 200 {integer} Upload-Offset "Bytes received"
+// @Header 200 {integer} Upload-Length "Size of the upload in bytes"
+// @Failure 404 {object} controller.APIError
+// @Router /uploads/{id} [head]
+// @Router /api/v1/uploads/{id} [head]
+func resumableOffset(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.ResumableOffset
+}
+
+// @Tags Upload
+// @Summary Send part of a resumable upload
+// @Description Append the body at Upload-Offset. The last part adds the upload to the library, like POST /upload.
+// @Accept  application/offset+octet-stream
+// @Param Tus-Resumable header string true "tus version, 1.0.0"
+// @Param Upload-Offset header integer true "Bytes received so far"
+// @Param id path string true "Upload ID"
+// @Success 204
+// @Header 204 {integer} Upload-Offset "Bytes received"
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 404 {object} controller.APIError
+// @Failure 409 {object} controller.APIError
+// @Failure 415 {object} controller.APIError
+// @Failure 422 {object} controller.APIError
+// @Failure 423 {object} controller.APIError
+// @Security BearerAuth
+// @Router /uploads/{id} [patch]
+// @Router /api/v1/uploads/{id} [patch]
+func patchResumable(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.PatchResumable
+}
+
+// @Tags Upload
+// @Summary Cancel a resumable upload
+// @Param Tus-Resumable header string true "tus version, 1.0.0"
+// @Param id path string true "Upload ID"
+// @Success 204
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 404 {object} controller.APIError
+// @Security BearerAuth
+// @Router /uploads/{id} [delete]
+// @Router /api/v1/uploads/{id} [delete]
+func deleteResumable(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.DeleteResumable
+}
+
+// @Tags Upload
+// @Summary Get a resumable upload
+// @Description Get the progress of a resumable upload, and what became of its files once it is complete
+// @Produce  json
+// @Param id path string true "Upload ID"
+// @Success 200 {object} upload.Partial
+// @Failure 404 {object} controller.APIError
+// @Router /uploads/{id} [get]
+// @Router /api/v1/uploads/{id} [get]
+func getResumable(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.GetResumable
+}
+
+// @Tags Remove
+// @Summary Remove a song
+// @Description Remove a song from the database. Uploaders may only remove the songs they uploaded, admins any song.
 // @Produce  json
 // @Param param path int true "Song ID"
+// @Param id path int true "Song ID"
+// @Success 200 {object} model.Song
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 404 {object} controller.APIError
+// @Failure 500 {object} controller.APIError
+// @Security BearerAuth
+// @Deprecated
 // @Router /remove/{param} [get]
-func removeSong(c *gin.Context) {
-	song_handler.RemoveSong(c)
+func removeSong(songs *song_handler.SongController) gin.HandlerFunc {
+	return songs.RemoveSong
+}
+
+// @Tags Stations
+// @Summary Get all stations
+// @Description Get every station with what it is playing and what's next
+// @Produce  json
+// @Router /stations [get]
+// @Router /api/v1/stations [get]
+func getStations(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.GetStations
+}
+
+// @Tags Stations
+// @Summary Create a station
+// @Description Create a station playing a playlist, the shuffled library or the songs matching a filter
+// @Accept  json
+// @Produce  json
+// @Param station body song_handler.StationRequest true "Station"
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Security BearerAuth
+// @Router /stations [post]
+// @Router /api/v1/stations [post]
+func createStation(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.CreateStation
+}
+
+// @Tags Stations
+// @Summary Get specified station
+// @Description Get a station with what it is playing and what's next
+// @Produce  json
+// @Param name path string true "Station name"
+// @Router /stations/{name} [get]
+// @Router /api/v1/stations/{name} [get]
+func getStation(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.GetStation
+}
+
+// @Tags Stations
+// @Summary Delete a station
+// @Description Take a station off air and disconnect its listeners
+// @Produce  json
+// @Param name path string true "Station name"
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Security BearerAuth
+// @Router /stations/{name} [delete]
+// @Router /api/v1/stations/{name} [delete]
+func deleteStation(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.DeleteStation
+}
+
+// @Tags Play
+// @Summary Listen to a station
+// @Description Tune in to a station's live stream
+// @Produce  audio/mpeg
+// @Param name path string true "Station name"
+// @Router /stations/{name}/listen [get]
+// @Router /api/v1/stations/{name}/listen [get]
+func listenStation(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.ListenStation
+}
+
+// @Tags Play
+// @Summary Listen to a station over HLS
+// @Description Get a station's live playlist (index.m3u8) or one of its segments (<sequence>.mp3)
+// @Produce  application/vnd.apple.mpegurl
+// @Produce  audio/mpeg
+// @Param name path string true "Station name"
+// @Param file path string true "index.m3u8 or a segment"
+// @Router /stations/{name}/hls/{file} [get]
+// @Router /api/v1/stations/{name}/hls/{file} [get]
+func stationHLS(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.StationHLS
+}
+
+// @Tags Stations
+// @Summary Skip the current track
+// @Description Move a station on to the next track in its queue
+// @Produce  json
+// @Param name path string true "Station name"
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Security BearerAuth
+// @Router /stations/{name}/skip [post]
+// @Router /api/v1/stations/{name}/skip [post]
+func skipTrack(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.SkipTrack
+}
+
+// @Tags Stations
+// @Summary Pause a station
+// @Description Pause a station, sending silence or holding its listeners until it resumes
+// @Produce  json
+// @Param name path string true "Station name"
+// @Param mode query string false "silence (default) or hold"
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Security BearerAuth
+// @Router /stations/{name}/pause [post]
+// @Router /api/v1/stations/{name}/pause [post]
+func pauseStation(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.PauseStation
+}
+
+// @Tags Stations
+// @Summary Resume a station
+// @Description Continue playback where a station was paused
+// @Produce  json
+// @Param name path string true "Station name"
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Security BearerAuth
+// @Router /stations/{name}/resume [post]
+// @Router /api/v1/stations/{name}/resume [post]
+func resumeStation(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.ResumeStation
+}
+
+// @Tags Stations
+// @Summary Get the queue
+// @Description Get the tracks planned on a station after the current one
+// @Produce  json
+// @Param name path string true "Station name"
+// @Router /stations/{name}/queue [get]
+// @Router /api/v1/stations/{name}/queue [get]
+func getQueue(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.GetQueue
+}
+
+// @Tags Stations
+// @Summary Enqueue a song
+// @Description Plan a song by its ID or title after the other requested songs
+// @Accept  json
+// @Produce  json
+// @Param name path string true "Station name"
+// @Param song body song_handler.EnqueueRequest true "Song"
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Security BearerAuth
+// @Router /stations/{name}/queue [post]
+// @Router /api/v1/stations/{name}/queue [post]
+func enqueueSong(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.EnqueueSong
+}
+
+// @Tags Stations
+// @Summary Remove a queue entry
+// @Description Take a planned track out of a station's queue
+// @Produce  json
+// @Param name path string true "Station name"
+// @Param entry path int true "Queue entry ID"
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Security BearerAuth
+// @Router /stations/{name}/queue/{entry} [delete]
+// @Router /api/v1/stations/{name}/queue/{entry} [delete]
+func removeQueueEntry(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.RemoveQueueEntry
+}
+
+// @Tags Stations
+// @Summary Move a queue entry
+// @Description Move a planned track to another position in a station's queue
+// @Accept  json
+// @Produce  json
+// @Param name path string true "Station name"
+// @Param entry path int true "Queue entry ID"
+// @Param position body song_handler.MoveRequest true "New position, 0 plays next"
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Security BearerAuth
+// @Router /stations/{name}/queue/{entry} [patch]
+// @Router /api/v1/stations/{name}/queue/{entry} [patch]
+func moveQueueEntry(stations *song_handler.StationController) gin.HandlerFunc {
+	return stations.MoveQueueEntry
+}
+
+// @Tags Library
+// @Summary Get all artists
+// @Description Get every artist in the library, ordered by name
+// @Produce  json
+// @Router /artists [get]
+// @Router /api/v1/artists [get]
+func getArtists(library *song_handler.LibraryController) gin.HandlerFunc {
+	return library.GetArtists
+}
+
+// @Tags Library
+// @Summary Get the albums of an artist
+// @Description Get the albums released by an artist, ordered by year
+// @Produce  json
+// @Param id path int true "Artist ID"
+// @Router /artists/{id}/albums [get]
+// @Router /api/v1/artists/{id}/albums [get]
+func getArtistAlbums(library *song_handler.LibraryController) gin.HandlerFunc {
+	return library.GetArtistAlbums
+}
+
+// @Tags Library
+// @Summary Get all albums
+// @Description Get every album in the library, ordered by title
+// @Produce  json
+// @Router /albums [get]
+// @Router /api/v1/albums [get]
+func getAlbums(library *song_handler.LibraryController) gin.HandlerFunc {
+	return library.GetAlbums
+}
+
+// @Tags Library
+// @Summary Get the tracks of an album
+// @Description Get the songs on an album ordered by disc and track number, with their featured artists
+// @Produce  json
+// @Param id path int true "Album ID"
+// @Router /albums/{id}/tracks [get]
+// @Router /api/v1/albums/{id}/tracks [get]
+func getAlbumTracks(library *song_handler.LibraryController) gin.HandlerFunc {
+	return library.GetAlbumTracks
+}
+
+// @Tags Library
+// @Summary Get the cover of an album
+// @Description Get an album's cover image, optionally scaled down to fit a square of the given size
+// @Produce  image/jpeg
+// @Produce  image/png
+// @Param id path int true "Album ID"
+// @Param size query int false "Size in pixels, between 16 and 2048"
+// @Router /albums/{id}/cover [get]
+// @Router /api/v1/albums/{id}/cover [get]
+func albumCover(library *song_handler.LibraryController) gin.HandlerFunc {
+	return library.AlbumCover
+}
+
+// @Tags Auth
+// @Summary Register
+// @Description Create an account. Only the first account can be created, unless registration is open.
+// @Accept  json
+// @Produce  json
+// @Param credentials body controller.Credentials true "Username and password"
+// @Success 201 {object} model.User
+// @Failure 400 {object} controller.APIError
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 409 {object} controller.APIError
+// @Router /api/v1/auth/register [post]
+func register(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.Register
+}
+
+// @Tags Auth
+// @Summary Log in
+// @Description Get a session token to send in the Authorization header, as "Bearer <token>"
+// @Accept  json
+// @Produce  json
+// @Param credentials body controller.Credentials true "Username and password"
+// @Success 200 {object} controller.Session
+// @Failure 401 {object} controller.APIError
+// @Router /api/v1/auth/login [post]
+func login(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.Login
+}
+
+// @Tags Auth
+// @Summary Current user
+// @Description Get the user who sent the request
+// @Produce  json
+// @Success 200 {object} model.User
+// @Failure 401 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/auth/me [get]
+func me(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.Me
+}
+
+// @Tags Auth
+// @Summary List API keys
+// @Description Get the API keys of the user who sent the request, without the keys themselves
+// @Produce  json
+// @Success 200 {array} model.APIKey
+// @Failure 401 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/auth/keys [get]
+func getAPIKeys(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.GetAPIKeys
+}
+
+// @Tags Auth
+// @Summary Create an API key
+// @Description Create an API key for scripts, sent in the Authorization or X-API-Key header. The key is only shown in this response.
+// @Accept  json
+// @Produce  json
+// @Param key body object true "Name of the key, as {\"name\": \"backup script\"}"
+// @Success 201 {object} controller.NewAPIKey
+// @Failure 400 {object} controller.APIError
+// @Failure 401 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/auth/keys [post]
+func createAPIKey(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.CreateAPIKey
+}
+
+// @Tags Auth
+// @Summary Delete an API key
+// @Description Delete an API key of the user who sent the request
+// @Param id path int true "API key ID"
+// @Success 204
+// @Failure 401 {object} controller.APIError
+// @Failure 404 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/auth/keys/{id} [delete]
+func deleteAPIKey(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.DeleteAPIKey
+}
+
+// @Tags Users
+// @Summary List roles
+// @Description Get every role with its permissions
+// @Produce  json
+// @Success 200 {array} auth.Role
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/roles [get]
+func getRoles(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.GetRoles
+}
+
+// @Tags Users
+// @Summary List users
+// @Description Get every user with their role
+// @Produce  json
+// @Success 200 {array} model.User
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/users [get]
+func getUsers(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.GetUsers
+}
+
+// @Tags Users
+// @Summary Create a user
+// @Description Create a user with a role, listener if none is given, whether or not registration is open
+// @Accept  json
+// @Produce  json
+// @Param user body controller.NewUser true "Username, password and role"
+// @Success 201 {object} model.User
+// @Failure 400 {object} controller.APIError
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 409 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/users [post]
+func createUser(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.CreateUser
+}
+
+// @Tags Users
+// @Summary Get a user
+// @Description Get the user with the ID
+// @Produce  json
+// @Param id path int true "User ID"
+// @Success 200 {object} model.User
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 404 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/users/{id} [get]
+func getUser(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.GetUser
+}
+
+// @Tags Users
+// @Summary Change the role of a user
+// @Description Give the user with the ID another role. The last admin keeps their role.
+// @Accept  json
+// @Produce  json
+// @Param id path int true "User ID"
+// @Param user body controller.UserPatch true "Role"
+// @Success 200 {object} model.User
+// @Failure 400 {object} controller.APIError
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 404 {object} controller.APIError
+// @Failure 409 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/users/{id} [patch]
+func updateUser(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.UpdateUser
+}
+
+// @Tags Users
+// @Summary Delete a user
+// @Description Delete the user with the ID and their API keys, keeping the songs they uploaded. The last admin can't be deleted.
+// @Param id path int true "User ID"
+// @Success 204
+// @Failure 401 {object} controller.APIError
+// @Failure 403 {object} controller.APIError
+// @Failure 404 {object} controller.APIError
+// @Failure 409 {object} controller.APIError
+// @Security BearerAuth
+// @Router /api/v1/users/{id} [delete]
+func deleteUser(accounts *song_handler.AuthController) gin.HandlerFunc {
+	return accounts.DeleteUser
 }
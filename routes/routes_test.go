@@ -1,34 +1,186 @@
 package routes
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"maps"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"infiniti.com/config"
+	song_handler "infiniti.com/controller"
+	"infiniti.com/internal/audiopipeline"
+	"infiniti.com/internal/auth"
+	"infiniti.com/internal/database"
+	"infiniti.com/internal/search"
+	"infiniti.com/internal/storage"
+	"infiniti.com/internal/suggest"
+	"infiniti.com/internal/upload"
+	model "infiniti.com/model"
 )
 
-func TestSetupRouter(t *testing.T) {
+const TEST_SONG = "../resources/test_songs/Recording.mp3"
 
-	r := SetupRouter()
+type testServer struct {
+	router  *gin.Engine
+	songs   *database.ObservedRepository
+	library *database.MemorySongRepository
+	store   storage.Backend
+	users   *database.MemoryUserRepository
+	auth    *auth.Authenticator
+	// key is the API key of the test user, sent with every request that has no credentials of its own
+	key string
+}
 
-	req := httptest.NewRequest("GET", "/", nil)
-	w := httptest.NewRecorder()
-	r.ServeHTTP(w, req)
+// setupTestServer creates a router backed by an in-memory repository and a
+// temporary song directory, seeded with the test song.
+func setupTestServer(t *testing.T) *testServer {
+	gin.SetMode(gin.TestMode)
 
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status %d; got %d", http.StatusOK, w.Code)
+	cfg := config.Default()
+	cfg.SongsDir = t.TempDir()
+	cfg.Upload.ResumableDir = t.TempDir()
+
+	store, err := storage.NewLocal(cfg.SongsDir)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+
+	content, err := os.ReadFile(TEST_SONG)
+	if err != nil {
+		t.Fatalf("Failed to read test song: %v", err)
+	}
+	err = store.Put("Recording.mp3", bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to store test song: %v", err)
+	}
+
+	library := database.NewMemorySongRepository()
+	database.Seed(library, store)
+
+	songs := database.Observe(library)
+	index := search.NewIndex()
+	songs.Listen(index)
+	err = index.Load(songs)
+	if err != nil {
+		t.Fatalf("Failed to build the search index: %v", err)
+	}
+	suggester := suggest.New()
+	songs.Listen(suggester)
+	err = suggester.Load(songs)
+	if err != nil {
+		t.Fatalf("Failed to load suggestions: %v", err)
+	}
+
+	cfg.Auth.Secret = strings.Repeat("s", 32)
+	users := database.NewMemoryUserRepository()
+	authenticator, err := auth.New(users, cfg.Auth)
+	if err != nil {
+		t.Fatalf("Failed to set up authentication: %v", err)
+	}
+	// the test user is created directly, hashing a password for every test would slow them down
+	user := &model.User{Username: "tester", Role: auth.ADMIN}
+	err = users.CreateUser(user)
+	if err != nil {
+		t.Fatalf("Failed to create the test user: %v", err)
 	}
+	key, _, err := authenticator.CreateKey(user, "tests")
+	if err != nil {
+		t.Fatalf("Failed to create an api key: %v", err)
+	}
+
+	return &testServer{
+		router: SetupRouter(cfg,
+			song_handler.NewSongController(songs, store, cfg),
+			song_handler.NewStationController(songs, store, cfg),
+			song_handler.NewLibraryController(library, store),
+			song_handler.NewSearchController(index, suggester, songs),
+			song_handler.NewAuthController(authenticator),
+		),
+		songs:   songs,
+		library: library,
+		store:   store,
+		users:   users,
+		auth:    authenticator,
+		key:     key,
+	}
+}
+
+// request sends the request as the test user, unless it has credentials of its own.
+func (ts *testServer) request(req *http.Request) *httptest.ResponseRecorder {
+	if req.Header.Get("Authorization") == "" && req.Header.Get("X-API-Key") == "" {
+		req.Header.Set("Authorization", "Bearer "+ts.key)
+	}
+	return ts.anonymous(req)
 }
 
-func TestHomeScreenHandler(t *testing.T) {
+// as returns a test server that sends requests as the user with the role, who is created for it.
+func (ts *testServer) as(t *testing.T, username string, role string) (*testServer, *model.User) {
+	user := &model.User{Username: username, Role: role}
+	err := ts.users.CreateUser(user)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", username, err)
+	}
+	key, _, err := ts.auth.CreateKey(user, "tests")
+	if err != nil {
+		t.Fatalf("Failed to create an api key: %v", err)
+	}
 
-	r := gin.Default()
-	r.GET("/", homeScreen)
+	other := *ts
+	other.key = key
+	return &other, user
+}
 
-	req := httptest.NewRequest("GET", "/", nil)
+// anonymous sends the request as it is, without credentials unless it has some.
+func (ts *testServer) anonymous(req *http.Request) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
-	r.ServeHTTP(w, req)
+	ts.router.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *testServer) get(path string) *httptest.ResponseRecorder {
+	return ts.request(httptest.NewRequest("GET", path, nil))
+}
+
+// createStation creates a station from the JSON body and takes it off air again when the test ends.
+func (ts *testServer) createStation(t *testing.T, body string) *httptest.ResponseRecorder {
+	w := ts.request(httptest.NewRequest("POST", "/stations", strings.NewReader(body)))
+	if w.Code == http.StatusCreated {
+		name := decode[audiopipeline.StationInfo](t, w).Name
+		t.Cleanup(func() { ts.request(httptest.NewRequest("DELETE", "/stations/"+name, nil)) })
+	}
+	return w
+}
+
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	var value T
+	err := json.Unmarshal(w.Body.Bytes(), &value)
+	if err != nil {
+		t.Fatalf("Failed to decode response %q: %v", w.Body.String(), err)
+	}
+	return value
+}
+
+func TestSetupRouter(t *testing.T) {
+	ts := setupTestServer(t)
+
+	w := ts.get("/")
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status %d; got %d", http.StatusOK, w.Code)
@@ -36,76 +188,1600 @@ func TestHomeScreenHandler(t *testing.T) {
 }
 
 func TestGetSongs(t *testing.T) {
-	var path = "/songs"
-
-	r := gin.Default()
-	r.GET(path, homeScreen)
+	ts := setupTestServer(t)
 
-	req := httptest.NewRequest("GET", path, nil)
-	w := httptest.NewRecorder()
-	r.ServeHTTP(w, req)
+	w := ts.get("/songs")
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status %d; got %d", http.StatusOK, w.Code)
 	}
+
+	songs := decode[[]model.Song](t, w)
+	if len(songs) != 1 || songs[0].Title != "Recording" {
+		t.Errorf("Expected the seeded song; got %+v", songs)
+	}
+}
+
+func TestGetSongsPages(t *testing.T) {
+	ts := setupTestServer(t)
+	for i := 1; i <= 4; i++ {
+		ts.songs.Create(&model.Song{Title: fmt.Sprintf("Song %d", i), Artist: "Band", Duration: float64(10 * i), FileType: "ogg", Path: fmt.Sprintf("%d.ogg", i)})
+	}
+
+	titles := func(w *httptest.ResponseRecorder) []string {
+		var titles []string
+		for _, song := range decode[[]model.Song](t, w) {
+			titles = append(titles, song.Title)
+		}
+		return titles
+	}
+
+	w := ts.get("/api/v1/songs?artist=band&sort=-duration&limit=3&offset=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d; got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if found := titles(w); strings.Join(found, ",") != "Song 3,Song 2,Song 1" {
+		t.Errorf("Expected songs 3 to 1; got %v", found)
+	}
+	if total := w.Header().Get("X-Total-Count"); total != "4" {
+		t.Errorf("Expected a total count of 4; got %q", total)
+	}
+	links := strings.Join(w.Header().Values("Link"), ", ")
+	for _, link := range []string{`offset=0&sort=-duration>; rel="first"`, `offset=0&sort=-duration>; rel="prev"`, `offset=3&sort=-duration>; rel="last"`} {
+		if !strings.Contains(links, link) {
+			t.Errorf("Expected the Link header to hold %s; got %s", link, links)
+		}
+	}
+	if strings.Contains(links, `rel="next"`) {
+		t.Errorf("Expected no next page; got %s", links)
+	}
+
+	// following the next links by cursor goes through every song
+	var paged []string
+	next := "/songs?cursor=&limit=2&file_type=ogg,mp3"
+	for next != "" {
+		w = ts.get(next)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for %s; got %d: %s", http.StatusOK, next, w.Code, w.Body.String())
+		}
+		paged = append(paged, titles(w)...)
+
+		next = ""
+		for _, link := range w.Header().Values("Link") {
+			if target, ok := strings.CutSuffix(link, `>; rel="next"`); ok {
+				next = strings.TrimPrefix(target, "<")
+			}
+		}
+	}
+	if strings.Join(paged, ",") != "Recording,Song 1,Song 2,Song 3,Song 4" {
+		t.Errorf("Expected every song once; got %v", paged)
+	}
+	if w.Header().Get("Deprecation") != "true" {
+		t.Errorf("Expected the legacy route to stay deprecated")
+	}
+
+	w = ts.get("/api/v1/songs?fields=id,title,file_type&min_duration=35")
+	fields := decode[[]map[string]any](t, w)
+	if len(fields) != 1 || len(fields[0]) != 3 || fields[0]["Title"] != "Song 4" || fields[0]["FileType"] != "ogg" {
+		t.Errorf("Expected the id, title and file type of song 4; got %v", fields)
+	}
+
+	invalid := []string{"limit=0", "limit=501", "offset=-1", "min_duration=long", "fields=rating", "sort=genre", "cursor=!", "cursor=abc&offset=2"}
+	for _, query := range invalid {
+		w = ts.get("/api/v1/songs?" + query)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s; got %d", http.StatusBadRequest, query, w.Code)
+		}
+		if code := decode[song_handler.APIError](t, w).Code; code != "bad_request" {
+			t.Errorf("Expected the error code bad_request for %s; got %q", query, code)
+		}
+	}
+
+	// a page without songs is no error
+	w = ts.get("/api/v1/songs?artist=nobody")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("Expected an empty list; got %d %s", w.Code, w.Body.String())
+	}
 }
 
 func TestGetSpecifiedSong(t *testing.T) {
-	var path = "/songs/e"
+	ts := setupTestServer(t)
 
-	r := gin.Default()
-	r.GET(path, homeScreen)
+	tests := map[string]int{
+		"/songs/1":      http.StatusOK,
+		"/songs/record": http.StatusOK,
+		"/songs/2":      http.StatusNotFound,
+		"/songs/zzz":    http.StatusNotFound,
+	}
 
-	req := httptest.NewRequest("GET", path, nil)
-	w := httptest.NewRecorder()
-	r.ServeHTTP(w, req)
+	for path, status := range tests {
+		w := ts.get(path)
+		if w.Code != status {
+			t.Errorf("%s: expected status %d; got %d", path, status, w.Code)
+		}
+	}
+}
 
+func TestSearchSong(t *testing.T) {
+	ts := setupTestServer(t)
+
+	w := ts.get("/search/cord")
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status %d; got %d", http.StatusOK, w.Code)
 	}
+	if songs := decode[[]model.Song](t, w); len(songs) != 1 {
+		t.Errorf("Expected 1 song to match; got %+v", songs)
+	}
+
+	w = ts.get("/search/nothing")
+	if songs := decode[[]model.Song](t, w); len(songs) != 0 {
+		t.Errorf("Expected no songs to match; got %+v", songs)
+	}
 }
 
-func TestSearchSong(t *testing.T) {
-	var path = "/search/e"
+func TestSearch(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.songs.Create(&model.Song{Title: "Other", Artist: "Someone", Lyrics: "a recording of a recording", FileType: "mp3", Path: "other.mp3"})
 
-	r := gin.Default()
-	r.GET(path, homeScreen)
+	w := ts.get("/api/v1/search?q=recordng")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d; got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	results := decode[[]search.Result](t, w)
+	if len(results) != 2 || results[0].Song.Title != "Recording" || results[1].Song.Title != "Other" {
+		t.Errorf("Expected the title to rank before the lyrics; got %+v", results)
+	}
+	if highlight := results[1].Highlights["lyrics"]; highlight != "a <mark>recording</mark> of a <mark>recording</mark>" {
+		t.Errorf("Expected the lyrics to be highlighted; got %q", highlight)
+	}
+	if total := w.Header().Get("X-Total-Count"); total != "2" {
+		t.Errorf("Expected a total count of 2; got %q", total)
+	}
 
-	req := httptest.NewRequest("GET", path, nil)
-	w := httptest.NewRecorder()
-	r.ServeHTTP(w, req)
+	// changes made through the API reach the index
+	w = ts.request(httptest.NewRequest("PATCH", "/api/v1/songs/2", strings.NewReader(`{"Artist": "Queen"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d; got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if results := decode[[]search.Result](t, ts.get("/api/v1/search?q=artist:queen")); len(results) != 1 || results[0].Song.Title != "Other" {
+		t.Errorf("Expected the updated song to be found by its artist; got %+v", results)
+	}
+	ts.request(httptest.NewRequest("DELETE", "/api/v1/songs/2", nil))
+	if results := decode[[]search.Result](t, ts.get("/api/v1/search?q=queen")); len(results) != 0 {
+		t.Errorf("Expected the deleted song to be gone; got %+v", results)
+	}
+
+	for _, query := range []string{"", "q=", "q=queen&limit=0"} {
+		if w = ts.get("/api/v1/search?" + query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %q; got %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.songs.Create(&model.Song{Title: "Record Store", Artist: "Recorders", FileType: "mp3", Path: "store.mp3"})
 
+	w := ts.get("/api/v1/suggest?q=rec")
 	if w.Code != http.StatusOK {
-		t.Errorf("Expected status %d; got %d", http.StatusOK, w.Code)
+		t.Fatalf("Expected status %d; got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	suggestions := decode[[]suggest.Suggestion](t, w)
+	if len(suggestions) != 3 || suggestions[0].Type != suggest.ARTIST || suggestions[0].Name != "Recorders" {
+		t.Errorf("Expected the artist, then both songs; got %+v", suggestions)
+	}
+
+	if suggestions := decode[[]suggest.Suggestion](t, ts.get("/api/v1/suggest?q=rec&limit=1")); len(suggestions) != 1 {
+		t.Errorf("Expected 1 suggestion; got %+v", suggestions)
+	}
+	if suggestions := decode[[]suggest.Suggestion](t, ts.get("/api/v1/suggest?q=")); len(suggestions) != 0 {
+		t.Errorf("Expected no suggestions for nothing typed; got %+v", suggestions)
+	}
+	if w = ts.get("/api/v1/suggest?q=rec&limit=51"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d; got %d", http.StatusBadRequest, w.Code)
 	}
 }
 
 func TestPlaySong(t *testing.T) {
-	var path = "/play/e"
+	ts := setupTestServer(t)
 
-	r := gin.Default()
-	r.GET(path, homeScreen)
+	w := ts.get("/play/zzz")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d; got %d", http.StatusNotFound, w.Code)
+	}
 
-	req := httptest.NewRequest("GET", path, nil)
-	w := httptest.NewRecorder()
-	r.ServeHTTP(w, req)
+	// the stream never ends, so the listener hangs up after a while
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
 
+	w = ts.request(httptest.NewRequest("GET", "/play/1", nil).WithContext(ctx))
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status %d; got %d", http.StatusOK, w.Code)
 	}
+	if w.Header().Get("Content-Type") != "audio/mp3" {
+		t.Errorf("Expected an audio content type; got %s", w.Header().Get("Content-Type"))
+	}
+	if w.Body.Len() == 0 {
+		t.Errorf("Expected audio to be streamed")
+	}
 }
 
-func TestRemoveSong(t *testing.T) {
-	var path = "/remove/e"
+func TestPlaySongNotMP3(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.store.Put("Lossless.flac", strings.NewReader("fLaC\x00\x00\x00\x22"))
+	song := &model.Song{Title: "Lossless", FileType: "flac", Path: "Lossless.flac"}
+	ts.songs.Create(song)
+
+	// a listener would wait for audio that never comes, so it is refused up front
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, path := range []string{"/play/%d", "/api/v1/songs/%d/play", "/api/v1/songs/%d/hls/index.m3u8"} {
+		w := ts.request(httptest.NewRequest("GET", fmt.Sprintf(path, song.ID), nil).WithContext(ctx))
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("%s: expected status %d; got %d", path, http.StatusUnsupportedMediaType, w.Code)
+		}
+		if code := decode[song_handler.APIError](t, w).Code; code != "unsupported_media_type" {
+			t.Errorf("%s: expected error code unsupported_media_type; got %q", path, code)
+		}
+	}
 
-	r := gin.Default()
-	r.GET(path, homeScreen)
+	// it can still be streamed as a file
+	if w := ts.get(fmt.Sprintf("/api/v1/songs/%d/stream", song.ID)); w.Code != http.StatusOK {
+		t.Errorf("Expected the song to be streamed; got %d", w.Code)
+	}
+}
 
-	req := httptest.NewRequest("GET", path, nil)
-	w := httptest.NewRecorder()
-	r.ServeHTTP(w, req)
+func TestPlayMissingSong(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.store.Delete("Recording.mp3")
+	database.Seed(ts.songs, ts.store)
+
+	w := ts.get("/api/v1/songs/1")
+	if song := decode[model.Song](t, w); !song.Missing {
+		t.Errorf("Expected the song to be marked as missing; got %+v", song)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	w = ts.request(httptest.NewRequest("GET", "/api/v1/songs/1/play", nil).WithContext(ctx))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a song missing its file; got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// upload posts the content as a multipart form with the file in field.
+func (ts *testServer) upload(field string, filename string, content []byte) *httptest.ResponseRecorder {
+	return ts.uploadFiles(field, uploadFile{filename, content})
+}
+
+type uploadFile struct {
+	name    string
+	content []byte
+}
+
+// uploadFiles posts the files as a multipart form, each in a field of its own by the same name.
+func (ts *testServer) uploadFiles(field string, files ...uploadFile) *httptest.ResponseRecorder {
+	return ts.uploadTo("/upload", field, files...)
+}
+
+// uploadTo posts the files like uploadFiles does, to target.
+func (ts *testServer) uploadTo(target string, field string, files ...uploadFile) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	for _, file := range files {
+		part, _ := form.CreateFormFile(field, file.name)
+		part.Write(file.content)
+	}
+	form.Close()
+
+	req := httptest.NewRequest("POST", target, body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return ts.request(req)
+}
+
+// variant returns a copy of the song with an ID3v1 tag appended, so it has different content.
+func variant(content []byte, title string) []byte {
+	tag := make([]byte, 128)
+	copy(tag, "TAG"+title)
+	return append(bytes.Clone(content), tag...)
+}
+
+func TestUploadSong(t *testing.T) {
+	ts := setupTestServer(t)
+
+	content, err := os.ReadFile(TEST_SONG)
+	if err != nil {
+		t.Fatalf("Failed to read test song: %v", err)
+	}
+
+	w := ts.upload("file", "Uploaded.mp3", variant(content, "Uploaded"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d; got %d %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	song := decode[model.Song](t, w)
+	if song.ID == 0 || song.Title != "Uploaded" || song.Path != "Uploaded.mp3" || song.Duration <= 0 {
+		t.Errorf("Expected the created song in the response; got %+v", song)
+	}
+	if _, err := ts.store.Stat("Uploaded.mp3"); err != nil {
+		t.Errorf("Expected the uploaded song to be stored; got %v", err)
+	}
+
+	w = ts.uploadTo("/api/v1/songs", "file", uploadFile{"Created.mp3", variant(content, "Created")})
+	if w.Code != http.StatusCreated || decode[model.Song](t, w).Slug != "created" {
+		t.Errorf("Expected the song to be created through /api/v1; got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUploadSongNames(t *testing.T) {
+	ts := setupTestServer(t)
+	content, _ := os.ReadFile(TEST_SONG)
+
+	tests := []struct {
+		filename string
+		path     string
+	}{
+		{"../../../etc/evil.mp3", "evil.mp3"},
+		{`C:\Users\guest\Music\Windows.mp3`, "Windows.mp3"},
+		{"Recording.mp3", "Recording (2).mp3"},
+		{"recording.mp3", "recording (3).mp3"},
+		{"No Extension", "No Extension.mp3"},
+		{"Wrong.wav", "Wrong.mp3"},
+		{"..", "upload.mp3"},
+	}
+
+	for _, test := range tests {
+		w := ts.upload("file", test.filename, variant(content, test.filename))
+		if w.Code != http.StatusCreated {
+			t.Errorf("%s: expected status %d; got %d %s", test.filename, http.StatusCreated, w.Code, w.Body.String())
+			continue
+		}
+		if song := decode[model.Song](t, w); song.Path != test.path {
+			t.Errorf("%s: expected the song to be stored as %q; got %q", test.filename, test.path, song.Path)
+		}
+	}
+}
+
+func TestUploadSongRejected(t *testing.T) {
+	ts := setupTestServer(t)
+
+	tests := map[string]struct {
+		field   string
+		content []byte
+		status  int
+	}{
+		"missing file": {"other", []byte("ID3"), http.StatusBadRequest},
+		"not audio":    {"file", []byte("#!/bin/sh\nrm -rf /\n"), http.StatusUnsupportedMediaType},
+		"unreadable":   {"file", []byte("ID3 but nothing else"), http.StatusUnprocessableEntity},
+		"too large":    {"file", append([]byte("ID3"), make([]byte, config.Default().Upload.MaxFileSize)...), http.StatusRequestEntityTooLarge},
+	}
+
+	for name, test := range tests {
+		w := ts.upload(test.field, "Rejected.mp3", test.content)
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d; got %d", name, test.status, w.Code)
+		}
+		if message := decode[map[string]string](t, w)["message"]; message == "" {
+			t.Errorf("%s: expected a JSON error message", name)
+		}
+	}
+
+	if _, err := ts.store.Stat("Rejected.mp3"); err == nil {
+		t.Errorf("Expected nothing to be stored for rejected uploads")
+	}
+	if songs, _ := ts.songs.List(); len(songs) != 1 {
+		t.Errorf("Expected no songs to be added; got %+v", songs)
+	}
+}
+
+func TestUploadSongDuplicate(t *testing.T) {
+	ts := setupTestServer(t)
+	content, _ := os.ReadFile(TEST_SONG)
+
+	w := ts.upload("file", "Copy.mp3", content)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected status %d; got %d %s", http.StatusConflict, w.Code, w.Body.String())
+	}
+	if song := decode[struct{ Details struct{ Song model.Song } }](t, w).Details.Song; song.ID != 1 {
+		t.Errorf("Expected the song already in the library; got %+v", song)
+	}
+	if _, err := ts.store.Stat("Copy.mp3"); err == nil {
+		t.Errorf("Expected the duplicate not to be stored")
+	}
+}
+
+func TestUploadSongs(t *testing.T) {
+	ts := setupTestServer(t)
+	content, _ := os.ReadFile(TEST_SONG)
+
+	w := ts.uploadFiles("file",
+		uploadFile{"One.mp3", variant(content, "One")},
+		uploadFile{"Two.mp3", variant(content, "Two")},
+		uploadFile{"Again.mp3", content},
+		uploadFile{"notes.txt", []byte("liner notes")},
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d; got %d %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	results := decode[[]upload.Result](t, w)
+	expected := []struct{ file, status, path string }{
+		{"One.mp3", upload.CREATED, "One.mp3"},
+		{"Two.mp3", upload.CREATED, "Two.mp3"},
+		{"Again.mp3", upload.DUPLICATE, "Recording.mp3"},
+		{"notes.txt", upload.REJECTED, ""},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results; got %+v", len(expected), results)
+	}
+	for i, result := range results {
+		path := ""
+		if result.Song != nil {
+			path = result.Song.Path
+		}
+		if result.File != expected[i].file || result.Status != expected[i].status || path != expected[i].path {
+			t.Errorf("Expected %+v; got %+v", expected[i], result)
+		}
+	}
+	if results[3].Reason != upload.ErrNotAudio.Error() {
+		t.Errorf("Expected the rejected file to have a reason; got %q", results[3].Reason)
+	}
+}
+
+func TestUploadAlbum(t *testing.T) {
+	content, _ := os.ReadFile(TEST_SONG)
+	var picture bytes.Buffer
+	png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 32, 32)))
+
+	// the cover comes last, so songs stored before it are given it afterwards
+	files := []uploadFile{
+		{"Album/CD1/01 First.mp3", variant(content, "First")},
+		{"Album/02 Second.mp3", variant(content, "Second")},
+		{"Album/03 Same.mp3", content},
+		{"Album/notes.txt", []byte("liner notes")},
+		{"__MACOSX/Album/._02 Second.mp3", []byte("resource fork")},
+		{"Album/cover.png", picture.Bytes()},
+	}
+
+	archives := map[string]func([]uploadFile) []byte{
+		"Album.zip":    zipArchive,
+		"Album.tar":    tarArchive,
+		"Album.tar.gz": func(files []uploadFile) []byte { return gzipped(tarArchive(files)) },
+	}
+
+	for filename, archive := range archives {
+		ts := setupTestServer(t)
+
+		w := ts.upload("file", filename, archive(files))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d; got %d %s", filename, http.StatusOK, w.Code, w.Body.String())
+			continue
+		}
+
+		statuses := make(map[string]string)
+		for _, result := range decode[[]upload.Result](t, w) {
+			statuses[result.File] = result.Status
+			if result.Status == upload.CREATED && result.Song != nil && (!strings.HasPrefix(result.Song.Path, "Album/") || result.Song.CoverHash == "") {
+				t.Errorf("%s: expected %s to be stored in the album folder with its cover; got %+v", filename, result.File, result.Song)
+			}
+		}
+		expected := map[string]string{
+			filename + "/Album/CD1/01 First.mp3": upload.CREATED,
+			filename + "/Album/02 Second.mp3":    upload.CREATED,
+			filename + "/Album/03 Same.mp3":      upload.DUPLICATE,
+			filename + "/Album/notes.txt":        upload.REJECTED,
+			filename + "/Album/cover.png":        upload.CREATED,
+		}
+		if !maps.Equal(statuses, expected) {
+			t.Errorf("%s: expected the results %v; got %v", filename, expected, statuses)
+		}
+
+		for _, name := range []string{"Album/01 First.mp3", "Album/02 Second.mp3", "Album/cover.png"} {
+			if _, err := ts.store.Stat(name); err != nil {
+				t.Errorf("%s: expected %s to be stored; got %v", filename, name, err)
+			}
+		}
+		if songs, _ := ts.songs.List(); len(songs) != 3 {
+			t.Errorf("%s: expected 2 songs to be added; got %+v", filename, songs)
+		}
+	}
+}
+
+func zipArchive(files []uploadFile) []byte {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, file := range files {
+		entry, _ := archive.Create(file.name)
+		entry.Write(file.content)
+	}
+	archive.Close()
+	return buffer.Bytes()
+}
+
+func tarArchive(files []uploadFile) []byte {
+	var buffer bytes.Buffer
+	archive := tar.NewWriter(&buffer)
+	for _, file := range files {
+		archive.WriteHeader(&tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.content))})
+		archive.Write(file.content)
+	}
+	archive.Close()
+	return buffer.Bytes()
+}
+
+func gzipped(content []byte) []byte {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	gz.Write(content)
+	gz.Close()
+	return buffer.Bytes()
+}
+
+// tus sends a request of the tus protocol for a resumable upload.
+func (ts *testServer) tus(method string, target string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return ts.request(req)
+}
+
+func TestResumableUpload(t *testing.T) {
+	ts := setupTestServer(t)
+	content, _ := os.ReadFile(TEST_SONG)
+	content = variant(content, "Resumed")
+	half := len(content) / 2
+
+	w := ts.tus("OPTIONS", "/uploads", nil, nil)
+	if w.Code != http.StatusNoContent || w.Header().Get("Tus-Version") != "1.0.0" || !strings.Contains(w.Header().Get("Tus-Extension"), "creation") {
+		t.Errorf("Expected the supported tus version and extensions; got %d %v", w.Code, w.Header())
+	}
+
+	req := httptest.NewRequest("POST", "/uploads", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	if w := ts.request(req); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status %d without a tus version; got %d", http.StatusPreconditionFailed, w.Code)
+	}
+
+	w = ts.tus("POST", "/uploads", map[string]string{"Upload-Length": strconv.Itoa(len(content)), "Upload-Metadata": "filename UmVzdW1lZC5tcDM=,is_confidential"}, nil)
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusCreated || !strings.HasPrefix(location, "/uploads/") || w.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("Expected the upload to be created; got %d %v", w.Code, w.Header())
+	}
 
+	patch := func(offset int, part []byte) *httptest.ResponseRecorder {
+		return ts.tus("PATCH", location, map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": strconv.Itoa(offset)}, part)
+	}
+	offset := func() string {
+		w := ts.tus("HEAD", location, nil, nil)
+		if w.Code != http.StatusOK || w.Header().Get("Upload-Length") != strconv.Itoa(len(content)) {
+			t.Errorf("Expected the progress of the upload; got %d %v", w.Code, w.Header())
+		}
+		return w.Header().Get("Upload-Offset")
+	}
+
+	if offset() != "0" {
+		t.Errorf("Expected nothing to have arrived yet")
+	}
+	if w := patch(0, content[:half]); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Errorf("Expected the first half to be accepted; got %d %v", w.Code, w.Header())
+	}
+	if w := patch(0, content[:half]); w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for a part at the wrong offset; got %d", http.StatusConflict, w.Code)
+	}
+	if offset() != strconv.Itoa(half) {
+		t.Errorf("Expected the first half to have arrived")
+	}
+	if _, err := ts.songs.FindPath("Resumed.mp3"); err == nil {
+		t.Errorf("Expected no song before the upload is complete")
+	}
+
+	if w := patch(half, content[half:]); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected the second half to be accepted; got %d %s", w.Code, w.Body.String())
+	}
+	if _, err := ts.songs.FindPath("Resumed.mp3"); err != nil {
+		t.Errorf("Expected the completed upload to be added to the library; got %v", err)
+	}
+
+	w = ts.get(location)
+	if p := decode[upload.Partial](t, w); len(p.Results) != 1 || p.Results[0].Status != upload.CREATED || p.Results[0].Song.Path != "Resumed.mp3" {
+		t.Errorf("Expected the result of the upload; got %s", w.Body.String())
+	}
+
+	if w := ts.tus("DELETE", location, nil, nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected the upload to be removed; got %d", w.Code)
+	}
+	if w := ts.tus("HEAD", location, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a removed upload; got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestResumableUploadRejected(t *testing.T) {
+	ts := setupTestServer(t)
+
+	w := ts.tus("POST", "/uploads", map[string]string{"Upload-Length": strconv.FormatInt(config.Default().Upload.MaxResumableSize+1, 10)}, nil)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d for a too large upload; got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	if w := ts.tus("POST", "/uploads", map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename !"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for invalid metadata; got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := ts.tus("HEAD", "/uploads/../../etc", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown upload; got %d", http.StatusNotFound, w.Code)
+	}
+
+	w = ts.tus("POST", "/uploads", map[string]string{"Upload-Length": "10"}, nil)
+	location := w.Header().Get("Location")
+	headers := map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}
+	if w := ts.tus("PATCH", location, map[string]string{"Upload-Offset": "0"}, []byte("0123456789")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status %d for a part of the wrong type; got %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+	if w := ts.tus("PATCH", location, headers, []byte("0123456789")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status %d for an upload that isn't audio; got %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+	if songs, _ := ts.songs.List(); len(songs) != 1 {
+		t.Errorf("Expected no songs to be added; got %+v", songs)
+	}
+}
+
+func TestSongAPI(t *testing.T) {
+	ts := setupTestServer(t)
+
+	w := ts.get("/api/v1/songs/1")
+	if w.Code != http.StatusOK || decode[model.Song](t, w).Slug != "recording" {
+		t.Fatalf("Expected the song with its slug; got %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Deprecation") != "" {
+		t.Errorf("Expected /api/v1 routes not to be deprecated")
+	}
+
+	w = ts.get("/api/v1/songs/slug/recording")
+	if w.Code != http.StatusOK || decode[model.Song](t, w).ID != 1 {
+		t.Errorf("Expected the song by its slug; got %d %s", w.Code, w.Body.String())
+	}
+
+	errors := map[string]struct {
+		status int
+		code   string
+	}{
+		"/api/v1/songs/100":          {http.StatusNotFound, "not_found"},
+		"/api/v1/songs/Recording":    {http.StatusBadRequest, "bad_request"},
+		"/api/v1/songs/slug/unknown": {http.StatusNotFound, "not_found"},
+		"/api/v1/songs/100/cover":    {http.StatusNotFound, "not_found"},
+		"/api/v1/nothing/here":       {http.StatusNotFound, "not_found"},
+		"/songs/Nothing%20like%20it": {http.StatusNotFound, "not_found"},
+	}
+	for target, expected := range errors {
+		w := ts.get(target)
+		body := decode[song_handler.APIError](t, w)
+		if w.Code != expected.status || body.Code != expected.code || body.Message == "" {
+			t.Errorf("%s: expected status %d with code %q; got %d %+v", target, expected.status, expected.code, w.Code, body)
+		}
+	}
+
+	// the deprecated routes still find songs by title
+	w = ts.get("/songs/Recording")
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "true" || !strings.Contains(w.Header().Get("Link"), "/api/v1") {
+		t.Errorf("Expected the song from a deprecated route; got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestUpdateSong(t *testing.T) {
+	ts := setupTestServer(t)
+	content, _ := os.ReadFile(TEST_SONG)
+	ts.upload("file", "Other.mp3", variant(content, "Other"))
+
+	patch := func(target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return ts.request(req)
+	}
+
+	w := patch("/api/v1/songs/1", `{"title": "Renamed Recording", "Artist": "Someone", "year": 2001}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d; got %d %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	song := decode[model.Song](t, w)
+	if song.Title != "Renamed Recording" || song.Slug != "renamed-recording" || song.Artist != "Someone" || song.ArtistID == 0 || song.Year != 2001 || song.Path != "Recording.mp3" {
+		t.Errorf("Expected the song to be changed; got %+v", song)
+	}
+	if stored, _ := ts.songs.Get(1); stored.Title != song.Title || stored.Slug != song.Slug || stored.ArtistID != song.ArtistID || stored.Year != song.Year {
+		t.Errorf("Expected the changes to be stored; got %+v", stored)
+	}
+
+	tests := map[string]struct {
+		target string
+		body   string
+		status int
+	}{
+		"taken title":   {"/api/v1/songs/1", `{"title": "Other"}`, http.StatusConflict},
+		"empty title":   {"/api/v1/songs/1", `{"title": " "}`, http.StatusBadRequest},
+		"negative year": {"/api/v1/songs/1", `{"year": -1}`, http.StatusBadRequest},
+		"unknown field": {"/api/v1/songs/1", `{"path": "/etc/passwd"}`, http.StatusBadRequest},
+		"not json":      {"/api/v1/songs/1", `title=x`, http.StatusBadRequest},
+		"missing song":  {"/api/v1/songs/100", `{"title": "x"}`, http.StatusNotFound},
+	}
+	for name, test := range tests {
+		w := patch(test.target, test.body)
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d; got %d %s", name, test.status, w.Code, w.Body.String())
+		}
+		if body := decode[song_handler.APIError](t, w); body.Code == "" || body.Message == "" {
+			t.Errorf("%s: expected an error envelope; got %s", name, w.Body.String())
+		}
+	}
+}
+
+func TestDeleteSong(t *testing.T) {
+	ts := setupTestServer(t)
+
+	req := httptest.NewRequest("DELETE", "/api/v1/songs/1", nil)
+	if w := ts.request(req); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d; got %d %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	if _, err := ts.store.Stat("Recording.mp3"); err == nil {
+		t.Errorf("Expected the song's file to be removed")
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v1/songs/1", nil)
+	if w := ts.request(req); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a removed song; got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestRemoveSong(t *testing.T) {
+	ts := setupTestServer(t)
+
+	w := ts.get("/remove/zzz")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d; got %d", http.StatusNotFound, w.Code)
+	}
+
+	w = ts.get("/remove/1")
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status %d; got %d", http.StatusOK, w.Code)
 	}
+	if !strings.Contains(w.Body.String(), "Recording") {
+		t.Errorf("Expected the removed song in the response; got %s", w.Body.String())
+	}
+
+	if songs, _ := ts.songs.List(); len(songs) != 0 {
+		t.Errorf("Expected the song to be removed; got %+v", songs)
+	}
+	if _, err := ts.store.Stat("Recording.mp3"); err == nil {
+		t.Errorf("Expected the song's file to be removed")
+	}
+
+	// a song whose file can't be removed is kept, and so is said
+	stuck := &model.Song{Title: "Stuck", FileType: "mp3", Path: "Stuck.mp3"}
+	ts.songs.Create(stuck)
+	os.MkdirAll(filepath.Join(ts.store.(*storage.Local).Root(), "Stuck.mp3", "inside"), 0o755)
+	w = ts.get(fmt.Sprintf("/remove/%d", stuck.ID))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d when the file can't be removed; got %d", http.StatusInternalServerError, w.Code)
+	}
+	if code := decode[song_handler.APIError](t, w).Code; code != "internal_error" {
+		t.Errorf("Expected error code internal_error; got %q", code)
+	}
+	if _, err := ts.songs.Get(stuck.ID); err != nil {
+		t.Errorf("Expected the song to be kept; got %v", err)
+	}
+}
+
+func TestStreamSong(t *testing.T) {
+	ts := setupTestServer(t)
+
+	content, err := os.ReadFile(TEST_SONG)
+	if err != nil {
+		t.Fatalf("Failed to read test song: %v", err)
+	}
+
+	w := ts.get("/songs/1/stream")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d; got %d", http.StatusOK, w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Expected the whole song to be served")
+	}
+	if w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Expected range requests to be accepted; got %q", w.Header().Get("Accept-Ranges"))
+	}
+	if w.Header().Get("Content-Length") != strconv.Itoa(len(content)) {
+		t.Errorf("Expected Content-Length %d; got %s", len(content), w.Header().Get("Content-Length"))
+	}
+	if w.Header().Get("Content-Type") != "audio/mpeg" {
+		t.Errorf("Expected Content-Type audio/mpeg; got %s", w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("Last-Modified") == "" {
+		t.Errorf("Expected a Last-Modified header")
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Expected an ETag header")
+	}
+
+	w = ts.get("/songs/zzz/stream")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing song; got %d", http.StatusNotFound, w.Code)
+	}
+
+	// seeking
+	req := httptest.NewRequest("GET", "/songs/1/stream", nil)
+	req.Header.Set("Range", "bytes=100-199")
+	w = ts.request(req)
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status %d; got %d", http.StatusPartialContent, w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), content[100:200]) {
+		t.Errorf("Expected the requested range to be served")
+	}
+	if w.Header().Get("Content-Range") != fmt.Sprintf("bytes 100-199/%d", len(content)) {
+		t.Errorf("Unexpected Content-Range %s", w.Header().Get("Content-Range"))
+	}
+
+	// resuming with a matching validator only sends the rest
+	req = httptest.NewRequest("GET", "/songs/1/stream", nil)
+	req.Header.Set("Range", "bytes=100-")
+	req.Header.Set("If-Range", etag)
+	w = ts.request(req)
+	if w.Code != http.StatusPartialContent || w.Body.Len() != len(content)-100 {
+		t.Errorf("Expected the rest of the song for a matching If-Range; got %d with %d bytes", w.Code, w.Body.Len())
+	}
+
+	// resuming after the file changed sends the whole file again
+	req = httptest.NewRequest("GET", "/songs/1/stream", nil)
+	req.Header.Set("Range", "bytes=100-")
+	req.Header.Set("If-Range", `"outdated"`)
+	w = ts.request(req)
+	if w.Code != http.StatusOK || w.Body.Len() != len(content) {
+		t.Errorf("Expected the whole song for a stale If-Range; got %d with %d bytes", w.Code, w.Body.Len())
+	}
+
+	// caching
+	req = httptest.NewRequest("GET", "/songs/1/stream", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = ts.request(req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d for a cached song; got %d", http.StatusNotModified, w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/songs/1/stream", nil)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(content)+10))
+	w = ts.request(req)
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Expected status %d for an unsatisfiable range; got %d", http.StatusRequestedRangeNotSatisfiable, w.Code)
+	}
+
+	w = ts.request(httptest.NewRequest("HEAD", "/songs/1/stream", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Length") != strconv.Itoa(len(content)) {
+		t.Errorf("Expected HEAD to report the song's length; got %d, %s", w.Code, w.Header().Get("Content-Length"))
+	}
+}
+
+func TestPlaySongICY(t *testing.T) {
+	ts := setupTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	req := httptest.NewRequest("GET", "/play/1", nil).WithContext(ctx)
+	req.Header.Set("Icy-MetaData", "1")
+	w := ts.request(req)
+
+	if w.Header().Get("icy-metaint") == "" {
+		t.Fatalf("Expected an icy-metaint header")
+	}
+	if w.Header().Get("icy-name") != "Infiniti" {
+		t.Errorf("Expected the station name; got %q", w.Header().Get("icy-name"))
+	}
+	if w.Header().Get("icy-br") == "" {
+		t.Errorf("Expected the bitrate")
+	}
+
+	metaint, _ := strconv.Atoi(w.Header().Get("icy-metaint"))
+	body := w.Body.Bytes()
+	if len(body) <= metaint {
+		t.Fatalf("Expected more than %d bytes to be streamed; got %d", metaint, len(body))
+	}
+
+	length := int(body[metaint]) * 16
+	metadata := string(body[metaint+1 : metaint+1+length])
+	if !strings.HasPrefix(metadata, "StreamTitle='Recording';") {
+		t.Errorf("Expected the song title in the stream; got %q", metadata)
+	}
+
+	// clients that don't ask for metadata get plain audio
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	w = ts.request(httptest.NewRequest("GET", "/play/1", nil).WithContext(ctx))
+	if w.Header().Get("icy-metaint") != "" {
+		t.Errorf("Expected no icy-metaint header without Icy-MetaData")
+	}
+}
+
+func TestCreateStation(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.songs.Create(&model.Song{Title: "Other", Artist: "Someone", FileType: "mp3", Path: "other.mp3"})
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"name": "office", "mode": "shuffle"}`, http.StatusCreated},
+		{`{"name": "office", "mode": "playlist", "songs": [1]}`, http.StatusConflict},
+		{`{"name": "mix", "mode": "playlist", "songs": [2, 1]}`, http.StatusCreated},
+		{`{"name": "someone", "mode": "filter", "artist": "someone"}`, http.StatusCreated},
+		{`{"name": "missing", "mode": "playlist", "songs": [3]}`, http.StatusBadRequest},
+		{`{"name": "empty", "mode": "playlist"}`, http.StatusBadRequest},
+		{`{"name": "any", "mode": "filter"}`, http.StatusBadRequest},
+		{`{"name": "odd", "mode": "random"}`, http.StatusBadRequest},
+		{`{"name": "Not Valid", "mode": "shuffle"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		w := ts.createStation(t, test.body)
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d; got %d", test.body, test.status, w.Code)
+		}
+	}
+
+	stations := decode[[]audiopipeline.StationInfo](t, ts.get("/stations"))
+	if len(stations) != 3 || stations[0].Name != "mix" {
+		t.Errorf("Expected the created stations ordered by name; got %+v", stations)
+	}
+}
+
+func TestGetStation(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.songs.Create(&model.Song{Title: "Other", Artist: "Someone", FileType: "mp3", Path: "other.mp3"})
+
+	ts.createStation(t, `{"name": "mix", "mode": "playlist", "songs": [1, 2]}`)
+
+	// the station goes on air right away, playing its first song
+	var station audiopipeline.StationInfo
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		w := ts.get("/stations/mix")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d; got %d", http.StatusOK, w.Code)
+		}
+		station = decode[audiopipeline.StationInfo](t, w)
+		if station.NowPlaying != nil {
+			break
+		}
+	}
+
+	if station.NowPlaying == nil || station.NowPlaying.Title != "Recording" {
+		t.Fatalf("Expected the first song to be playing; got %+v", station.NowPlaying)
+	}
+	if len(station.Upcoming) != audiopipeline.UPCOMING || station.Upcoming[0].Title != "Other" || station.Upcoming[1].Title != "Recording" {
+		t.Errorf("Expected the playlist to come up next on repeat; got %+v", station.Upcoming)
+	}
+
+	w := ts.get("/stations/none")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing station; got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestListenStation(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.createStation(t, `{"name": "office", "mode": "shuffle"}`)
+
+	// the queue loads its first track before anything is broadcast, which takes a while with the race detector
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	req := httptest.NewRequest("GET", "/stations/office/listen", nil).WithContext(ctx)
+	req.Header.Set("Icy-MetaData", "1")
+	w := ts.request(req)
+
+	if w.Body.Len() == 0 {
+		t.Errorf("Expected the station to be streamed")
+	}
+	if w.Header().Get("icy-name") != "Infiniti - office" {
+		t.Errorf("Expected the station name; got %q", w.Header().Get("icy-name"))
+	}
+
+	w = ts.get("/stations/none/listen")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing station; got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestDeleteStation(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.createStation(t, `{"name": "office", "mode": "shuffle"}`)
+
+	// listeners are disconnected when their station is deleted
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- ts.get("/stations/office/listen") }()
+
+	time.Sleep(100 * time.Millisecond)
+	w := ts.request(httptest.NewRequest("DELETE", "/stations/office", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d; got %d", http.StatusOK, w.Code)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the listener to be disconnected")
+	}
+
+	w = ts.request(httptest.NewRequest("DELETE", "/stations/office", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d when deleting twice; got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestStationQueue(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.songs.Create(&model.Song{Title: "Other", Artist: "Someone", FileType: "mp3", Path: "other.mp3"})
+	ts.createStation(t, `{"name": "office", "mode": "playlist", "songs": [1]}`)
+
+	enqueue := func(body string) *httptest.ResponseRecorder {
+		return ts.request(httptest.NewRequest("POST", "/stations/office/queue", strings.NewReader(body)))
+	}
+
+	w := enqueue(`{"id": 2}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d; got %d", http.StatusCreated, w.Code)
+	}
+	first := decode[audiopipeline.Entry](t, w)
+
+	w = enqueue(`{"title": "recording"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d; got %d", http.StatusCreated, w.Code)
+	}
+	second := decode[audiopipeline.Entry](t, w)
+
+	for body, status := range map[string]int{`{"id": 3}`: http.StatusNotFound, `{"title": "zzz"}`: http.StatusNotFound, `{}`: http.StatusBadRequest} {
+		if w := enqueue(body); w.Code != status {
+			t.Errorf("%s: expected status %d; got %d", body, status, w.Code)
+		}
+	}
+
+	queue := decode[[]audiopipeline.Entry](t, ts.get("/stations/office/queue"))
+	if len(queue) < 2 || queue[0].EntryID != first.EntryID || queue[1].EntryID != second.EntryID {
+		t.Fatalf("Expected the requested songs up next; got %+v", queue)
+	}
+
+	w = ts.request(httptest.NewRequest("PATCH", fmt.Sprintf("/stations/office/queue/%d", second.EntryID), strings.NewReader(`{"position": 0}`)))
+	queue = decode[[]audiopipeline.Entry](t, w)
+	if w.Code != http.StatusOK || queue[0].EntryID != second.EntryID {
+		t.Errorf("Expected the entry to be moved up; got %d, %+v", w.Code, queue)
+	}
+
+	w = ts.request(httptest.NewRequest("PATCH", fmt.Sprintf("/stations/office/queue/%d", second.EntryID), strings.NewReader(`{}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d without a position; got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = ts.request(httptest.NewRequest("DELETE", fmt.Sprintf("/stations/office/queue/%d", first.EntryID), nil))
+	queue = decode[[]audiopipeline.Entry](t, w)
+	if w.Code != http.StatusOK || queue[0].EntryID != second.EntryID || queue[1].Requested {
+		t.Errorf("Expected the entry to be removed; got %d, %+v", w.Code, queue)
+	}
+
+	w = ts.request(httptest.NewRequest("DELETE", fmt.Sprintf("/stations/office/queue/%d", first.EntryID), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d when removing twice; got %d", http.StatusNotFound, w.Code)
+	}
+
+	w = ts.get("/stations/none/queue")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing station; got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestStationControls(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.createStation(t, `{"name": "office", "mode": "shuffle"}`)
+
+	post := func(path string) int {
+		return ts.request(httptest.NewRequest("POST", path, nil)).Code
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		paused bool
+	}{
+		{"/stations/office/pause", http.StatusOK, true},
+		{"/stations/office/resume", http.StatusOK, false},
+		{"/stations/office/pause?mode=hold", http.StatusOK, true},
+		{"/stations/office/pause?mode=loud", http.StatusBadRequest, true},
+		{"/stations/office/skip", http.StatusOK, true},
+		{"/stations/office/resume", http.StatusOK, false},
+		{"/stations/none/skip", http.StatusNotFound, false},
+		{"/stations/none/pause", http.StatusNotFound, false},
+	}
+
+	for _, test := range tests {
+		if status := post(test.path); status != test.status {
+			t.Errorf("%s: expected status %d; got %d", test.path, test.status, status)
+		}
+
+		station := decode[audiopipeline.StationInfo](t, ts.get("/stations/office"))
+		if station.Paused != test.paused {
+			t.Errorf("%s: expected paused to be %v", test.path, test.paused)
+		}
+	}
+}
+
+// playlistSegments returns the segment URIs listed in an HLS playlist.
+func playlistSegments(playlist string) []string {
+	var segments []string
+	for _, line := range strings.Split(playlist, "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			segments = append(segments, line)
+		}
+	}
+	return segments
+}
+
+func TestSongHLS(t *testing.T) {
+	ts := setupTestServer(t)
+
+	w := ts.get("/songs/1/hls/index.m3u8")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/vnd.apple.mpegurl" {
+		t.Fatalf("Expected a playlist; got %d, %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	playlist := w.Body.String()
+	if !strings.HasPrefix(playlist, "#EXTM3U\n") || !strings.HasSuffix(playlist, "#EXT-X-ENDLIST\n") {
+		t.Errorf("Expected a VOD playlist; got\n%s", playlist)
+	}
+
+	segments := playlistSegments(playlist)
+	if len(segments) == 0 {
+		t.Fatalf("Expected segments in the playlist")
+	}
+
+	for _, segment := range segments {
+		w := ts.get("/songs/1/hls/" + segment)
+		if w.Code != http.StatusOK || !bytes.HasPrefix(w.Body.Bytes(), []byte("ID3")) {
+			t.Errorf("%s: expected a segment starting with its timestamp tag; got %d", segment, w.Code)
+		}
+	}
+
+	tests := map[string]int{
+		"/songs/1/hls/999.mp3":    http.StatusNotFound,
+		"/songs/1/hls/first.mp3":  http.StatusNotFound,
+		"/songs/zzz/hls/0.mp3":    http.StatusNotFound,
+		"/songs/2/hls/index.m3u8": http.StatusNotFound,
+	}
+	for path, status := range tests {
+		if w := ts.get(path); w.Code != status {
+			t.Errorf("%s: expected status %d; got %d", path, status, w.Code)
+		}
+	}
+}
+
+func TestStationHLS(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.createStation(t, `{"name": "office", "mode": "shuffle"}`)
+
+	// the first segment is cut once the station has broadcast a target duration ahead
+	var segments []string
+	for start := time.Now(); len(segments) == 0 && time.Since(start) < 10*time.Second; time.Sleep(100 * time.Millisecond) {
+		w := ts.get("/stations/office/hls/index.m3u8")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d; got %d", http.StatusOK, w.Code)
+		}
+		if strings.Contains(w.Body.String(), "#EXT-X-ENDLIST") || w.Header().Get("Cache-Control") != "no-cache" {
+			t.Fatalf("Expected an open live playlist")
+		}
+		segments = playlistSegments(w.Body.String())
+	}
+
+	if len(segments) == 0 {
+		t.Fatalf("Expected the live playlist to list segments")
+	}
+
+	w := ts.get("/stations/office/hls/" + segments[0])
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "audio/mpeg" {
+		t.Errorf("Expected the segment to be served; got %d", w.Code)
+	}
+
+	w = ts.get("/stations/none/hls/index.m3u8")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing station; got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestBrowseLibrary(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.songs.Create(&model.Song{Title: "Second Song", Artist: "Composer", Album: "Works", Track: 2})
+	ts.songs.Create(&model.Song{Title: "First Song", Artist: "composer feat. Soloist", Album: "works", Track: 1})
+
+	w := ts.get("/artists")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d; got %d", http.StatusOK, w.Code)
+	}
+
+	var composer model.Artist
+	for _, artist := range decode[[]model.Artist](t, w) {
+		if artist.Name == "Composer" {
+			composer = artist
+		}
+	}
+	if composer.ID == 0 {
+		t.Fatalf("Expected Composer to be listed once")
+	}
+
+	w = ts.get(fmt.Sprintf("/artists/%d/albums", composer.ID))
+	albums := decode[[]model.Album](t, w)
+	if len(albums) != 1 || albums[0].Title != "Works" {
+		t.Fatalf("Expected the album of Composer; got %+v", albums)
+	}
+
+	w = ts.get(fmt.Sprintf("/albums/%d/tracks", albums[0].ID))
+	tracks := decode[[]song_handler.AlbumTrack](t, w)
+	if len(tracks) != 2 || tracks[0].Title != "First Song" || tracks[1].Title != "Second Song" {
+		t.Fatalf("Expected the tracks in album order; got %+v", tracks)
+	}
+	if len(tracks[0].Featured) != 1 || tracks[0].Featured[0].Name != "Soloist" {
+		t.Errorf("Expected Soloist to be featured on the first track; got %+v", tracks[0].Featured)
+	}
+
+	tests := map[string]int{
+		"/albums":             http.StatusOK,
+		"/artists/100/albums": http.StatusNotFound,
+		"/artists/x/albums":   http.StatusNotFound,
+		"/albums/100/tracks":  http.StatusNotFound,
+	}
+	for path, status := range tests {
+		if w := ts.get(path); w.Code != status {
+			t.Errorf("%s: expected status %d; got %d", path, status, w.Code)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	ts := setupTestServer(t)
+
+	var picture bytes.Buffer
+	png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 64, 32)))
+	ts.store.Put("cover.png", &picture)
+	// seeding again picks up the cover of the songs already in the library
+	database.Seed(ts.songs, ts.store)
+
+	w := ts.get("/songs/1/cover")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Expected the cover to be served; got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("ETag") == "" || !strings.Contains(w.Header().Get("Cache-Control"), "max-age") {
+		t.Errorf("Expected caching headers; got %v", w.Header())
+	}
+
+	w = ts.get("/songs/1/cover?size=16")
+	resized, err := png.Decode(w.Body)
+	if err != nil || resized.Bounds().Dx() != 16 || resized.Bounds().Dy() != 8 {
+		t.Errorf("Expected the cover to be scaled down to 16x8; got %v, %v", resized, err)
+	}
+
+	req := httptest.NewRequest("GET", "/songs/1/cover?size=16", nil)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	if w := ts.request(req); w.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d for a cached cover; got %d", http.StatusNotModified, w.Code)
+	}
+
+	song, _ := ts.songs.Get(1)
+	ts.songs.Create(&model.Song{Title: "Album Song", Album: "Album", CoverHash: song.CoverHash})
+	ts.songs.Create(&model.Song{Title: "Plain Song"})
+	albums, _ := ts.library.Albums()
+
+	tests := map[string]int{
+		fmt.Sprintf("/albums/%d/cover", albums[0].ID): http.StatusOK,
+		"/albums/100/cover":                           http.StatusNotFound,
+		"/songs/1/cover?size=5":                       http.StatusBadRequest,
+		"/songs/1/cover?size=big":                     http.StatusBadRequest,
+		"/songs/3/cover":                              http.StatusNotFound,
+		"/songs/100/cover":                            http.StatusNotFound,
+	}
+	for path, status := range tests {
+		if w := ts.get(path); w.Code != status {
+			t.Errorf("%s: expected status %d; got %d", path, status, w.Code)
+		}
+	}
+}
+
+// jsonRequest returns a request with the JSON body, sent with the token if there is one.
+func jsonRequest(method string, target string, body string, token string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestAuthenticate(t *testing.T) {
+	ts := setupTestServer(t)
+
+	if w := ts.anonymous(httptest.NewRequest("GET", "/api/v1/songs", nil)); w.Code != http.StatusOK {
+		t.Errorf("Expected the songs to be listed without credentials; got %d", w.Code)
+	}
+
+	upload := httptest.NewRequest("POST", "/api/v1/uploads", nil)
+	upload.Header.Set("Tus-Resumable", "1.0.0")
+	protected := []*http.Request{
+		httptest.NewRequest("POST", "/api/v1/songs", nil),
+		httptest.NewRequest("DELETE", "/api/v1/songs/1", nil),
+		httptest.NewRequest("POST", "/api/v1/stations", nil),
+		upload,
+		httptest.NewRequest("GET", "/remove/1", nil),
+		httptest.NewRequest("GET", "/api/v1/auth/me", nil),
+	}
+	for _, req := range protected {
+		w := ts.anonymous(req)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s: expected status %d with a challenge; got %d %v", req.Method, req.URL, http.StatusUnauthorized, w.Code, w.Header())
+		}
+		if apiErr := decode[song_handler.APIError](t, w); apiErr.Code != "unauthorized" {
+			t.Errorf("%s %s: expected the unauthorized error code; got %+v", req.Method, req.URL, apiErr)
+		}
+	}
+	if _, err := ts.songs.Get(1); err != nil {
+		t.Errorf("Expected the song to be left alone; got %v", err)
+	}
+
+	user, _ := ts.users.FindUser("tester")
+	expired, _ := ts.auth.Token(user, time.Now().Add(-48*time.Hour))
+	valid, _ := ts.auth.Token(user, time.Now())
+	tampered := valid[:len(valid)-2] + "xx"
+	for name, token := range map[string]string{"expired": expired, "tampered": tampered, "unknown key": auth.KEYPREFIX + "nope", "garbage": "a.b.c"} {
+		if w := ts.anonymous(jsonRequest("GET", "/api/v1/auth/me", "", token)); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d for a %s token; got %d", http.StatusUnauthorized, name, w.Code)
+		}
+	}
+
+	w := ts.anonymous(jsonRequest("GET", "/api/v1/auth/me", "", valid))
+	if w.Code != http.StatusOK || decode[model.User](t, w).Username != "tester" {
+		t.Errorf("Expected the user of the token; got %d %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "PasswordHash") {
+		t.Errorf("Expected the password hash to be left out; got %s", w.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/auth/me", nil)
+	req.Header.Set("X-API-Key", ts.key)
+	if w := ts.anonymous(req); w.Code != http.StatusOK {
+		t.Errorf("Expected the API key to be accepted in X-API-Key; got %d", w.Code)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ts := setupTestServer(t)
+
+	tests := map[string]int{
+		`{"username": "newcomer", "password": "long enough"}`: http.StatusForbidden,
+		`{"username": "x", "password": "long enough"}`:        http.StatusBadRequest,
+		`{"username": "newcomer", "password": "short"}`:       http.StatusBadRequest,
+		`not json`: http.StatusBadRequest,
+	}
+	for body, status := range tests {
+		if w := ts.anonymous(jsonRequest("POST", "/api/v1/auth/register", body, "")); w.Code != status {
+			t.Errorf("Expected status %d registering %s; got %d", status, body, w.Code)
+		}
+	}
+
+	// the cheapest cost keeps the test fast, logins compare with whatever cost the hash has
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	ts.users.CreateUser(&model.User{Username: "alice", PasswordHash: string(hash), Role: auth.UPLOADER})
+
+	for _, body := range []string{`{"username": "alice", "password": "wrong horse"}`, `{"username": "bob", "password": "correct horse"}`} {
+		if w := ts.anonymous(jsonRequest("POST", "/api/v1/auth/login", body, "")); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d logging in with %s; got %d", http.StatusUnauthorized, body, w.Code)
+		}
+	}
+
+	w := ts.anonymous(jsonRequest("POST", "/api/v1/auth/login", `{"username": "Alice", "password": "correct horse"}`, ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d logging in; got %d %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	session := decode[song_handler.Session](t, w)
+	if session.Token == "" || session.User.Username != "alice" || !session.Expires.After(time.Now().Add(23*time.Hour)) {
+		t.Errorf("Expected a session for alice lasting a day; got %+v", session)
+	}
+
+	w = ts.anonymous(jsonRequest("PATCH", "/api/v1/songs/1", `{"title": "Renamed"}`, session.Token))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the session token to be accepted; got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	ts := setupTestServer(t)
+
+	if w := ts.request(jsonRequest("POST", "/api/v1/auth/keys", `{"name": " "}`, "")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a key without a name; got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w := ts.request(jsonRequest("POST", "/api/v1/auth/keys", `{"name": "backup script"}`, ""))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d; got %d %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	created := decode[song_handler.NewAPIKey](t, w)
+	if !strings.HasPrefix(created.Key, auth.KEYPREFIX) || !strings.HasPrefix(created.Key, created.APIKey.Prefix) || created.APIKey.Name != "backup script" {
+		t.Errorf("Expected a named key starting with its prefix; got %+v", created)
+	}
+
+	w = ts.request(jsonRequest("GET", "/api/v1/auth/keys", "", created.Key))
+	keys := decode[[]model.APIKey](t, w)
+	if len(keys) != 2 || strings.Contains(w.Body.String(), created.Key) {
+		t.Errorf("Expected both keys of the user, without the keys themselves; got %s", w.Body.String())
+	}
+
+	target := fmt.Sprintf("/api/v1/auth/keys/%d", created.APIKey.ID)
+	if w := ts.request(httptest.NewRequest("DELETE", target, nil)); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d deleting the key; got %d", http.StatusNoContent, w.Code)
+	}
+	if w := ts.request(jsonRequest("GET", "/api/v1/auth/me", "", created.Key)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the deleted key to be refused; got %d", w.Code)
+	}
+	for _, target := range []string{target, "/api/v1/auth/keys/abc"} {
+		if w := ts.request(httptest.NewRequest("DELETE", target, nil)); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status %d; got %d", target, http.StatusNotFound, w.Code)
+		}
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	ts := setupTestServer(t)
+	listener, _ := ts.as(t, "listener", auth.LISTENER)
+	uploader, _ := ts.as(t, "uploader", auth.UPLOADER)
+
+	tests := []struct {
+		server *testServer
+		method string
+		target string
+		status int
+	}{
+		{listener, "GET", "/api/v1/songs", http.StatusOK},
+		{listener, "GET", "/api/v1/stations", http.StatusOK},
+		{listener, "PATCH", "/api/v1/songs/1", http.StatusForbidden},
+		{listener, "DELETE", "/api/v1/songs/1", http.StatusForbidden},
+		{listener, "POST", "/api/v1/stations/nowhere/skip", http.StatusForbidden},
+		{listener, "GET", "/api/v1/users", http.StatusForbidden},
+		{listener, "GET", "/api/v1/auth/me", http.StatusOK},
+		{uploader, "POST", "/api/v1/stations/nowhere/skip", http.StatusNotFound},
+		{uploader, "GET", "/api/v1/roles", http.StatusForbidden},
+		{ts, "GET", "/api/v1/roles", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := test.server.request(httptest.NewRequest(test.method, test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected status %d; got %d", test.method, test.target, test.status, w.Code)
+		}
+	}
+
+	w := listener.request(httptest.NewRequest("DELETE", "/api/v1/stations/nowhere", nil))
+	apiErr := decode[song_handler.APIError](t, w)
+	details, _ := apiErr.Details.(map[string]any)
+	if apiErr.Code != "forbidden" || details["permission"] != auth.STATIONSCONTROL || details["role"] != auth.LISTENER {
+		t.Errorf("Expected the missing permission and the role; got %+v", apiErr)
+	}
+	if !strings.Contains(apiErr.Message, auth.STATIONSCONTROL) {
+		t.Errorf("Expected the message to name the permission; got %q", apiErr.Message)
+	}
+}
+
+func TestSongOwnership(t *testing.T) {
+	ts := setupTestServer(t)
+	uploader, user := ts.as(t, "uploader", auth.UPLOADER)
+	other, _ := ts.as(t, "other", auth.UPLOADER)
+	content, _ := os.ReadFile(TEST_SONG)
+
+	w := uploader.uploadTo("/api/v1/songs", "file", uploadFile{"Owned.mp3", variant(content, "Owned")})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d; got %d %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	owned := decode[model.Song](t, w)
+	if owned.OwnerID != user.ID {
+		t.Errorf("Expected the uploader to own the song; got %d", owned.OwnerID)
+	}
+
+	w = uploader.tus("POST", "/api/v1/uploads", map[string]string{"Upload-Length": strconv.Itoa(len(content) + 128), "Upload-Metadata": "filename UmVzdW1lZC5tcDM="}, nil)
+	location := w.Header().Get("Location")
+	uploader.tus("PATCH", location, map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}, variant(content, "Resumed"))
+	if resumed, err := ts.songs.Find("Resumed"); err != nil || resumed.OwnerID != user.ID {
+		t.Errorf("Expected the uploader to own the resumed song; got %+v, %v", resumed, err)
+	}
+
+	// the seeded song has no owner, so only admins may delete it
+	tests := []struct {
+		server *testServer
+		target string
+		status int
+	}{
+		{uploader, "/api/v1/songs/1", http.StatusForbidden},
+		{other, fmt.Sprintf("/api/v1/songs/%d", owned.ID), http.StatusForbidden},
+		{uploader, fmt.Sprintf("/api/v1/songs/%d", owned.ID), http.StatusNoContent},
+		{ts, "/api/v1/songs/1", http.StatusNoContent},
+	}
+	for _, test := range tests {
+		if w := test.server.request(httptest.NewRequest("DELETE", test.target, nil)); w.Code != test.status {
+			t.Errorf("DELETE %s: expected status %d; got %d %s", test.target, test.status, w.Code, w.Body.String())
+		}
+	}
+
+	resumed, _ := ts.songs.Find("Resumed")
+	if w := other.request(httptest.NewRequest("GET", fmt.Sprintf("/remove/%d", resumed.ID), nil)); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the deprecated route to check the owner too; got %d", w.Code)
+	}
+	if _, err := ts.songs.Get(resumed.ID); err != nil {
+		t.Errorf("Expected the song to be left alone; got %v", err)
+	}
+}
+
+func TestUserAdmin(t *testing.T) {
+	ts := setupTestServer(t)
+	admin, _ := ts.users.FindUser("tester")
+
+	w := ts.request(httptest.NewRequest("GET", "/api/v1/roles", nil))
+	if roles := decode[[]auth.Role](t, w); len(roles) != 3 || roles[0].Name != auth.ADMIN {
+		t.Errorf("Expected the three roles, admin first; got %+v", roles)
+	}
+
+	// in order, as bob must exist before he is created again
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"username": "bob", "password": "correct horse", "role": "uploader"}`, http.StatusCreated},
+		{`{"username": "bob", "password": "correct horse"}`, http.StatusConflict},
+		{`{"username": "carol", "password": "correct horse", "role": "owner"}`, http.StatusBadRequest},
+		{`{"username": "carol", "password": "short"}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if w := ts.request(jsonRequest("POST", "/api/v1/users", test.body, "")); w.Code != test.status {
+			t.Errorf("Expected status %d creating %s; got %d %s", test.status, test.body, w.Code, w.Body.String())
+		}
+	}
+
+	w = ts.request(httptest.NewRequest("GET", "/api/v1/users", nil))
+	users := decode[[]model.User](t, w)
+	if len(users) != 2 || users[1].Username != "bob" || users[1].Role != auth.UPLOADER {
+		t.Fatalf("Expected tester and bob the uploader; got %+v", users)
+	}
+	bob := users[1]
+	bobKey, _, _ := ts.auth.CreateKey(&bob, "tests")
+	target := fmt.Sprintf("/api/v1/users/%d", bob.ID)
+
+	w = ts.request(jsonRequest("PATCH", target, `{"role": "listener"}`, ""))
+	if w.Code != http.StatusOK || decode[model.User](t, w).Role != auth.LISTENER {
+		t.Errorf("Expected bob to be a listener; got %d %s", w.Code, w.Body.String())
+	}
+	if w := ts.anonymous(jsonRequest("POST", "/api/v1/stations", `{"name": "bob"}`, bobKey)); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the new role to apply at once; got %d", w.Code)
+	}
+
+	self := fmt.Sprintf("/api/v1/users/%d", admin.ID)
+	if w := ts.request(jsonRequest("PATCH", self, `{"role": "uploader"}`, "")); w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d demoting the last admin; got %d", http.StatusConflict, w.Code)
+	}
+	if w := ts.request(httptest.NewRequest("DELETE", self, nil)); w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d deleting the last admin; got %d", http.StatusConflict, w.Code)
+	}
+	if w := ts.request(jsonRequest("PATCH", target, `{"role": "root"}`, "")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown role; got %d", http.StatusBadRequest, w.Code)
+	}
+
+	if w := ts.request(httptest.NewRequest("DELETE", target, nil)); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d deleting bob; got %d", http.StatusNoContent, w.Code)
+	}
+	for _, path := range []string{target, "/api/v1/users/abc"} {
+		if w := ts.request(httptest.NewRequest("GET", path, nil)); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status %d; got %d", path, http.StatusNotFound, w.Code)
+		}
+	}
+	if w := ts.anonymous(jsonRequest("GET", "/api/v1/auth/me", "", bobKey)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the key of a deleted user to be refused; got %d", w.Code)
+	}
 }